@@ -0,0 +1,214 @@
+package breaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+)
+
+type Config struct {
+	// Next is a function to skip middleware based on some condition
+	Next func(c *volta.Ctx) bool
+
+	// KeyGenerator returns the key a circuit is kept for, e.g. the downstream dependency
+	KeyGenerator func(c *volta.Ctx) string
+
+	// Window is the rolling window failures are counted over
+	Window time.Duration
+
+	// Buckets is the number of slices the window is divided into
+	Buckets int
+
+	// FailureThreshold is the number of failures within the window opening the circuit
+	FailureThreshold int
+
+	// FailureRatio opens the circuit when this share of the messages within the window failed,
+	// once at least MinRequests were handled. 0 disables it
+	FailureRatio float64
+
+	// MinRequests is the number of messages needed before FailureRatio applies
+	MinRequests int
+
+	// OpenTimeout is how long the circuit stays open before trial messages are let through
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of trial messages that have to succeed to close the circuit
+	HalfOpenRequests int
+
+	// IsFailure decides whether the error returned by the chain counts as a failure
+	IsFailure func(c *volta.Ctx, err error) bool
+
+	// OnOpen is called instead of the chain while the circuit is open
+	OnOpen volta.Handler
+
+	// OnStateChange is called when the circuit of a key changes its state,
+	// after the middleware released its lock
+	OnStateChange func(key string, from, to State)
+}
+
+var ConfigDefault = Config{
+	Next:             nil,
+	KeyGenerator:     RoutingKey,
+	Window:           time.Minute,
+	Buckets:          10,
+	FailureThreshold: 5,
+	FailureRatio:     0,
+	MinRequests:      10,
+	OpenTimeout:      30 * time.Second,
+	HalfOpenRequests: 1,
+	IsFailure:        func(_ *volta.Ctx, err error) bool { return err != nil },
+	OnOpen:           NackWithDelay(time.Second),
+	OnStateChange:    nil,
+}
+
+// RoutingKey keeps one circuit per routing key
+func RoutingKey(c *volta.Ctx) string {
+	return c.RoutingKey()
+}
+
+// Requeue negatively acknowledges the message and puts it back into the queue right away
+func Requeue(c *volta.Ctx) error {
+	return c.Nack(false, true)
+}
+
+// NackWithDelay holds the message for delay before requeueing it,
+// so an open circuit does not turn into a tight redelivery loop.
+// The message is requeued right away once ctx.Context() is done.
+func NackWithDelay(delay time.Duration) volta.Handler {
+	return func(c *volta.Ctx) error {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-c.Context().Done():
+		}
+
+		return c.Nack(false, true)
+	}
+}
+
+// ParkOptions controls how Park publishes the message
+type ParkOptions struct {
+	// Timeout bounds the publishing, the message is requeued when it expires
+	Timeout time.Duration
+}
+
+var ParkOptionsDefault = ParkOptions{
+	Timeout: 10 * time.Second,
+}
+
+// Park moves the message to another exchange, e.g. a parking queue drained once the dependency is back,
+// and acknowledges it. Properties and headers are kept.
+func Park(exchange, routingKey string, options ...ParkOptions) volta.Handler {
+	opts := ParkOptionsDefault
+	if len(options) > 0 && options[0].Timeout > 0 {
+		opts.Timeout = options[0].Timeout
+	}
+
+	return func(c *volta.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.Context(), opts.Timeout)
+		defer cancel()
+
+		headers := amqp091.Table{}
+		for key, value := range c.Delivery.Headers {
+			headers[key] = value
+		}
+		headers["x-volta-parked-from"] = c.RoutingKey()
+
+		err := c.Channel.PublishWithContext(ctx, exchange, routingKey, false, false, amqp091.Publishing{
+			Headers:         headers,
+			ContentType:     c.Delivery.ContentType,
+			ContentEncoding: c.Delivery.ContentEncoding,
+			DeliveryMode:    c.Delivery.DeliveryMode,
+			Priority:        c.Delivery.Priority,
+			CorrelationId:   c.Delivery.CorrelationId,
+			ReplyTo:         c.Delivery.ReplyTo,
+			Expiration:      c.Delivery.Expiration,
+			MessageId:       c.Delivery.MessageId,
+			Timestamp:       c.Delivery.Timestamp,
+			Type:            c.Delivery.Type,
+			UserId:          c.Delivery.UserId,
+			AppId:           c.Delivery.AppId,
+			Body:            c.Delivery.Body,
+		})
+		if err != nil {
+			return c.Nack(false, true)
+		}
+
+		return c.Ack(false)
+	}
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	cfg := config[0]
+
+	if cfg.KeyGenerator == nil {
+		cfg.KeyGenerator = ConfigDefault.KeyGenerator
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = ConfigDefault.Window
+	}
+	if cfg.Buckets <= 0 {
+		cfg.Buckets = ConfigDefault.Buckets
+	}
+	if cfg.FailureThreshold <= 0 && cfg.FailureRatio <= 0 {
+		cfg.FailureThreshold = ConfigDefault.FailureThreshold
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = ConfigDefault.MinRequests
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = ConfigDefault.OpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = ConfigDefault.HalfOpenRequests
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = ConfigDefault.IsFailure
+	}
+	if cfg.OnOpen == nil {
+		cfg.OnOpen = ConfigDefault.OnOpen
+	}
+
+	return cfg
+}
+
+// New creates a circuit breaker middleware. Every key has its own circuit which opens when the
+// chain fails too often, short-circuits messages to OnOpen while open, and closes again once
+// trial messages succeed after OpenTimeout. Circuits of keys unused for a Window are forgotten.
+func New(config ...Config) volta.Handler {
+	cfg := configDefault(config...)
+	circuits := newCircuits(cfg)
+
+	return func(c *volta.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		key := cfg.KeyGenerator(c)
+		if !circuits.allow(key) {
+			return cfg.OnOpen(c)
+		}
+
+		// A panicking chain counts as a failure, so a half-open circuit cannot get stuck
+		panicked := true
+		defer func() {
+			if panicked {
+				circuits.record(key, false)
+			}
+		}()
+
+		err := c.Next()
+		panicked = false
+		circuits.record(key, !cfg.IsFailure(c, err))
+
+		return err
+	}
+}
@@ -0,0 +1,256 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/voltatest"
+)
+
+func TestCircuits(t *testing.T) {
+	now := time.Now()
+	var changes []State
+
+	var circuits *circuits
+	circuits = newCircuits(configDefault(Config{
+		FailureThreshold: 3,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenRequests: 2,
+		OnStateChange: func(key string, _, to State) {
+			// The callback runs without the lock, it may use the circuits
+			if state := circuits.state(key); state != to {
+				t.Errorf("State is %s in the callback, expected %s", state, to)
+			}
+			changes = append(changes, to)
+		},
+	}))
+	circuits.now = func() time.Time { return now }
+
+	fail := func(key string) {
+		if circuits.allow(key) {
+			circuits.record(key, false)
+		}
+	}
+
+	// TEST: the circuit opens after the threshold of failures
+	fail("a")
+	fail("a")
+	if circuits.state("a") != Closed {
+		t.Fatalf("State is %s, expected closed below the threshold", circuits.state("a"))
+	}
+
+	fail("a")
+	if circuits.state("a") != Open || circuits.allow("a") {
+		t.Fatalf("State is %s, expected open and rejecting", circuits.state("a"))
+	}
+
+	// TEST: other keys are not affected
+	if !circuits.allow("b") {
+		t.Error("Another key was rejected")
+	}
+
+	// TEST: after the timeout only the trial messages pass
+	now = now.Add(10 * time.Second)
+	if !circuits.allow("a") || !circuits.allow("a") || circuits.allow("a") {
+		t.Fatal("Expected exactly two trial messages in half-open state")
+	}
+
+	// TEST: a failed trial opens the circuit again
+	circuits.record("a", false)
+	if circuits.state("a") != Open {
+		t.Fatalf("State is %s, expected open after a failed trial", circuits.state("a"))
+	}
+
+	// TEST: successful trials close the circuit
+	now = now.Add(10 * time.Second)
+	circuits.allow("a")
+	circuits.allow("a")
+	circuits.record("a", true)
+	circuits.record("a", true)
+	if circuits.state("a") != Closed {
+		t.Fatalf("State is %s, expected closed after successful trials", circuits.state("a"))
+	}
+
+	expected := []State{Open, HalfOpen, Open, HalfOpen, Closed}
+	if len(changes) != len(expected) {
+		t.Fatalf("State changes are %v, expected %v", changes, expected)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("State changes are %v, expected %v", changes, expected)
+			break
+		}
+	}
+}
+
+func TestCircuits_rollingWindow(t *testing.T) {
+	now := time.Now()
+	circuits := newCircuits(configDefault(Config{FailureThreshold: 3, Window: 10 * time.Second, Buckets: 10}))
+	circuits.now = func() time.Time { return now }
+
+	// TEST: failures older than the window are forgotten
+	circuits.record("a", false)
+	circuits.record("a", false)
+	now = now.Add(11 * time.Second)
+	circuits.record("a", false)
+
+	if circuits.state("a") != Closed {
+		t.Errorf("State is %s, expected closed", circuits.state("a"))
+	}
+}
+
+func TestCircuits_failureRatio(t *testing.T) {
+	circuits := newCircuits(configDefault(Config{FailureRatio: 0.5, MinRequests: 4}))
+
+	circuits.record("a", false)
+	circuits.record("a", false)
+	circuits.record("a", true)
+	if circuits.state("a") != Closed {
+		t.Fatalf("State is %s, expected closed below MinRequests", circuits.state("a"))
+	}
+
+	circuits.record("a", true)
+	if circuits.state("a") != Open {
+		t.Errorf("State is %s, expected open at a 50%% failure ratio", circuits.state("a"))
+	}
+}
+
+func TestCircuits_sweep(t *testing.T) {
+	now := time.Now()
+	circuits := newCircuits(configDefault(Config{FailureThreshold: 1, Window: 10 * time.Second, OpenTimeout: time.Minute}))
+	circuits.now = func() time.Time { return now }
+
+	circuits.record("closed", true)
+	circuits.record("open", false)
+
+	// TEST: idle closed circuits are dropped, an open circuit is kept until its timeout passed
+	now = now.Add(10 * time.Second)
+	circuits.record("other", true)
+
+	if _, ok := circuits.circuits["closed"]; ok {
+		t.Error("Idle closed circuit was not dropped")
+	}
+	if circuits.state("open") != Open {
+		t.Fatalf("State is %s, expected open", circuits.state("open"))
+	}
+
+	now = now.Add(time.Minute)
+	circuits.record("other", true)
+
+	if len(circuits.circuits) != 1 {
+		t.Errorf("%d circuits are kept, expected 1", len(circuits.circuits))
+	}
+}
+
+func TestNew(t *testing.T) {
+	failed := errors.New("failed")
+	opened := 0
+
+	middleware := New(Config{
+		FailureThreshold: 2,
+		OnOpen: func(c *volta.Ctx) error {
+			opened++
+			return Requeue(c)
+		},
+	})
+
+	handled := 0
+	handler := func(c *volta.Ctx) error {
+		handled++
+		return failed
+	}
+
+	message := func() *voltatest.Message {
+		return voltatest.NewMessage([]byte("test")).RoutingKey("payments")
+	}
+
+	// TEST: errors of the chain are returned and counted
+	for i := 0; i < 2; i++ {
+		if result := voltatest.Run(message(), middleware, handler); !errors.Is(result.Err, failed) {
+			t.Errorf("Error is %v, expected %v", result.Err, failed)
+		}
+	}
+
+	// TEST: an open circuit hands the message to OnOpen without running the chain
+	result := voltatest.Run(message(), middleware, handler)
+	if result.Err != nil || !result.Nacked() || !result.Requeue {
+		t.Errorf("Result is %v, %s, expected a requeue", result.Err, result.Settlement)
+	}
+
+	if handled != 2 || opened != 1 {
+		t.Errorf("Chain ran %d times and OnOpen %d times, expected 2 and 1", handled, opened)
+	}
+
+	// TEST: a panic counts as a failure and is passed on
+	panicking := New(Config{FailureThreshold: 1, OnOpen: Requeue})
+	result = voltatest.Run(message(), panicking, func(c *volta.Ctx) error { panic("boom") })
+	if result.Panic == nil {
+		t.Error("Panic was not passed on")
+	}
+
+	if result := voltatest.Run(message(), panicking, handler); !result.Nacked() {
+		t.Errorf("Settlement is %s after a panic, expected nack", result.Settlement)
+	}
+}
+
+func TestNackWithDelay(t *testing.T) {
+	cancelled := func(c *volta.Ctx) error {
+		ctx, cancel := context.WithCancel(c.Context())
+		cancel()
+
+		c.SetContext(ctx)
+		return c.Next()
+	}
+
+	// TEST: a done context requeues the message without waiting for the delay
+	start := time.Now()
+	result := voltatest.Run(voltatest.NewMessage([]byte("test")), cancelled, NackWithDelay(time.Hour))
+	if !result.Nacked() || !result.Requeue || time.Since(start) > time.Second {
+		t.Errorf("Result is %s after %s, expected a requeue right away", result.Settlement, time.Since(start))
+	}
+}
+
+func TestPark(t *testing.T) {
+	tester := voltatest.New()
+	if err := tester.Declare(volta.Exchange{Name: "parking", Type: "topic"}); err != nil {
+		t.Fatalf("Tester.Declare() error = %v", err)
+	}
+
+	message := voltatest.NewMessage([]byte("test")).RoutingKey("payments").MessageId("1").Header("tenant", "a")
+
+	// TEST: the message is published to the parking exchange with its properties and acked
+	result := tester.Run(message, Park("parking", "payments.parked"))
+	if result.Err != nil || !result.Acked() {
+		t.Fatalf("Result is %v, %s, expected acked", result.Err, result.Settlement)
+	}
+
+	if len(result.Published) != 1 {
+		t.Fatalf("%d messages were published, expected 1", len(result.Published))
+	}
+
+	parked := result.Published[0]
+	if parked.Exchange != "parking" || parked.RoutingKey != "payments.parked" || parked.MessageId != "1" {
+		t.Errorf("Parked message is %s %s %s, expected parking payments.parked 1", parked.Exchange, parked.RoutingKey, parked.MessageId)
+	}
+
+	if parked.Headers["tenant"] != "a" || parked.Headers["x-volta-parked-from"] != "payments" {
+		t.Errorf("Parked headers are %v", parked.Headers)
+	}
+
+	// TEST: the message is requeued when it cannot be published in time
+	ctx := tester.Ctx(message)
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.SetContext(expired)
+
+	if err := Park("parking", "payments.parked", ParkOptions{Timeout: time.Second})(ctx); err != nil {
+		t.Errorf("Park() error = %v", err)
+	}
+
+	if ctx.Settlement() != volta.SettlementNack {
+		t.Errorf("Settlement is %s, expected nack", ctx.Settlement())
+	}
+}
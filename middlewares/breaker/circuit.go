@@ -0,0 +1,238 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit
+type State int
+
+const (
+	// Closed lets every message through and counts the failures
+	Closed State = iota
+
+	// Open short-circuits every message to OnOpen
+	Open
+
+	// HalfOpen lets a limited number of trial messages through
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// counts is the number of outcomes within one slice of the rolling window
+type counts struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+type circuit struct {
+	state    State
+	openedAt time.Time
+	usedAt   time.Time
+	buckets  []counts
+
+	// Trial messages of the half-open state
+	trials    int
+	succeeded int
+}
+
+// stateChange is a transition reported to OnStateChange once the mutex is released
+type stateChange struct {
+	key      string
+	from, to State
+}
+
+// circuits holds the circuits of one middleware
+type circuits struct {
+	config Config
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+	sweptAt  time.Time
+	now      func() time.Time
+}
+
+func newCircuits(config Config) *circuits {
+	return &circuits{config: config, circuits: make(map[string]*circuit), now: time.Now}
+}
+
+func (c *circuits) get(key string, now time.Time) *circuit {
+	c.sweep(now)
+
+	cb, ok := c.circuits[key]
+	if !ok {
+		cb = &circuit{buckets: make([]counts, c.config.Buckets)}
+		c.circuits[key] = cb
+	}
+	cb.usedAt = now
+
+	return cb
+}
+
+// sweep drops the circuits unused for a window once per window, so keys that are no longer seen do not pile up.
+// Only circuits that would let the next message through are dropped, their counts are outdated anyway.
+func (c *circuits) sweep(now time.Time) {
+	if now.Sub(c.sweptAt) < c.config.Window {
+		return
+	}
+	c.sweptAt = now
+
+	for key, cb := range c.circuits {
+		if now.Sub(cb.usedAt) < c.config.Window {
+			continue
+		}
+		if cb.state == Closed || cb.state == Open && now.Sub(cb.openedAt) >= c.config.OpenTimeout {
+			delete(c.circuits, key)
+		}
+	}
+}
+
+// notify calls OnStateChange, it must be called without the mutex so the callback may use the middleware
+func (c *circuits) notify(changes []stateChange) {
+	if c.config.OnStateChange == nil {
+		return
+	}
+
+	for _, change := range changes {
+		c.config.OnStateChange(change.key, change.from, change.to)
+	}
+}
+
+// allow reports whether a message of key may run the chain
+func (c *circuits) allow(key string) bool {
+	var changes []stateChange
+	defer func() { c.notify(changes) }()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	cb := c.get(key, now)
+
+	if cb.state == Open && now.Sub(cb.openedAt) >= c.config.OpenTimeout {
+		changes = append(changes, c.transition(key, cb, HalfOpen, now))
+	}
+
+	switch cb.state {
+	case Open:
+		return false
+	case HalfOpen:
+		if cb.trials >= c.config.HalfOpenRequests {
+			return false
+		}
+		cb.trials++
+	}
+
+	return true
+}
+
+// record counts the outcome of a message of key and moves the circuit accordingly
+func (c *circuits) record(key string, success bool) {
+	var changes []stateChange
+	defer func() { c.notify(changes) }()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	cb := c.get(key, now)
+
+	switch cb.state {
+	case HalfOpen:
+		if !success {
+			changes = append(changes, c.transition(key, cb, Open, now))
+			return
+		}
+
+		cb.succeeded++
+		if cb.succeeded >= c.config.HalfOpenRequests {
+			changes = append(changes, c.transition(key, cb, Closed, now))
+		}
+	case Closed:
+		b := c.bucket(cb, now)
+		if success {
+			b.successes++
+		} else {
+			b.failures++
+		}
+
+		if c.tripped(cb, now) {
+			changes = append(changes, c.transition(key, cb, Open, now))
+		}
+	}
+}
+
+// bucket returns the slice of the window now falls into, recycling an outdated one
+func (c *circuits) bucket(cb *circuit, now time.Time) *counts {
+	size := c.config.Window / time.Duration(c.config.Buckets)
+	start := now.Truncate(size)
+	b := &cb.buckets[int(start.UnixNano()/int64(size))%len(cb.buckets)]
+
+	if !b.start.Equal(start) {
+		*b = counts{start: start}
+	}
+
+	return b
+}
+
+// tripped reports whether the failures within the window exceed the thresholds
+func (c *circuits) tripped(cb *circuit, now time.Time) bool {
+	var successes, failures int
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) < c.config.Window {
+			successes += b.successes
+			failures += b.failures
+		}
+	}
+
+	if c.config.FailureThreshold > 0 && failures >= c.config.FailureThreshold {
+		return true
+	}
+
+	total := successes + failures
+	if c.config.FailureRatio > 0 && total >= c.config.MinRequests {
+		return float64(failures)/float64(total) >= c.config.FailureRatio
+	}
+
+	return false
+}
+
+func (c *circuits) transition(key string, cb *circuit, to State, now time.Time) stateChange {
+	from := cb.state
+	cb.state = to
+	cb.trials = 0
+	cb.succeeded = 0
+
+	switch to {
+	case Open:
+		cb.openedAt = now
+	case Closed:
+		cb.buckets = make([]counts, c.config.Buckets)
+	}
+
+	return stateChange{key: key, from: from, to: to}
+}
+
+// state returns the current state of the circuit of key
+func (c *circuits) state(key string) State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if cb, ok := c.circuits[key]; ok {
+		return cb.state
+	}
+
+	return Closed
+}
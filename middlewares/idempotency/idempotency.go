@@ -0,0 +1,158 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/volta-dev/volta"
+)
+
+// State is the processing state of a message key
+type State int
+
+const (
+	// StateAcquired means the caller now holds the in-progress lock of the key
+	StateAcquired State = iota
+
+	// StateInProgress means another handler is processing the same key
+	StateInProgress
+
+	// StateCompleted means the key was already processed successfully
+	StateCompleted
+)
+
+// Store keeps track of the processed message keys
+type Store interface {
+	// Acquire locks key for lockTTL unless it is locked or completed already
+	Acquire(ctx context.Context, key string, lockTTL time.Duration) (State, error)
+
+	// Complete marks key as processed and remembers it for ttl
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+
+	// Release drops the lock of key so a redelivery can be processed again
+	Release(ctx context.Context, key string) error
+}
+
+type Config struct {
+	// Next is a function to skip middleware based on some condition
+	Next func(c *volta.Ctx) bool
+
+	// KeyExtractor returns the deduplication key of a message, messages without key are not deduplicated
+	KeyExtractor func(c *volta.Ctx) string
+
+	// Store keeps the processed keys, an in-memory store is used when nil
+	Store Store
+
+	// LockTTL is how long a key stays locked while its message is processed
+	LockTTL time.Duration
+
+	// TTL is how long a processed key is remembered
+	TTL time.Duration
+
+	// OnDuplicate is called for a message that was already processed
+	OnDuplicate volta.Handler
+
+	// OnInProgress is called for a message that is being processed by another handler
+	OnInProgress volta.Handler
+}
+
+var ConfigDefault = Config{
+	Next:         nil,
+	KeyExtractor: MessageId,
+	Store:        nil,
+	LockTTL:      time.Minute,
+	TTL:          24 * time.Hour,
+	OnDuplicate:  func(c *volta.Ctx) error { return c.Ack(false) },
+	OnInProgress: func(c *volta.Ctx) error { return c.Nack(false, true) },
+}
+
+// MessageId uses the message_id property as the deduplication key
+func MessageId(c *volta.Ctx) string {
+	return c.MessageId()
+}
+
+// Header returns a key extractor reading the given header
+func Header(name string) func(c *volta.Ctx) string {
+	return func(c *volta.Ctx) string {
+		if value, ok := c.Delivery.Headers[name].(string); ok {
+			return value
+		}
+		return ""
+	}
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		cfg := ConfigDefault
+		cfg.Store = NewMemoryStore(0)
+		return cfg
+	}
+
+	cfg := config[0]
+
+	if cfg.KeyExtractor == nil {
+		cfg.KeyExtractor = ConfigDefault.KeyExtractor
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore(0)
+	}
+	if cfg.LockTTL <= 0 {
+		cfg.LockTTL = ConfigDefault.LockTTL
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = ConfigDefault.TTL
+	}
+	if cfg.OnDuplicate == nil {
+		cfg.OnDuplicate = ConfigDefault.OnDuplicate
+	}
+	if cfg.OnInProgress == nil {
+		cfg.OnInProgress = ConfigDefault.OnInProgress
+	}
+
+	return cfg
+}
+
+// New creates a middleware that skips messages already processed successfully.
+// The key is recorded as completed only when the rest of the chain returns no error and
+// acknowledged the message or left it unsettled, otherwise it is released for the redelivery.
+func New(config ...Config) volta.Handler {
+	cfg := configDefault(config...)
+
+	return func(c *volta.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		key := cfg.KeyExtractor(c)
+		if key == "" {
+			return c.Next()
+		}
+
+		ctx := c.Context()
+
+		state, err := cfg.Store.Acquire(ctx, key, cfg.LockTTL)
+		if err != nil {
+			return err
+		}
+
+		switch state {
+		case StateCompleted:
+			return cfg.OnDuplicate(c)
+		case StateInProgress:
+			return cfg.OnInProgress(c)
+		}
+
+		err = c.Next()
+
+		// A message negatively acknowledged or rejected by the chain, e.g. to requeue it, was not processed
+		if settlement := c.Settlement(); err != nil || (settlement != "" && settlement != volta.SettlementAck) {
+			if releaseErr := cfg.Store.Release(ctx, key); releaseErr != nil {
+				return errors.Join(err, releaseErr)
+			}
+			return err
+		}
+
+		return cfg.Store.Complete(ctx, key, cfg.TTL)
+	}
+}
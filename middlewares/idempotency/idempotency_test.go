@@ -0,0 +1,224 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/voltatest"
+	_ "modernc.org/sqlite"
+)
+
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	ctx := context.Background()
+
+	// TEST: the first delivery acquires the key, a concurrent one sees it in progress
+	if state, err := store.Acquire(ctx, "a", time.Minute); err != nil || state != StateAcquired {
+		t.Fatalf("Store.Acquire() = %v, %v, expected acquired", state, err)
+	}
+
+	if state, err := store.Acquire(ctx, "a", time.Minute); err != nil || state != StateInProgress {
+		t.Errorf("Store.Acquire() = %v, %v, expected in progress", state, err)
+	}
+
+	// TEST: a completed key is reported as duplicate
+	if err := store.Complete(ctx, "a", time.Hour); err != nil {
+		t.Fatalf("Store.Complete() error = %v", err)
+	}
+
+	if state, err := store.Acquire(ctx, "a", time.Minute); err != nil || state != StateCompleted {
+		t.Errorf("Store.Acquire() = %v, %v, expected completed", state, err)
+	}
+
+	// TEST: a released key can be acquired again
+	if state, err := store.Acquire(ctx, "b", time.Minute); err != nil || state != StateAcquired {
+		t.Fatalf("Store.Acquire() = %v, %v, expected acquired", state, err)
+	}
+
+	if err := store.Release(ctx, "b"); err != nil {
+		t.Fatalf("Store.Release() error = %v", err)
+	}
+
+	if state, err := store.Acquire(ctx, "b", time.Minute); err != nil || state != StateAcquired {
+		t.Errorf("Store.Acquire() = %v, %v, expected acquired after release", state, err)
+	}
+
+	// TEST: an expired lock is taken over
+	advance(2 * time.Minute)
+
+	if state, err := store.Acquire(ctx, "b", time.Minute); err != nil || state != StateAcquired {
+		t.Errorf("Store.Acquire() = %v, %v, expected acquired after expiry", state, err)
+	}
+
+	if state, err := store.Acquire(ctx, "a", time.Minute); err != nil || state != StateCompleted {
+		t.Errorf("Store.Acquire() = %v, %v, expected completed within ttl", state, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore(0)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestMemoryStore_Capacity(t *testing.T) {
+	store := NewMemoryStore(2)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		store.Acquire(ctx, key, time.Minute)
+		store.Complete(ctx, key, time.Hour)
+	}
+
+	if store.Len() != 2 {
+		t.Errorf("Len is %d, expected 2", store.Len())
+	}
+
+	if state, _ := store.Acquire(ctx, "a", time.Minute); state != StateAcquired {
+		t.Errorf("Least recently used key was not evicted")
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	now := time.Now()
+	store := NewSQLStore(db)
+	store.now = func() time.Time { return now }
+
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("SQLStore.Migrate() error = %v", err)
+	}
+
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestNew(t *testing.T) {
+	store := NewMemoryStore(0)
+	middleware := New(Config{Store: store})
+	ctx := context.Background()
+
+	calls := 0
+	failed := errors.New("failed")
+	handler := func(err error) volta.Handler {
+		return func(c *volta.Ctx) error {
+			calls++
+
+			// TEST: the key is locked, not completed, while the handler runs
+			if state, _ := store.Acquire(ctx, c.MessageId(), time.Minute); state != StateInProgress {
+				t.Errorf("Key state is %v while handling, expected in progress", state)
+			}
+
+			if err != nil {
+				return err
+			}
+			return c.Ack(false)
+		}
+	}
+
+	// TEST: a failed message releases the key, its redelivery is handled again
+	result := voltatest.Run(voltatest.NewMessage([]byte("test")).MessageId("a"), middleware, handler(failed))
+	if !errors.Is(result.Err, failed) {
+		t.Errorf("Error is %v, expected %v", result.Err, failed)
+	}
+
+	result = voltatest.Run(voltatest.NewMessage([]byte("test")).MessageId("a").Redelivered(), middleware, handler(nil))
+	if result.Err != nil || !result.Acked() {
+		t.Errorf("Redelivery result is %v, %s, expected acked", result.Err, result.Settlement)
+	}
+
+	if calls != 2 {
+		t.Fatalf("Handler was called %d times, expected 2", calls)
+	}
+
+	// TEST: the key is completed after the success, a duplicate is acked without calling the handler
+	if state, _ := store.Acquire(ctx, "a", time.Minute); state != StateCompleted {
+		t.Errorf("Key state is %v after the success, expected completed", state)
+	}
+
+	result = voltatest.Run(voltatest.NewMessage([]byte("test")).MessageId("a"), middleware, handler(nil))
+	if result.Err != nil || !result.Acked() {
+		t.Errorf("Duplicate result is %v, %s, expected acked", result.Err, result.Settlement)
+	}
+
+	if calls != 2 {
+		t.Errorf("Handler was called %d times for a duplicate, expected 2", calls)
+	}
+
+	// TEST: a message without key is not deduplicated
+	for i := 0; i < 2; i++ {
+		voltatest.Run(voltatest.NewMessage([]byte("test")), middleware, func(c *volta.Ctx) error {
+			calls++
+			return c.Ack(false)
+		})
+	}
+
+	if calls != 4 {
+		t.Errorf("Handler was called %d times, expected 4", calls)
+	}
+}
+
+func TestNew_requeued(t *testing.T) {
+	store := NewMemoryStore(0)
+	middleware := New(Config{Store: store})
+
+	calls := 0
+	handler := func(c *volta.Ctx) error {
+		calls++
+		if calls == 1 {
+			return c.Nack(false, true)
+		}
+		return c.Ack(false)
+	}
+
+	// TEST: a message requeued by the handler is not recorded as completed
+	result := voltatest.Run(voltatest.NewMessage([]byte("test")).MessageId("a"), middleware, handler)
+	if result.Err != nil || !result.Nacked() {
+		t.Errorf("Result is %v, %s, expected nacked", result.Err, result.Settlement)
+	}
+
+	if state, _ := store.Acquire(context.Background(), "a", time.Minute); state != StateAcquired {
+		t.Errorf("Key state is %v after the nack, expected released", state)
+	}
+	store.Release(context.Background(), "a")
+
+	// TEST: the redelivery reaches the handler
+	result = voltatest.Run(voltatest.NewMessage([]byte("test")).MessageId("a").Redelivered(), middleware, handler)
+	if result.Err != nil || !result.Acked() || calls != 2 {
+		t.Errorf("Redelivery result is %v, %s after %d calls, expected acked after 2", result.Err, result.Settlement, calls)
+	}
+}
+
+type failingReleaseStore struct {
+	Store
+	err error
+}
+
+func (s failingReleaseStore) Release(ctx context.Context, key string) error {
+	return s.err
+}
+
+func TestNew_releaseFailed(t *testing.T) {
+	failed := errors.New("failed")
+	unavailable := errors.New("unavailable")
+	middleware := New(Config{Store: failingReleaseStore{Store: NewMemoryStore(0), err: unavailable}})
+
+	// TEST: both the handler and the store errors are returned
+	result := voltatest.Run(voltatest.NewMessage([]byte("test")).MessageId("a"), middleware, func(c *volta.Ctx) error {
+		return failed
+	})
+
+	if !errors.Is(result.Err, failed) || !errors.Is(result.Err, unavailable) {
+		t.Errorf("Error is %v, expected %v and %v", result.Err, failed, unavailable)
+	}
+}
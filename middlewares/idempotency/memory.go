@@ -0,0 +1,117 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Default number of keys kept by the in-memory store
+const defaultCapacity = 100000
+
+type memoryEntry struct {
+	key       string
+	completed bool
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-memory Store evicting the least recently used keys beyond its capacity.
+// It only deduplicates within one process.
+type MemoryStore struct {
+	capacity int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+// NewMemoryStore creates an in-memory store holding up to capacity keys, 0 uses the default capacity
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+
+	return &MemoryStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Acquire(_ context.Context, key string, lockTTL time.Duration) (State, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		if now.Before(entry.expiresAt) {
+			s.order.MoveToFront(element)
+			if entry.completed {
+				return StateCompleted, nil
+			}
+			return StateInProgress, nil
+		}
+
+		s.remove(element)
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, expiresAt: now.Add(lockTTL)})
+	s.evict()
+
+	return StateAcquired, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		element = s.order.PushFront(&memoryEntry{key: key})
+		s.entries[key] = element
+	}
+
+	entry := element.Value.(*memoryEntry)
+	entry.completed = true
+	entry.expiresAt = s.now().Add(ttl)
+	s.order.MoveToFront(element)
+	s.evict()
+
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.entries[key]; ok && !element.Value.(*memoryEntry).completed {
+		s.remove(element)
+	}
+
+	return nil
+}
+
+// Len returns the number of keys currently held
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.order.Len()
+}
+
+// evict drops the least recently used keys beyond the capacity
+func (s *MemoryStore) evict() {
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *MemoryStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,148 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/volta-dev/volta/sqlbind"
+)
+
+type SQLConfig struct {
+	// Table is the name of the table holding the keys
+	Table string
+
+	// Placeholder returns the bind parameter for the n-th argument, use sqlbind.Dollar for PostgreSQL
+	Placeholder sqlbind.Placeholder
+
+	// Schema is the statement creating the table, %s is replaced by Table
+	Schema string
+}
+
+var SQLConfigDefault = SQLConfig{
+	Table:       "volta_idempotency",
+	Placeholder: sqlbind.Question,
+	Schema: `CREATE TABLE IF NOT EXISTS %s (
+	idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+	completed       INTEGER      NOT NULL DEFAULT 0,
+	expires_at      BIGINT       NOT NULL
+)`,
+}
+
+func sqlConfigDefault(config ...SQLConfig) SQLConfig {
+	if len(config) < 1 {
+		return SQLConfigDefault
+	}
+
+	cfg := config[0]
+
+	if cfg.Table == "" {
+		cfg.Table = SQLConfigDefault.Table
+	}
+	if cfg.Placeholder == nil {
+		cfg.Placeholder = SQLConfigDefault.Placeholder
+	}
+	if cfg.Schema == "" {
+		cfg.Schema = SQLConfigDefault.Schema
+	}
+
+	return cfg
+}
+
+// SQLStore is a Store backed by a database/sql table, shared by all replicas using the database
+type SQLStore struct {
+	db     *sql.DB
+	config SQLConfig
+	now    func() time.Time
+}
+
+// NewSQLStore creates a store using the given database
+func NewSQLStore(db *sql.DB, config ...SQLConfig) *SQLStore {
+	return &SQLStore{db: db, config: sqlConfigDefault(config...), now: time.Now}
+}
+
+// Migrate creates the table if it does not exist
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(s.config.Schema, s.config.Table))
+	return err
+}
+
+// query adapts the statement to the configured table and placeholder
+func (s *SQLStore) query(statement string) string {
+	return sqlbind.Query(statement, s.config.Table, s.config.Placeholder)
+}
+
+func (s *SQLStore) Acquire(ctx context.Context, key string, lockTTL time.Duration) (State, error) {
+	now := s.now()
+	expiresAt := now.Add(lockTTL).UnixMilli()
+
+	_, insertErr := s.db.ExecContext(ctx, s.query(
+		`INSERT INTO %s (idempotency_key, completed, expires_at) VALUES (?, 0, ?)`,
+	), key, expiresAt)
+	if insertErr == nil {
+		return StateAcquired, nil
+	}
+
+	// The insert failed, most likely because the key exists: inspect it
+	var completed bool
+	var currentExpiresAt int64
+	err := s.db.QueryRowContext(ctx, s.query(
+		`SELECT completed, expires_at FROM %s WHERE idempotency_key = ?`,
+	), key).Scan(&completed, &currentExpiresAt)
+	if err == sql.ErrNoRows {
+		return StateAcquired, insertErr
+	}
+	if err != nil {
+		return StateAcquired, err
+	}
+
+	if currentExpiresAt > now.UnixMilli() {
+		if completed {
+			return StateCompleted, nil
+		}
+		return StateInProgress, nil
+	}
+
+	// The key expired, take it over unless another handler was faster
+	result, err := s.db.ExecContext(ctx, s.query(
+		`UPDATE %s SET completed = 0, expires_at = ? WHERE idempotency_key = ? AND expires_at = ?`,
+	), expiresAt, key, currentExpiresAt)
+	if err != nil {
+		return StateAcquired, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+		return StateInProgress, err
+	}
+
+	return StateAcquired, nil
+}
+
+func (s *SQLStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, s.query(
+		`UPDATE %s SET completed = 1, expires_at = ? WHERE idempotency_key = ?`,
+	), s.now().Add(ttl).UnixMilli(), key)
+
+	return err
+}
+
+func (s *SQLStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.query(
+		`DELETE FROM %s WHERE idempotency_key = ? AND completed = 0`,
+	), key)
+
+	return err
+}
+
+// Purge deletes the expired keys
+func (s *SQLStore) Purge(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.query(
+		`DELETE FROM %s WHERE expires_at < ?`,
+	), s.now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
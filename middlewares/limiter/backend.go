@@ -0,0 +1,111 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Rate is the refill speed and capacity of a token bucket
+type Rate struct {
+	// Limit is the number of tokens refilled per Window
+	Limit int
+
+	// Burst is the capacity of the bucket
+	Burst int
+
+	Window time.Duration
+}
+
+// Interval returns the time needed to refill one token
+func (r Rate) Interval() time.Duration {
+	if r.Limit <= 0 {
+		return 0
+	}
+
+	return r.Window / time.Duration(r.Limit)
+}
+
+// Backend stores the token buckets. The in-memory backend limits a single process,
+// a shared backend such as Redis enforces the limit across all replicas.
+type Backend interface {
+	// Take removes a token from the bucket of key. When the bucket is empty, the token is
+	// reserved if it becomes available within maxWait, and the time to wait for it is returned.
+	// It reports false when the message has to be rejected.
+	Take(ctx context.Context, key string, rate Rate, maxWait time.Duration) (time.Duration, bool, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryBackend keeps the token buckets in the memory of the process
+type MemoryBackend struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	idle    time.Duration
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewMemoryBackend creates an in-memory backend, buckets are checked for removal every sweep interval
+func NewMemoryBackend(sweep time.Duration) *MemoryBackend {
+	return &MemoryBackend{buckets: make(map[string]*bucket), idle: sweep, now: time.Now}
+}
+
+func (m *MemoryBackend) Take(_ context.Context, key string, r Rate, maxWait time.Duration) (time.Duration, bool, error) {
+	interval := r.Interval()
+	if interval <= 0 {
+		return 0, false, nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	m.sweep(now, r)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.Burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	// Refill the tokens earned since the last update, up to the burst
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += float64(elapsed) / float64(interval)
+	}
+	if b.tokens > float64(r.Burst) {
+		b.tokens = float64(r.Burst)
+	}
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) * float64(interval))
+	if wait > maxWait {
+		return wait, false, nil
+	}
+
+	b.tokens--
+	return wait, true, nil
+}
+
+// sweep drops the buckets that have refilled completely, a new bucket starts full anyway
+func (m *MemoryBackend) sweep(now time.Time, r Rate) {
+	if now.Sub(m.swept) < m.idle {
+		return
+	}
+	m.swept = now
+
+	interval := float64(r.Interval())
+	for key, b := range m.buckets {
+		if b.tokens+float64(now.Sub(b.updated))/interval >= float64(r.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -1,52 +1,168 @@
 package limiter
 
 import (
-	"github.com/volta-dev/volta"
 	"time"
+
+	"github.com/volta-dev/volta"
+)
+
+// Mode is what the limiter does with a message that exceeds the limit
+type Mode int
+
+const (
+	// Reject hands the message to OnLimitReached right away
+	Reject Mode = iota
+
+	// Block holds the message until a token is available, which slows the consumer down
+	Block
 )
 
 type Config struct {
-	// Limit is the maximum number of requests allowed in a minute
+	// Limits is the maximum number of messages allowed per Window and key, 0 disables the limit
 	Limits int
 
+	// Window is the period Limits applies to
+	Window time.Duration
+
+	// Burst is the number of messages allowed at once, tokens refill at Limits per Window
+	Burst int
+
+	// Mode is what happens to a message exceeding the limit
+	Mode Mode
+
+	// MaxWait is the longest a message is held in Block mode before OnLimitReached is called,
+	// 0 waits as long as needed
+	MaxWait time.Duration
+
+	// KeyGenerator returns the key the limit is counted for
+	KeyGenerator func(c *volta.Ctx) string
+
+	// Backend stores the token buckets, use a shared backend to limit across replicas
+	Backend Backend
+
 	// Next is a function to skip middleware based on some condition
 	Next func(c *volta.Ctx) bool
 
 	// OnLimitReached is a function that will be called when the limit is reached
 	OnLimitReached volta.Handler
+}
+
+var ConfigDefault = Config{
+	Limits:         0,
+	Window:         time.Minute,
+	Burst:          0,
+	Mode:           Reject,
+	MaxWait:        0,
+	KeyGenerator:   RoutingKey,
+	Backend:        nil,
+	Next:           nil,
+	OnLimitReached: Reply,
+}
+
+// RoutingKey limits messages per routing key
+func RoutingKey(c *volta.Ctx) string {
+	return c.RoutingKey()
+}
+
+// UserId limits messages per user_id property
+func UserId(c *volta.Ctx) string {
+	return c.UserId()
+}
+
+// AppId limits messages per app_id property
+func AppId(c *volta.Ctx) string {
+	return c.AppId()
+}
+
+// Header returns a key generator limiting messages per value of the given header
+func Header(name string) func(c *volta.Ctx) string {
+	return func(c *volta.Ctx) string {
+		if value, ok := c.Delivery.Headers[name].(string); ok {
+			return value
+		}
+		return ""
+	}
+}
+
+// Reply answers the message with a "Limit reached" JSON reply, or requeues it when there is no reply_to
+func Reply(c *volta.Ctx) error {
+	if c.ReplyTo() == "" {
+		return Requeue(c)
+	}
+
+	return c.ReplyJSON(volta.Map{
+		"message": "Limit reached",
+	})
+}
+
+// Requeue negatively acknowledges the message and puts it back into the queue
+func Requeue(c *volta.Ctx) error {
+	return c.Nack(false, true)
+}
 
-	// Requests is a map of routingKey addresses and their request count
-	requests map[string]int
+// Discard negatively acknowledges the message without requeueing, it is dropped or dead-lettered
+func Discard(c *volta.Ctx) error {
+	return c.Nack(false, false)
 }
 
+func configDefault(config Config) Config {
+	if config.Window <= 0 {
+		config.Window = ConfigDefault.Window
+	}
+	if config.Burst <= 0 {
+		config.Burst = config.Limits
+	}
+	if config.KeyGenerator == nil {
+		config.KeyGenerator = ConfigDefault.KeyGenerator
+	}
+	if config.OnLimitReached == nil {
+		config.OnLimitReached = ConfigDefault.OnLimitReached
+	}
+	if config.Backend == nil {
+		config.Backend = NewMemoryBackend(config.Window)
+	}
+
+	return config
+}
+
+// New creates a token bucket limiter, safe for concurrent handlers.
+// Every key gets a bucket of Burst tokens refilled at Limits per Window.
+// A message waiting for its token in Block mode gives up with the error of Ctx.Context when it is done.
 func New(config Config) volta.Handler {
-	config.requests = make(map[string]int)
+	cfg := configDefault(config)
+	rate := Rate{Limit: cfg.Limits, Burst: cfg.Burst, Window: cfg.Window}
 
 	return func(c *volta.Ctx) (err error) {
-		if config.Next != nil && config.Next(c) {
+		if cfg.Limits <= 0 || (cfg.Next != nil && cfg.Next(c)) {
 			return c.Next()
 		}
 
-		if count, ok := config.requests[c.Delivery.RoutingKey]; ok {
-			if count >= config.Limits {
-				if config.OnLimitReached != nil {
-					return config.OnLimitReached(c)
-				}
-
-				return c.ReplyJSON(volta.Map{
-					"message": "Limit reached",
-				})
+		var maxWait time.Duration
+		if cfg.Mode == Block {
+			maxWait = cfg.MaxWait
+			if maxWait <= 0 {
+				maxWait = time.Duration(1<<63 - 1)
 			}
+		}
 
-			config.requests[c.Delivery.RoutingKey] = count + 1
-		} else {
-			config.requests[c.Delivery.RoutingKey] = 1
+		wait, ok, err := cfg.Backend.Take(c.Context(), cfg.KeyGenerator(c), rate, maxWait)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return cfg.OnLimitReached(c)
 		}
 
-		// Wait for 1 minute before resetting the request count
-		time.AfterFunc(time.Minute, func() {
-			delete(config.requests, c.Delivery.RoutingKey)
-		})
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-c.Context().Done():
+				return c.Context().Err()
+			}
+		}
 
 		// Continue processing the next middleware or route handler
 		return c.Next()
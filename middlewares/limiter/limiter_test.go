@@ -0,0 +1,222 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/voltatest"
+)
+
+func testBackend(t *testing.T, backend Backend, advance func(time.Duration)) {
+	ctx := context.Background()
+	r := Rate{Limit: 60, Burst: 2, Window: time.Minute}
+
+	take := func(key string, maxWait time.Duration) (time.Duration, bool) {
+		wait, ok, err := backend.Take(ctx, key, r, maxWait)
+		if err != nil {
+			t.Fatalf("Backend.Take() error = %v", err)
+		}
+		return wait, ok
+	}
+
+	// TEST: the burst is available at once, then the bucket is empty
+	for i := 0; i < 2; i++ {
+		if _, ok := take("a", 0); !ok {
+			t.Fatalf("Message #%d was rejected within the burst", i)
+		}
+	}
+
+	if wait, ok := take("a", 0); ok || wait != time.Second {
+		t.Errorf("Take() = %s, %v, expected a rejection with a wait of 1s", wait, ok)
+	}
+
+	// TEST: keys are limited independently
+	if _, ok := take("b", 0); !ok {
+		t.Error("Another key was rejected")
+	}
+
+	// TEST: tokens refill over time
+	advance(time.Second)
+	if _, ok := take("a", 0); !ok {
+		t.Error("Message was rejected after the refill")
+	}
+
+	// TEST: blocking reserves tokens in order
+	if wait, ok := take("a", time.Minute); !ok || wait != time.Second {
+		t.Errorf("Take() = %s, %v, expected a reservation in 1s", wait, ok)
+	}
+
+	if wait, ok := take("a", time.Minute); !ok || wait != 2*time.Second {
+		t.Errorf("Take() = %s, %v, expected a reservation in 2s", wait, ok)
+	}
+
+	if _, ok := take("a", time.Second); ok {
+		t.Error("Take() reserved a token beyond maxWait")
+	}
+
+	// TEST: a limiter without limits rejects everything
+	if _, ok, _ := backend.Take(ctx, "c", Rate{Window: time.Minute}, 0); ok {
+		t.Error("A limiter without limits allowed a message")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	now := time.Now()
+	backend := NewMemoryBackend(time.Minute)
+	backend.now = func() time.Time { return now }
+
+	testBackend(t, backend, func(d time.Duration) { now = now.Add(d) })
+
+	// TEST: idle buckets are swept once refilled
+	now = now.Add(2 * time.Minute)
+	backend.Take(context.Background(), "d", Rate{Limit: 60, Burst: 2, Window: time.Minute}, 0)
+	if len(backend.buckets) != 1 {
+		t.Errorf("%d buckets are kept, expected 1", len(backend.buckets))
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := NewRedisClient(server.Addr())
+	defer client.Close()
+
+	// The buckets follow the clock of Redis
+	now := time.Now()
+	server.SetTime(now)
+	counting := &countingClient{RedisClient: client}
+	backend := NewRedisBackend(counting, "limiter:")
+
+	testBackend(t, backend, func(d time.Duration) {
+		now = now.Add(d)
+		server.SetTime(now)
+	})
+
+	if !server.Exists("limiter:a") {
+		t.Error("Bucket was not stored under the prefix")
+	}
+
+	// TEST: the script is sent once, then run by its digest
+	if counting.evals != 1 {
+		t.Errorf("The script was sent %d times, expected once", counting.evals)
+	}
+}
+
+type countingClient struct {
+	*RedisClient
+	evals int
+}
+
+func (c *countingClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	c.evals++
+	return c.RedisClient.Eval(ctx, script, keys, args...)
+}
+
+func TestBackend_concurrent(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := NewRedisClient(server.Addr())
+	defer client.Close()
+
+	backends := map[string]Backend{
+		"memory": NewMemoryBackend(time.Minute),
+		"redis":  NewRedisBackend(client, "limiter:"),
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			r := Rate{Limit: 100, Burst: 100, Window: time.Hour}
+
+			var wg sync.WaitGroup
+			var mutex sync.Mutex
+			allowed := 0
+
+			for i := 0; i < 300; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, ok, err := backend.Take(context.Background(), "key", r, 0); ok && err == nil {
+						mutex.Lock()
+						allowed++
+						mutex.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if allowed != 100 {
+				t.Errorf("%d messages were allowed, expected 100", allowed)
+			}
+		})
+	}
+}
+
+func TestRedisClient_Do(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := NewRedisClient(server.Addr())
+	defer client.Close()
+
+	ctx := context.Background()
+
+	if reply, err := client.Do(ctx, "SET", "key", "value"); err != nil || reply != "OK" {
+		t.Errorf("Do(SET) = %v, %v", reply, err)
+	}
+
+	if reply, err := client.Do(ctx, "GET", "key"); err != nil || reply != "value" {
+		t.Errorf("Do(GET) = %v, %v", reply, err)
+	}
+
+	if reply, err := client.Do(ctx, "GET", "missing"); err != nil || reply != nil {
+		t.Errorf("Do(GET missing) = %v, %v", reply, err)
+	}
+
+	if _, err := client.Do(ctx, "UNKNOWN"); err == nil {
+		t.Error("Do(UNKNOWN) did not fail")
+	}
+
+	// TEST: the connection is still usable after an error reply
+	if reply, err := client.Do(ctx, "INCR", "counter"); err != nil || reply != int64(1) {
+		t.Errorf("Do(INCR) = %v, %v", reply, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	// TEST: a limiter without limits lets every message through
+	unlimited := New(Config{})
+	for i := 0; i < 3; i++ {
+		result := voltatest.Run(voltatest.NewMessage([]byte("test")), unlimited, func(c *volta.Ctx) error { return c.Ack(false) })
+		if result.Err != nil || !result.Acked() {
+			t.Fatalf("Message #%d is %v, %s, expected acked", i, result.Err, result.Settlement)
+		}
+	}
+
+	// TEST: a blocked message gives up when its context is done
+	blocking := New(Config{Limits: 1, Window: time.Hour, Mode: Block})
+	deadline := func(c *volta.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.Context(), 50*time.Millisecond)
+		defer cancel()
+
+		c.SetContext(ctx)
+		return c.Next()
+	}
+
+	called := 0
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		result := voltatest.Run(voltatest.NewMessage([]byte("test")), deadline, blocking, func(c *volta.Ctx) error {
+			called++
+			return c.Ack(false)
+		})
+
+		if i == 1 && (!errors.Is(result.Err, context.DeadlineExceeded) || time.Since(start) > time.Second) {
+			t.Errorf("Blocked message is %v after %s, expected %v", result.Err, time.Since(start), context.DeadlineExceeded)
+		}
+	}
+
+	if called != 1 {
+		t.Errorf("Handler was called %d times, expected 1", called)
+	}
+}
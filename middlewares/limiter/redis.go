@@ -0,0 +1,367 @@
+package limiter
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// takeScript is the token bucket of MemoryBackend.Take executed atomically inside Redis.
+// Times are in microseconds and read from the clock of Redis, so replicas with skewed clocks agree.
+// The bucket expires once it would be full again.
+const takeScript = `
+-- Writing after reading the clock needs effects replication before Redis 5
+redis.replicate_commands()
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local max_wait = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+
+if now > updated then
+	tokens = tokens + (now - updated) / interval
+end
+if tokens > burst then
+	tokens = burst
+end
+
+local wait = 0
+local ok = 1
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = (1 - tokens) * interval
+	if wait > max_wait then
+		ok = 0
+	else
+		tokens = tokens - 1
+	end
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(((burst - tokens) * interval) / 1000) + 1000)
+
+return {ok, tostring(math.floor(wait))}
+`
+
+// takeScriptSHA is the SHA1 digest Redis caches takeScript under
+var takeScriptSHA = func() string {
+	sum := sha1.Sum([]byte(takeScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// Evaler runs a Lua script, it is implemented by RedisClient and easily adapted from other Redis clients
+type Evaler interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// EvalShaer runs a Lua script cached by Redis. An Evaler implementing it sends the script
+// only when Redis does not know it yet, i.e. EvalSha failed with a NOSCRIPT error.
+type EvalShaer interface {
+	EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisBackend keeps the token buckets in Redis, so all replicas share the same limits
+type RedisBackend struct {
+	client Evaler
+	prefix string
+}
+
+// NewRedisBackend creates a backend storing the buckets under keys starting with prefix
+func NewRedisBackend(client Evaler, prefix string) *RedisBackend {
+	return &RedisBackend{client: client, prefix: prefix}
+}
+
+func (r *RedisBackend) Take(ctx context.Context, key string, rate Rate, maxWait time.Duration) (time.Duration, bool, error) {
+	interval := rate.Interval()
+	if interval <= 0 {
+		return 0, false, nil
+	}
+
+	reply, err := r.eval(ctx, []string{r.prefix + key},
+		interval.Microseconds(),
+		rate.Burst,
+		maxWait.Microseconds(),
+	)
+	if err != nil {
+		return 0, false, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, false, fmt.Errorf("limiter: Unexpected reply from Redis: %v", reply)
+	}
+
+	allowed, _ := values[0].(int64)
+	wait, err := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return time.Duration(wait) * time.Microsecond, allowed == 1, nil
+}
+
+// eval runs takeScript by its digest when the client supports it, sending the whole script only once
+func (r *RedisBackend) eval(ctx context.Context, keys []string, args ...interface{}) (interface{}, error) {
+	if client, ok := r.client.(EvalShaer); ok {
+		reply, err := client.EvalSha(ctx, takeScriptSHA, keys, args...)
+		if err == nil || !strings.Contains(err.Error(), "NOSCRIPT") {
+			return reply, err
+		}
+	}
+
+	return r.client.Eval(ctx, takeScript, keys, args...)
+}
+
+// RedisClient is a minimal client speaking the Redis protocol (RESP2).
+// It keeps a small pool of connections and is safe for concurrent use.
+type RedisClient struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	pool     chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+type RedisConfig struct {
+	// Password used to AUTH, empty to skip
+	Password string
+
+	// DB selected on every new connection
+	DB int
+
+	// Timeout of dialing and of a single command
+	Timeout time.Duration
+
+	// PoolSize is the number of idle connections kept open
+	PoolSize int
+}
+
+var RedisConfigDefault = RedisConfig{
+	Timeout:  5 * time.Second,
+	PoolSize: 8,
+}
+
+// NewRedisClient creates a client for the Redis server at addr ("host:port"), connections are opened lazily
+func NewRedisClient(addr string, config ...RedisConfig) *RedisClient {
+	cfg := RedisConfigDefault
+	if len(config) > 0 {
+		cfg = config[0]
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = RedisConfigDefault.Timeout
+		}
+		if cfg.PoolSize <= 0 {
+			cfg.PoolSize = RedisConfigDefault.PoolSize
+		}
+	}
+
+	return &RedisClient{
+		addr:     addr,
+		password: cfg.Password,
+		db:       cfg.DB,
+		timeout:  cfg.Timeout,
+		pool:     make(chan *redisConn, cfg.PoolSize),
+	}
+}
+
+// Eval runs a Lua script with EVAL
+func (c *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.Do(ctx, scriptCommand("EVAL", script, keys, args)...)
+}
+
+// EvalSha runs a Lua script cached by Redis with EVALSHA
+func (c *RedisClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.Do(ctx, scriptCommand("EVALSHA", sha1, keys, args)...)
+}
+
+func scriptCommand(name, script string, keys []string, args []interface{}) []interface{} {
+	command := make([]interface{}, 0, 3+len(keys)+len(args))
+	command = append(command, name, script, len(keys))
+	for _, key := range keys {
+		command = append(command, key)
+	}
+
+	return append(command, args...)
+}
+
+// Do sends a command and returns its reply: string, int64, []interface{} or nil
+func (c *RedisClient) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.conn.SetDeadline(deadline)
+
+	reply, err := conn.do(args...)
+
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state
+		conn.conn.Close()
+		return nil, err
+	}
+
+	c.put(conn)
+	return reply, err
+}
+
+// Close closes the idle connections
+func (c *RedisClient) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *RedisClient) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+	netConn.SetDeadline(time.Now().Add(c.timeout))
+
+	if c.password != "" {
+		if _, err := conn.do("AUTH", c.password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	if c.db != 0 {
+		if _, err := conn.do("SELECT", c.db); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *RedisClient) put(conn *redisConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
+
+// redisError is an error reply of the server, the connection stays usable
+type redisError string
+
+func (e redisError) Error() string {
+	return "limiter: Redis error: " + string(e)
+}
+
+func (c *redisConn) do(args ...interface{}) (interface{}, error) {
+	buffer := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var value string
+		switch v := arg.(type) {
+		case string:
+			value = v
+		case []byte:
+			value = string(v)
+		default:
+			value = fmt.Sprint(v)
+		}
+		buffer = append(buffer, "$"+strconv.Itoa(len(value))+"\r\n"+value+"\r\n"...)
+	}
+
+	if _, err := c.conn.Write(buffer); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("limiter: Malformed Redis reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		values := make([]interface{}, size)
+		for i := range values {
+			value, err := c.read()
+
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				value = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+
+			values[i] = value
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("limiter: Unknown Redis reply type %q", line[0])
+}
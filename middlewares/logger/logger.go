@@ -1,28 +1,166 @@
 package logger
 
 import (
-	"fmt"
-	"github.com/volta-dev/volta"
+	"io"
+	"log/slog"
+	"os"
 	"time"
+
+	"github.com/volta-dev/volta"
+)
+
+// Format is the output format of the entries
+type Format int
+
+const (
+	// FormatText writes key=value pairs
+	FormatText Format = iota
+
+	// FormatJSON writes one JSON object per entry
+	FormatJSON
+)
+
+// Field is a property of the message written with every entry
+type Field string
+
+const (
+	FieldCorrelationId Field = "correlation_id"
+	FieldMessageId     Field = "message_id"
+	FieldQueue         Field = "queue"
+	FieldExchange      Field = "exchange"
+	FieldRoutingKey    Field = "routing_key"
+	FieldLatency       Field = "latency"
+	FieldBodySize      Field = "body_size"
+	FieldHeaders       Field = "headers"
+
+	// FieldOutcome is how the message was settled: ack, nack, reject or empty
+	FieldOutcome Field = "outcome"
 )
 
-func New() volta.Handler {
-	return func(ctx *volta.Ctx) error {
-		timeStart := time.Now()
+type Config struct {
+	// Next is a function to skip middleware based on some condition
+	Next func(c *volta.Ctx) bool
+
+	// Logger the entries are written to, Output and Format are ignored when set
+	Logger *slog.Logger
 
-		ctx.Next()
+	// Output the entries are written to
+	Output io.Writer
 
-		elapsed := time.Since(timeStart)
+	// Format of the entries
+	Format Format
 
-		fmt.Printf(
-			"[%s] correlationId: \"%s\" | exchange: \"%s\" | routingKey: \"%s\" | elapsed: \"%s\"\n",
-			time.Now().Format("2006-01-02 15:04:05"),
-			ctx.CorrelationId(),
-			ctx.Delivery.Exchange,
-			ctx.Delivery.RoutingKey,
-			elapsed,
-		)
+	// Fields written with every entry, the error of the handlers is always added when present
+	Fields []Field
 
-		return nil
+	// Level returns the level of the entry from the error of the handlers
+	Level func(c *volta.Ctx, err error) slog.Level
+}
+
+var ConfigDefault = Config{
+	Next:   nil,
+	Logger: nil,
+	Output: os.Stdout,
+	Format: FormatText,
+	Fields: []Field{FieldCorrelationId, FieldExchange, FieldRoutingKey, FieldLatency},
+	Level:  ErrorLevel,
+}
+
+// ErrorLevel logs messages at info level, or at error level when the handlers returned an error
+func ErrorLevel(_ *volta.Ctx, err error) slog.Level {
+	if err != nil {
+		return slog.LevelError
+	}
+
+	return slog.LevelInfo
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	cfg := config[0]
+
+	if cfg.Output == nil {
+		cfg.Output = ConfigDefault.Output
+	}
+	if cfg.Fields == nil {
+		cfg.Fields = ConfigDefault.Fields
 	}
+	if cfg.Level == nil {
+		cfg.Level = ConfigDefault.Level
+	}
+
+	return cfg
+}
+
+func (cfg Config) logger() *slog.Logger {
+	if cfg.Logger != nil {
+		return cfg.Logger
+	}
+
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if cfg.Format == FormatJSON {
+		return slog.New(slog.NewJSONHandler(cfg.Output, options))
+	}
+
+	return slog.New(slog.NewTextHandler(cfg.Output, options))
+}
+
+// New creates a middleware logging every handled message.
+// Entries are logged with Ctx.Context, so handlers reading e.g. the trace ID from the context see it.
+func New(config ...Config) volta.Handler {
+	cfg := configDefault(config...)
+	logger := cfg.logger()
+
+	return func(c *volta.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		start := time.Now()
+
+		err := c.Next()
+
+		attributes := make([]slog.Attr, 0, len(cfg.Fields)+1)
+		for _, field := range cfg.Fields {
+			attributes = append(attributes, attribute(c, field, time.Since(start)))
+		}
+
+		if err != nil {
+			attributes = append(attributes, slog.Any("error", err))
+		}
+
+		logger.LogAttrs(c.Context(), cfg.Level(c, err), "Message handled", attributes...)
+
+		return err
+	}
+}
+
+func attribute(c *volta.Ctx, field Field, latency time.Duration) slog.Attr {
+	key := string(field)
+
+	switch field {
+	case FieldCorrelationId:
+		return slog.String(key, c.CorrelationId())
+	case FieldMessageId:
+		return slog.String(key, c.MessageId())
+	case FieldQueue:
+		return slog.String(key, c.Queue())
+	case FieldExchange:
+		return slog.String(key, c.Delivery.Exchange)
+	case FieldRoutingKey:
+		return slog.String(key, c.Delivery.RoutingKey)
+	case FieldLatency:
+		return slog.Duration(key, latency)
+	case FieldBodySize:
+		return slog.Int(key, len(c.Delivery.Body))
+	case FieldHeaders:
+		return slog.Any(key, map[string]interface{}(c.Delivery.Headers))
+	case FieldOutcome:
+		return slog.String(key, string(c.Settlement()))
+	}
+
+	return slog.Attr{Key: key}
 }
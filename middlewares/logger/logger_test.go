@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+)
+
+type acknowledger struct{}
+
+func (acknowledger) Ack(uint64, bool) error        { return nil }
+func (acknowledger) Nack(uint64, bool, bool) error { return nil }
+func (acknowledger) Reject(uint64, bool) error     { return nil }
+
+func TestNew_JSON(t *testing.T) {
+	var output bytes.Buffer
+	handler := New(Config{
+		Output: &output,
+		Format: FormatJSON,
+		Fields: []Field{FieldCorrelationId, FieldRoutingKey, FieldBodySize, FieldHeaders, FieldOutcome},
+	})
+
+	ctx := &volta.Ctx{Delivery: amqp091.Delivery{
+		Acknowledger:  acknowledger{},
+		CorrelationId: "abc",
+		RoutingKey:    "orders.created",
+		Headers:       amqp091.Table{"tenant": "acme"},
+		Body:          []byte("hello"),
+	}}
+	if err := ctx.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil {
+		t.Fatalf("entry is not JSON: %s", output.String())
+	}
+
+	expected := map[string]interface{}{
+		"level":          "INFO",
+		"correlation_id": "abc",
+		"routing_key":    "orders.created",
+		"body_size":      float64(5),
+		"outcome":        "ack",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("%s is %v, expected %v", key, entry[key], value)
+		}
+	}
+
+	if headers, _ := entry["headers"].(map[string]interface{}); headers["tenant"] != "acme" {
+		t.Errorf("headers are %v", entry["headers"])
+	}
+	if _, ok := entry["latency"]; ok {
+		t.Error("latency was not requested")
+	}
+}
+
+func TestErrorLevel(t *testing.T) {
+	if level := ErrorLevel(nil, nil); level != slog.LevelInfo {
+		t.Errorf("level without error is %s", level)
+	}
+	if level := ErrorLevel(nil, errors.New("failed")); level != slog.LevelError {
+		t.Errorf("level with error is %s", level)
+	}
+}
+
+type contextKey struct{}
+
+// contextHandler records the value of contextKey in the context of every entry
+type contextHandler struct {
+	slog.Handler
+	values []interface{}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.values = append(h.values, ctx.Value(contextKey{}))
+	return nil
+}
+
+func TestNew_context(t *testing.T) {
+	handler := &contextHandler{Handler: slog.NewTextHandler(io.Discard, nil)}
+	middleware := New(Config{Logger: slog.New(handler)})
+
+	ctx := &volta.Ctx{}
+	ctx.SetContext(context.WithValue(context.Background(), contextKey{}, "span"))
+
+	if err := middleware(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST: the entry is logged with the context of the message
+	if len(handler.values) != 1 || handler.values[0] != "span" {
+		t.Errorf("Entries were logged with %v, expected the context of the message", handler.values)
+	}
+}
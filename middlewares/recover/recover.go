@@ -2,6 +2,8 @@ package recover
 
 import (
 	"fmt"
+	"runtime/debug"
+
 	"github.com/volta-dev/volta"
 )
 
@@ -22,8 +24,9 @@ var ConfigDefault = Config{
 	StackTraceHandler: defaultStackTraceHandler,
 }
 
-func defaultStackTraceHandler(_ *volta.Ctx, e interface{}) {
-	fmt.Println("Panic:", e)
+// defaultStackTraceHandler logs the panic and its stack through the logger of the application
+func defaultStackTraceHandler(c *volta.Ctx, e interface{}) {
+	c.App.Logger().Error("Panic recovered", "queue", c.Queue(), "routing_key", c.RoutingKey(), "panic", e, "stack", string(debug.Stack()))
 }
 
 func configDefault(config ...Config) Config {
@@ -0,0 +1,206 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/volta-dev/volta"
+)
+
+// ErrTimeout is returned by the middleware when the chain did not finish before the deadline
+var ErrTimeout = errors.New("timeout: Handler exceeded its deadline")
+
+type Config struct {
+	// Next is a function to skip middleware based on some condition
+	Next func(c *volta.Ctx) bool
+
+	// Timeout is the longest a message may be handled
+	Timeout time.Duration
+
+	// FromExpiration shortens the deadline to the expiration property of the message,
+	// counted from its timestamp (or from its arrival when it has none)
+	FromExpiration bool
+
+	// DeadlineHeader is a header set by the publisher holding the deadline,
+	// as unix milliseconds or an RFC 3339 time. Empty to disable
+	DeadlineHeader string
+
+	// OnTimeout settles a message whose handler exceeded the deadline
+	OnTimeout volta.Handler
+}
+
+var ConfigDefault = Config{
+	Next:           nil,
+	Timeout:        30 * time.Second,
+	FromExpiration: false,
+	DeadlineHeader: "",
+	OnTimeout:      Requeue,
+}
+
+// Requeue negatively acknowledges the message and puts it back into the queue
+func Requeue(c *volta.Ctx) error {
+	return c.Nack(false, true)
+}
+
+// Nack negatively acknowledges the message without requeueing, it is dropped or dead-lettered
+func Nack(c *volta.Ctx) error {
+	return c.Nack(false, false)
+}
+
+// Reply answers an RPC message with a timeout error, or requeues it when there is no reply_to
+func Reply(c *volta.Ctx) error {
+	if c.ReplyTo() == "" {
+		return Requeue(c)
+	}
+
+	return c.ReplyJSON(volta.Map{
+		"error": ErrTimeout.Error(),
+	})
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	cfg := config[0]
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = ConfigDefault.Timeout
+	}
+	if cfg.OnTimeout == nil {
+		cfg.OnTimeout = ConfigDefault.OnTimeout
+	}
+
+	return cfg
+}
+
+// deadline returns the earliest deadline of the message
+func (cfg Config) deadline(c *volta.Ctx, now time.Time) time.Time {
+	deadline := now.Add(cfg.Timeout)
+
+	if cfg.FromExpiration && c.Delivery.Expiration != "" {
+		if ms, err := strconv.ParseInt(c.Delivery.Expiration, 10, 64); err == nil {
+			start := c.Timestamp()
+			if start.IsZero() {
+				start = now
+			}
+
+			if expires := start.Add(time.Duration(ms) * time.Millisecond); expires.Before(deadline) {
+				deadline = expires
+			}
+		}
+	}
+
+	if cfg.DeadlineHeader != "" {
+		if header, ok := headerTime(c.Delivery.Headers[cfg.DeadlineHeader]); ok && header.Before(deadline) {
+			deadline = header
+		}
+	}
+
+	return deadline
+}
+
+// headerTime parses a deadline header value
+func headerTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case int64:
+		return time.UnixMilli(v), true
+	case int32:
+		return time.UnixMilli(int64(v)), true
+	case int:
+		return time.UnixMilli(int64(v)), true
+	case time.Time:
+		return v, true
+	case string:
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.UnixMilli(ms), true
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// chainPanic is a panic of the chain handed back to the middlewares running before this one,
+// it carries the stack of the goroutine the chain panicked on
+type chainPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p chainPanic) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// Unwrap returns the panic value when it is an error
+func (p chainPanic) Unwrap() error {
+	err, _ := p.value.(error)
+	return err
+}
+
+// New creates a middleware bounding the time the rest of the chain may take.
+// The deadline is attached to Ctx.Context, handlers should pass it on to their I/O.
+// When it is exceeded the message is settled by OnTimeout and ErrTimeout is returned;
+// a late acknowledgement of the abandoned handler then returns volta.ErrAlreadySettled.
+// The rest of the chain runs on a detached copy of the context (see volta.Ctx.Detach),
+// locals it sets are not seen by the middlewares running before this one.
+func New(config ...Config) volta.Handler {
+	cfg := configDefault(config...)
+
+	return func(c *volta.Ctx) error {
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithDeadline(c.Context(), cfg.deadline(c, time.Now()))
+		defer cancel()
+
+		if ctx.Err() != nil {
+			if err := cfg.OnTimeout(c); err != nil {
+				return err
+			}
+			return ErrTimeout
+		}
+
+		c.SetContext(ctx)
+
+		type result struct {
+			err      error
+			panicked *chainPanic
+		}
+		done := make(chan result, 1)
+
+		// The abandoned chain keeps running after a timeout, it must not touch c anymore
+		detached := c.Detach()
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- result{panicked: &chainPanic{value: r, stack: debug.Stack()}}
+				}
+			}()
+
+			done <- result{err: detached.Next()}
+		}()
+
+		select {
+		case r := <-done:
+			if r.panicked != nil {
+				// Hand the panic back to the middlewares running before this one, e.g. recover
+				panic(*r.panicked)
+			}
+			return r.err
+		case <-ctx.Done():
+			if err := cfg.OnTimeout(c); err != nil && !errors.Is(err, volta.ErrAlreadySettled) {
+				return err
+			}
+			return ErrTimeout
+		}
+	}
+}
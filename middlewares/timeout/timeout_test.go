@@ -0,0 +1,117 @@
+package timeout
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/voltatest"
+)
+
+func TestConfig_deadline(t *testing.T) {
+	now := time.Now()
+	cfg := configDefault(Config{Timeout: time.Minute, FromExpiration: true, DeadlineHeader: "x-deadline"})
+
+	tests := []struct {
+		name     string
+		delivery amqp091.Delivery
+		expected time.Time
+	}{
+		{"timeout", amqp091.Delivery{}, now.Add(time.Minute)},
+		{"expiration from arrival", amqp091.Delivery{Expiration: "5000"}, now.Add(5 * time.Second)},
+		{"expiration from timestamp", amqp091.Delivery{Expiration: "5000", Timestamp: now.Add(-2 * time.Second)}, now.Add(3 * time.Second)},
+		{"longer expiration", amqp091.Delivery{Expiration: "120000"}, now.Add(time.Minute)},
+		{"header milliseconds", amqp091.Delivery{Headers: amqp091.Table{"x-deadline": now.Add(time.Second).UnixMilli()}}, time.UnixMilli(now.Add(time.Second).UnixMilli())},
+		{"header string", amqp091.Delivery{Headers: amqp091.Table{"x-deadline": strconv.FormatInt(now.Add(time.Second).UnixMilli(), 10)}}, time.UnixMilli(now.Add(time.Second).UnixMilli())},
+		{"header rfc3339", amqp091.Delivery{Headers: amqp091.Table{"x-deadline": now.Add(2 * time.Second).Format(time.RFC3339Nano)}}, now.Add(2 * time.Second)},
+		{"invalid header", amqp091.Delivery{Headers: amqp091.Table{"x-deadline": "soon"}}, now.Add(time.Minute)},
+	}
+
+	for _, test := range tests {
+		deadline := cfg.deadline(&volta.Ctx{Delivery: test.delivery}, now)
+		if !deadline.Equal(test.expected) {
+			t.Errorf("%s: deadline is %s, expected %s", test.name, deadline, test.expected)
+		}
+	}
+}
+
+func TestNew_expired(t *testing.T) {
+	called := false
+	handler := New(Config{
+		DeadlineHeader: "x-deadline",
+		OnTimeout: func(c *volta.Ctx) error {
+			called = true
+			return nil
+		},
+	})
+
+	ctx := &volta.Ctx{Delivery: amqp091.Delivery{Headers: amqp091.Table{"x-deadline": time.Now().Add(-time.Second).UnixMilli()}}}
+
+	if err := handler(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Handler error = %v, expected %v", err, ErrTimeout)
+	}
+
+	if !called {
+		t.Error("OnTimeout was not called for an expired message")
+	}
+}
+
+func TestNew_context(t *testing.T) {
+	handler := New(Config{Timeout: time.Minute})
+	ctx := &volta.Ctx{}
+
+	if err := handler(ctx); err != nil {
+		t.Errorf("Handler error = %v", err)
+	}
+
+	if _, ok := ctx.Context().Deadline(); !ok {
+		t.Error("No deadline attached to the context")
+	}
+}
+
+func TestNew_abandoned(t *testing.T) {
+	late := make(chan error, 1)
+	handler := New(Config{Timeout: 50 * time.Millisecond, OnTimeout: Requeue})
+
+	result := voltatest.Run(voltatest.NewMessage([]byte("test")), handler, func(c *volta.Ctx) error {
+		<-c.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+
+		// TEST: the abandoned handler cannot settle the message again
+		c.Locals("late", true)
+		late <- c.Ack(false)
+		return nil
+	})
+
+	if !errors.Is(result.Err, ErrTimeout) || !result.Nacked() {
+		t.Errorf("Result is %v, %s, expected a timeout and a nack", result.Err, result.Settlement)
+	}
+
+	if err := <-late; !errors.Is(err, volta.ErrAlreadySettled) {
+		t.Errorf("Late Ack() error = %v, expected %v", err, volta.ErrAlreadySettled)
+	}
+
+	if result.Ctx.Locals("late") != nil {
+		t.Error("Abandoned handler changed the context of the middleware")
+	}
+}
+
+func TestNew_panic(t *testing.T) {
+	result := voltatest.Run(voltatest.NewMessage([]byte("test")), New(), func(c *volta.Ctx) error {
+		panic("boom")
+	})
+
+	// TEST: the panic is passed on with the stack of the handler
+	err, ok := result.Panic.(error)
+	if !ok {
+		t.Fatalf("Panic is %v, expected an error", result.Panic)
+	}
+
+	if !strings.HasPrefix(err.Error(), "boom") || !strings.Contains(err.Error(), "TestNew_panic") {
+		t.Errorf("Panic is %q, expected the value and the stack of the handler", err)
+	}
+}
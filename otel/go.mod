@@ -0,0 +1,20 @@
+module github.com/volta-dev/volta/otel
+
+go 1.21
+
+require (
+	github.com/rabbitmq/amqp091-go v1.8.1
+	github.com/volta-dev/volta v0.0.0
+	go.opentelemetry.io/otel v1.25.0
+	go.opentelemetry.io/otel/sdk v1.25.0
+	go.opentelemetry.io/otel/trace v1.25.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel/metric v1.25.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+)
+
+replace github.com/volta-dev/volta => ..
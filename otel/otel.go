@@ -0,0 +1,188 @@
+// Package otel propagates OpenTelemetry trace context through AMQP headers
+// and records spans for publishing, consuming and RPC requests.
+package otel
+
+import (
+	"context"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+	global "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer spans are created with
+const instrumentationName = "github.com/volta-dev/volta/otel"
+
+type Config struct {
+	// TracerProvider creates the tracer, the global provider is used when nil
+	TracerProvider trace.TracerProvider
+
+	// Propagator injects and extracts the trace context, W3C trace context and baggage when nil
+	Propagator propagation.TextMapPropagator
+
+	// Attributes are added to every span
+	Attributes []attribute.KeyValue
+}
+
+var ConfigDefault = Config{
+	TracerProvider: nil,
+	Propagator:     nil,
+	Attributes:     nil,
+}
+
+func configDefault(config ...Config) Config {
+	cfg := ConfigDefault
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = global.GetTracerProvider()
+	}
+	if cfg.Propagator == nil {
+		cfg.Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+
+	return cfg
+}
+
+// Tracer implements volta.Tracer with OpenTelemetry
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	attributes []attribute.KeyValue
+}
+
+var _ volta.Tracer = (*Tracer)(nil)
+
+// New creates a tracer, set it as volta.Config.Tracer
+func New(config ...Config) *Tracer {
+	cfg := configDefault(config...)
+
+	return &Tracer{
+		tracer:     cfg.TracerProvider.Tracer(instrumentationName),
+		propagator: cfg.Propagator,
+		attributes: cfg.Attributes,
+	}
+}
+
+// StartPublish starts a producer span and injects its context into the message headers
+func (t *Tracer) StartPublish(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(error)) {
+	ctx, span := t.tracer.Start(ctx, spanName(exchange, "publish"),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(t.publishAttributes(exchange, routingKey, msg)...),
+	)
+
+	t.inject(ctx, msg)
+
+	return ctx, func(err error) {
+		recordError(span, err)
+		span.End()
+	}
+}
+
+// StartRequest starts a client span and injects its context into the request headers.
+// The span is linked to the span the reply was published in.
+func (t *Tracer) StartRequest(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(*amqp091.Delivery, error)) {
+	attributes := t.publishAttributes(exchange, routingKey, msg)
+	if msg.CorrelationId != "" {
+		attributes = append(attributes, semconv.MessagingMessageConversationID(msg.CorrelationId))
+	}
+
+	ctx, span := t.tracer.Start(ctx, spanName(exchange, "request"),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+
+	t.inject(ctx, msg)
+
+	return ctx, func(reply *amqp091.Delivery, err error) {
+		if reply != nil {
+			replyCtx := t.propagator.Extract(context.Background(), headerCarrier(reply.Headers))
+			if replySpan := trace.SpanContextFromContext(replyCtx); replySpan.IsValid() {
+				span.AddLink(trace.Link{SpanContext: replySpan})
+			}
+		}
+
+		recordError(span, err)
+		span.End()
+	}
+}
+
+// StartConsume extracts the trace context from the message headers and starts a consumer span as its child
+func (t *Tracer) StartConsume(ctx context.Context, delivery *amqp091.Delivery) (context.Context, func(error)) {
+	ctx = t.propagator.Extract(ctx, headerCarrier(delivery.Headers))
+
+	attributes := append([]attribute.KeyValue{
+		semconv.MessagingSystemRabbitmq,
+		semconv.MessagingOperationDeliver,
+		semconv.MessagingDestinationName(delivery.Exchange),
+		semconv.MessagingRabbitmqDestinationRoutingKey(delivery.RoutingKey),
+		semconv.MessagingMessageBodySize(len(delivery.Body)),
+	}, t.attributes...)
+	if delivery.MessageId != "" {
+		attributes = append(attributes, semconv.MessagingMessageID(delivery.MessageId))
+	}
+	if delivery.CorrelationId != "" {
+		attributes = append(attributes, semconv.MessagingMessageConversationID(delivery.CorrelationId))
+	}
+
+	ctx, span := t.tracer.Start(ctx, spanName(delivery.Exchange, "deliver"),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...),
+	)
+
+	return ctx, func(err error) {
+		recordError(span, err)
+		span.End()
+	}
+}
+
+func (t *Tracer) publishAttributes(exchange, routingKey string, msg *amqp091.Publishing) []attribute.KeyValue {
+	attributes := append([]attribute.KeyValue{
+		semconv.MessagingSystemRabbitmq,
+		semconv.MessagingOperationPublish,
+		semconv.MessagingDestinationName(exchange),
+		semconv.MessagingRabbitmqDestinationRoutingKey(routingKey),
+		semconv.MessagingMessageBodySize(len(msg.Body)),
+	}, t.attributes...)
+	if msg.MessageId != "" {
+		attributes = append(attributes, semconv.MessagingMessageID(msg.MessageId))
+	}
+
+	return attributes
+}
+
+// inject writes the trace context into a copy of the message headers, the caller's table is left untouched
+func (t *Tracer) inject(ctx context.Context, msg *amqp091.Publishing) {
+	headers := make(amqp091.Table, len(msg.Headers)+2)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	t.propagator.Inject(ctx, headerCarrier(headers))
+	msg.Headers = headers
+}
+
+// spanName follows the "<destination> <operation>" convention, the default exchange is anonymous
+func spanName(exchange, operation string) string {
+	if exchange == "" {
+		exchange = "(default)"
+	}
+
+	return exchange + " " + operation
+}
+
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
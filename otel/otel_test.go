@@ -0,0 +1,158 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracer() (*Tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	return New(Config{TracerProvider: provider}), exporter
+}
+
+// deliver turns a publishing into the delivery a consumer would receive
+func deliver(exchange, routingKey string, msg amqp091.Publishing) *amqp091.Delivery {
+	return &amqp091.Delivery{
+		Exchange:      exchange,
+		RoutingKey:    routingKey,
+		Headers:       msg.Headers,
+		MessageId:     msg.MessageId,
+		CorrelationId: msg.CorrelationId,
+		ReplyTo:       msg.ReplyTo,
+		Body:          msg.Body,
+	}
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	t.Fatalf("span %q not recorded, got %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func TestTracer_PublishConsume(t *testing.T) {
+	tracer, exporter := newTestTracer()
+
+	headers := amqp091.Table{"x-custom": "value"}
+	msg := amqp091.Publishing{MessageId: "42", Headers: headers, Body: []byte("hello")}
+
+	_, end := tracer.StartPublish(context.Background(), "orders", "orders.created", &msg)
+	end(nil)
+
+	if _, ok := msg.Headers["traceparent"]; !ok {
+		t.Fatal("traceparent was not injected")
+	}
+	if len(headers) != 1 {
+		t.Fatal("caller's headers were modified")
+	}
+
+	ctx, end := tracer.StartConsume(context.Background(), deliver("orders", "orders.created", msg))
+	end(nil)
+
+	spans := exporter.GetSpans()
+	producer := findSpan(t, spans, "orders publish")
+	consumer := findSpan(t, spans, "orders deliver")
+
+	if producer.SpanKind != trace.SpanKindProducer || consumer.SpanKind != trace.SpanKindConsumer {
+		t.Fatalf("unexpected span kinds %v, %v", producer.SpanKind, consumer.SpanKind)
+	}
+	if consumer.Parent.SpanID() != producer.SpanContext.SpanID() {
+		t.Fatal("consumer span is not a child of the producer span")
+	}
+	if trace.SpanContextFromContext(ctx).SpanID() != consumer.SpanContext.SpanID() {
+		t.Fatal("returned context does not carry the consumer span")
+	}
+
+	expected := []interface{}{
+		semconv.MessagingSystemRabbitmq,
+		semconv.MessagingDestinationName("orders"),
+		semconv.MessagingRabbitmqDestinationRoutingKey("orders.created"),
+		semconv.MessagingMessageID("42"),
+		semconv.MessagingMessageBodySize(5),
+	}
+	for _, attribute := range expected {
+		found := false
+		for _, actual := range consumer.Attributes {
+			if actual == attribute {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("consumer span misses attribute %v", attribute)
+		}
+	}
+}
+
+func TestTracer_RequestReplyLink(t *testing.T) {
+	tracer, exporter := newTestTracer()
+
+	request := amqp091.Publishing{CorrelationId: "abc", ReplyTo: "amq.gen-reply", Body: []byte("ping")}
+	_, endRequest := tracer.StartRequest(context.Background(), "", "rpc", &request)
+
+	ctx, endConsume := tracer.StartConsume(context.Background(), deliver("", "rpc", request))
+
+	reply := amqp091.Publishing{CorrelationId: "abc", Body: []byte("pong")}
+	_, endReply := tracer.StartPublish(ctx, "", request.ReplyTo, &reply)
+	endReply(nil)
+	endConsume(nil)
+
+	endRequest(deliver("", request.ReplyTo, reply), nil)
+
+	spans := exporter.GetSpans()
+	client := findSpan(t, spans, "(default) request")
+	consumer := findSpan(t, spans, "(default) deliver")
+	replySpan := findSpan(t, spans, "(default) publish")
+
+	if consumer.Parent.SpanID() != client.SpanContext.SpanID() {
+		t.Fatal("consumer span is not a child of the request span")
+	}
+	if replySpan.Parent.SpanID() != consumer.SpanContext.SpanID() {
+		t.Fatal("reply span is not a child of the consumer span")
+	}
+	if len(client.Links) != 1 || client.Links[0].SpanContext.SpanID() != replySpan.SpanContext.SpanID() {
+		t.Fatal("request span is not linked to the reply span")
+	}
+}
+
+func TestTracer_RecordsError(t *testing.T) {
+	tracer, exporter := newTestTracer()
+
+	_, end := tracer.StartConsume(context.Background(), &amqp091.Delivery{Exchange: "orders"})
+	end(errors.New("handler failed"))
+
+	span := findSpan(t, exporter.GetSpans(), "orders deliver")
+	if span.Status.Code != codes.Error || span.Status.Description != "handler failed" {
+		t.Fatalf("unexpected status %+v", span.Status)
+	}
+	if len(span.Events) != 1 || span.Events[0].Name != "exception" {
+		t.Fatal("error was not recorded")
+	}
+}
+
+func TestTracer_NoParent(t *testing.T) {
+	tracer, exporter := newTestTracer()
+
+	_, end := tracer.StartConsume(context.Background(), &amqp091.Delivery{})
+	end(nil)
+
+	span := findSpan(t, exporter.GetSpans(), "(default) deliver")
+	if span.Parent.IsValid() {
+		t.Fatal("span without propagated context must be a root span")
+	}
+}
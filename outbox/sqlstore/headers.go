@@ -0,0 +1,206 @@
+package sqlstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// header is a header value stored with its type, so it is published again exactly as it was added
+type header struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// encodeHeaders marshals the headers to JSON, keeping the type of every value
+func encodeHeaders(headers amqp091.Table) ([]byte, error) {
+	table, err := encodeTable(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(table)
+}
+
+// decodeHeaders restores headers marshaled by encodeHeaders
+func decodeHeaders(data []byte) (amqp091.Table, error) {
+	var table map[string]header
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+
+	return decodeTable(table)
+}
+
+func encodeTable(table amqp091.Table) (map[string]header, error) {
+	encoded := make(map[string]header, len(table))
+	for key, value := range table {
+		h, err := encodeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("sqlstore: Header %q: %w", key, err)
+		}
+		encoded[key] = h
+	}
+
+	return encoded, nil
+}
+
+func decodeTable(table map[string]header) (amqp091.Table, error) {
+	decoded := make(amqp091.Table, len(table))
+	for key, h := range table {
+		value, err := decodeValue(h)
+		if err != nil {
+			return nil, fmt.Errorf("sqlstore: Header %q: %w", key, err)
+		}
+		decoded[key] = value
+	}
+
+	return decoded, nil
+}
+
+func encodeValue(value interface{}) (header, error) {
+	var name string
+	var v interface{} = value
+
+	switch x := value.(type) {
+	case nil:
+		return header{Type: "nil"}, nil
+	case bool:
+		name = "bool"
+	case byte:
+		name = "byte"
+	case int8:
+		name = "int8"
+	case int16:
+		name = "int16"
+	case int32:
+		name = "int32"
+	case int64:
+		name = "int64"
+	case int:
+		name = "int"
+	case float32:
+		name = "float32"
+	case float64:
+		name = "float64"
+	case string:
+		name = "string"
+	case []byte:
+		name = "bytes"
+	case amqp091.Decimal:
+		name = "decimal"
+	case time.Time:
+		name = "time"
+		v = x.Format(time.RFC3339Nano)
+	case amqp091.Table:
+		table, err := encodeTable(x)
+		if err != nil {
+			return header{}, err
+		}
+		name, v = "table", table
+	case []interface{}:
+		array := make([]header, len(x))
+		for i, item := range x {
+			h, err := encodeValue(item)
+			if err != nil {
+				return header{}, err
+			}
+			array[i] = h
+		}
+		name, v = "array", array
+	default:
+		return header{}, fmt.Errorf("unsupported type %T", value)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return header{}, err
+	}
+
+	return header{Type: name, Value: data}, nil
+}
+
+func decodeValue(h header) (interface{}, error) {
+	var err error
+
+	switch h.Type {
+	case "nil":
+		return nil, nil
+	case "bool":
+		var v bool
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "byte":
+		var v byte
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "int8":
+		var v int8
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "int16":
+		var v int16
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "int32":
+		var v int32
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "int64":
+		var v int64
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "int":
+		var v int
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "float32":
+		var v float32
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "float64":
+		var v float64
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "string":
+		var v string
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "bytes":
+		var v []byte
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "decimal":
+		var v amqp091.Decimal
+		err = json.Unmarshal(h.Value, &v)
+		return v, err
+	case "time":
+		var v string
+		if err = json.Unmarshal(h.Value, &v); err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, v)
+	case "table":
+		var v map[string]header
+		if err = json.Unmarshal(h.Value, &v); err != nil {
+			return nil, err
+		}
+		return decodeTable(v)
+	case "array":
+		var v []header
+		if err = json.Unmarshal(h.Value, &v); err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, len(v))
+		for i, item := range v {
+			if array[i], err = decodeValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q", h.Type)
+}
@@ -0,0 +1,219 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/sqlbind"
+)
+
+// Executor runs statements, both *sql.DB and *sql.Tx implement it
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type Config struct {
+	// Table is the name of the outbox table
+	Table string
+
+	// Placeholder returns the bind parameter for the n-th argument, use sqlbind.Dollar for PostgreSQL
+	Placeholder sqlbind.Placeholder
+
+	// Schema is the statement creating the outbox table, %s is replaced by Table
+	Schema string
+}
+
+var ConfigDefault = Config{
+	Table:       "volta_outbox",
+	Placeholder: sqlbind.Question,
+	Schema: `CREATE TABLE IF NOT EXISTS %s (
+	id           VARCHAR(64)  NOT NULL PRIMARY KEY,
+	exchange     VARCHAR(255) NOT NULL,
+	routing_key  VARCHAR(255) NOT NULL,
+	content_type VARCHAR(255) NOT NULL,
+	headers      TEXT,
+	body         BLOB,
+	attempts     INTEGER      NOT NULL DEFAULT 0,
+	last_error   TEXT,
+	created_at   BIGINT       NOT NULL,
+	available_at BIGINT       NOT NULL,
+	sent_at      BIGINT
+)`,
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+
+	cfg := config[0]
+
+	if cfg.Table == "" {
+		cfg.Table = ConfigDefault.Table
+	}
+	if cfg.Placeholder == nil {
+		cfg.Placeholder = ConfigDefault.Placeholder
+	}
+	if cfg.Schema == "" {
+		cfg.Schema = ConfigDefault.Schema
+	}
+
+	return cfg
+}
+
+var _ volta.OutboxStore = (*Store)(nil)
+
+// Store is a volta.OutboxStore backed by a database/sql table
+type Store struct {
+	db     *sql.DB
+	config Config
+}
+
+// New creates a store using the given database for relaying
+func New(db *sql.DB, config ...Config) *Store {
+	return &Store{db: db, config: configDefault(config...)}
+}
+
+// Migrate creates the outbox table if it does not exist
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(s.config.Schema, s.config.Table))
+	return err
+}
+
+// query adapts the statement to the configured table and placeholder
+func (s *Store) query(statement string) string {
+	return sqlbind.Query(statement, s.config.Table, s.config.Placeholder)
+}
+
+// Add inserts the message using tx, which must be an Executor (usually the caller's *sql.Tx).
+// Headers are stored as JSON along with their types, so they are published with the types they were added with.
+func (s *Store) Add(ctx context.Context, tx interface{}, message volta.OutboxMessage) error {
+	executor, ok := tx.(Executor)
+	if !ok {
+		return errors.New("sqlstore: Transaction must be a *sql.Tx or implement Executor")
+	}
+
+	var headers []byte
+	if len(message.Headers) > 0 {
+		var err error
+		if headers, err = encodeHeaders(message.Headers); err != nil {
+			return err
+		}
+	}
+
+	createdAt := message.CreatedAt.UnixMilli()
+
+	_, err := executor.ExecContext(ctx, s.query(
+		`INSERT INTO %s (id, exchange, routing_key, content_type, headers, body, attempts, created_at, available_at) VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)`,
+	), message.ID, message.Exchange, message.RoutingKey, message.ContentType, headers, message.Body, createdAt, createdAt)
+
+	return err
+}
+
+// Claim selects due messages and leases them by moving their availability into the future.
+// A message leased by another relay in the meantime is skipped.
+func (s *Store) Claim(ctx context.Context, limit int, lease time.Duration) ([]volta.OutboxMessage, error) {
+	now := time.Now()
+
+	rows, err := s.db.QueryContext(ctx, s.query(
+		`SELECT id, exchange, routing_key, content_type, headers, body, attempts, created_at, available_at FROM %s WHERE sent_at IS NULL AND available_at <= ? ORDER BY created_at LIMIT ?`,
+	), now.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		message     volta.OutboxMessage
+		availableAt int64
+	}
+
+	candidates := make([]candidate, 0, limit)
+	for rows.Next() {
+		var c candidate
+		var headers []byte
+		var createdAt int64
+
+		if err := rows.Scan(
+			&c.message.ID,
+			&c.message.Exchange,
+			&c.message.RoutingKey,
+			&c.message.ContentType,
+			&headers,
+			&c.message.Body,
+			&c.message.Attempts,
+			&createdAt,
+			&c.availableAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if len(headers) > 0 {
+			if c.message.Headers, err = decodeHeaders(headers); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		c.message.CreatedAt = time.UnixMilli(createdAt)
+
+		candidates = append(candidates, c)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := make([]volta.OutboxMessage, 0, len(candidates))
+	leasedUntil := now.Add(lease).UnixMilli()
+
+	for _, c := range candidates {
+		result, err := s.db.ExecContext(ctx, s.query(
+			`UPDATE %s SET available_at = ? WHERE id = ? AND available_at = ? AND sent_at IS NULL`,
+		), leasedUntil, c.message.ID, c.availableAt)
+		if err != nil {
+			return claimed, err
+		}
+
+		if affected, err := result.RowsAffected(); err == nil && affected == 1 {
+			claimed = append(claimed, c.message)
+		}
+	}
+
+	return claimed, nil
+}
+
+// MarkSent marks the message as published
+func (s *Store) MarkSent(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.query(
+		`UPDATE %s SET sent_at = ? WHERE id = ?`,
+	), time.Now().UnixMilli(), id)
+
+	return err
+}
+
+// MarkFailed records a failed attempt and makes the message due again at retryAt
+func (s *Store) MarkFailed(ctx context.Context, id string, retryAt time.Time, cause error) error {
+	_, err := s.db.ExecContext(ctx, s.query(
+		`UPDATE %s SET attempts = attempts + 1, last_error = ?, available_at = ? WHERE id = ?`,
+	), cause.Error(), retryAt.UnixMilli(), id)
+
+	return err
+}
+
+// Purge deletes the messages sent before the given time
+func (s *Store) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.query(
+		`DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < ?`,
+	), before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
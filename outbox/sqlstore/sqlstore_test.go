@@ -0,0 +1,298 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/sqlbind"
+	_ "modernc.org/sqlite"
+)
+
+func newTestStore(t *testing.T) *Store {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store := New(db)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatalf("Store.Migrate() error = %v", err)
+	}
+
+	return store
+}
+
+func add(t *testing.T, store *Store, message volta.OutboxMessage, commit bool) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		t.Fatalf("DB.Begin() error = %v", err)
+	}
+
+	if err := store.Add(context.Background(), tx, message); err != nil {
+		t.Fatalf("Store.Add() error = %v", err)
+	}
+
+	if commit {
+		err = tx.Commit()
+	} else {
+		err = tx.Rollback()
+	}
+	if err != nil {
+		t.Fatalf("Tx.Commit() / Tx.Rollback() error = %v", err)
+	}
+}
+
+func TestStore_Claim(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	add(t, store, volta.OutboxMessage{
+		ID:          "committed",
+		Exchange:    "test",
+		RoutingKey:  "test.created",
+		ContentType: "application/json",
+		Headers:     amqp091.Table{"x-test": "yes"},
+		Body:        []byte(`{"id":1}`),
+		CreatedAt:   time.Now(),
+	}, true)
+	add(t, store, volta.OutboxMessage{ID: "rolled-back", CreatedAt: time.Now()}, false)
+
+	messages, err := store.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Store.Claim() error = %v", err)
+	}
+
+	if len(messages) != 1 || messages[0].ID != "committed" {
+		t.Fatalf("Claimed %v, expected only the committed message", messages)
+	}
+
+	if string(messages[0].Body) != `{"id":1}` || messages[0].Headers["x-test"] != "yes" {
+		t.Errorf("Claimed message is %+v, expected body and headers to round-trip", messages[0])
+	}
+
+	// TEST: a leased message is not claimed twice
+	if messages, err := store.Claim(ctx, 10, time.Minute); err != nil || len(messages) != 0 {
+		t.Errorf("Store.Claim() = %v, %v, expected no messages while leased", messages, err)
+	}
+}
+
+func TestStore_MarkFailedAndSent(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	add(t, store, volta.OutboxMessage{ID: "retry", CreatedAt: time.Now()}, true)
+
+	if _, err := store.Claim(ctx, 10, time.Minute); err != nil {
+		t.Fatalf("Store.Claim() error = %v", err)
+	}
+
+	// TEST: a failed message becomes due again at the retry time
+	if err := store.MarkFailed(ctx, "retry", time.Now().Add(-time.Second), errors.New("nacked")); err != nil {
+		t.Fatalf("Store.MarkFailed() error = %v", err)
+	}
+
+	messages, err := store.Claim(ctx, 10, time.Minute)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Store.Claim() = %v, %v, expected the failed message", messages, err)
+	}
+
+	if messages[0].Attempts != 1 {
+		t.Errorf("Attempts is %d, expected 1", messages[0].Attempts)
+	}
+
+	// TEST: a sent message is never claimed again
+	if err := store.MarkSent(ctx, "retry"); err != nil {
+		t.Fatalf("Store.MarkSent() error = %v", err)
+	}
+
+	if err := store.MarkFailed(ctx, "retry", time.Now().Add(-time.Second), errors.New("late")); err != nil {
+		t.Fatalf("Store.MarkFailed() error = %v", err)
+	}
+
+	if messages, err := store.Claim(ctx, 10, time.Minute); err != nil || len(messages) != 0 {
+		t.Errorf("Store.Claim() = %v, %v, expected no messages after MarkSent", messages, err)
+	}
+
+	if purged, err := store.Purge(ctx, time.Now().Add(time.Second)); err != nil || purged != 1 {
+		t.Errorf("Store.Purge() = %d, %v, expected 1", purged, err)
+	}
+}
+
+func TestStore_headers(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	headers := amqp091.Table{
+		"bool":    true,
+		"int32":   int32(-3),
+		"int64":   int64(1) << 60,
+		"float64": 1.5,
+		"string":  "test",
+		"bytes":   []byte("test"),
+		"decimal": amqp091.Decimal{Scale: 2, Value: 314},
+		"time":    time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC),
+		"table":   amqp091.Table{"count": int64(1)},
+		"array":   []interface{}{"a", int16(2)},
+		"nil":     nil,
+	}
+	add(t, store, volta.OutboxMessage{ID: "headers", Headers: headers, CreatedAt: time.Now()}, true)
+
+	messages, err := store.Claim(ctx, 10, time.Minute)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Store.Claim() = %v, %v, expected the message", messages, err)
+	}
+
+	// TEST: header values keep their types
+	if !reflect.DeepEqual(messages[0].Headers, headers) {
+		t.Errorf("Headers are %#v, expected %#v", messages[0].Headers, headers)
+	}
+
+	// TEST: a value that cannot be published is refused
+	tx, err := store.db.Begin()
+	if err != nil {
+		t.Fatalf("DB.Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := store.Add(ctx, tx, volta.OutboxMessage{ID: "invalid", Headers: amqp091.Table{"map": map[string]int{}}}); err == nil {
+		t.Error("Store.Add() accepted an unsupported header value")
+	}
+}
+
+func TestStore_relay(t *testing.T) {
+	store := newTestStore(t)
+	transport := volta.NewMemoryTransport()
+
+	app := volta.New(volta.Config{Transport: transport, DisableLogging: true})
+	app.UseOutbox(store, volta.OutboxOptions{PollInterval: 10 * time.Millisecond, RetryBackoff: 100 * time.Millisecond})
+
+	go app.Listen()
+	t.Cleanup(func() { app.Close() })
+
+	// TEST: a message to a missing exchange is marked failed and retried with backoff
+	publish(t, app, store, volta.OutboxMessage{ID: "order-1", Exchange: "orders", RoutingKey: "orders.created", Body: []byte("1")})
+
+	var attempts int
+	var lastError sql.NullString
+	var availableAt int64
+	waitFor(t, func() bool {
+		store.db.QueryRow(`SELECT attempts, last_error, available_at FROM volta_outbox WHERE id = 'order-1'`).Scan(&attempts, &lastError, &availableAt)
+		return attempts > 0
+	}, "the message was not marked failed")
+
+	if !lastError.Valid || lastError.String == "" {
+		t.Error("The cause of the failure was not recorded")
+	}
+	if retryIn := time.Until(time.UnixMilli(availableAt)); retryIn < 50*time.Millisecond {
+		t.Errorf("The message is retried in %s, expected the retry backoff", retryIn)
+	}
+
+	channel := openChannel(t, transport)
+	if err := channel.ExchangeDeclare("orders", "topic", true, false, false, false, nil); err != nil {
+		t.Fatalf("ExchangeDeclare() error = %v", err)
+	}
+	if _, err := channel.QueueDeclare("orders", true, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare() error = %v", err)
+	}
+	if err := channel.QueueBind("orders", "orders.created", "orders", false, nil); err != nil {
+		t.Fatalf("QueueBind() error = %v", err)
+	}
+
+	// TEST: messages are published with their headers and marked sent
+	publish(t, app, store, volta.OutboxMessage{
+		ID:          "order-2",
+		Exchange:    "orders",
+		RoutingKey:  "orders.created",
+		ContentType: "text/plain",
+		Headers:     amqp091.Table{"version": int32(2)},
+		Body:        []byte("2"),
+	})
+
+	deliveries, err := channel.Consume("orders", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	received := map[string]amqp091.Delivery{}
+	for len(received) < 2 {
+		select {
+		case delivery := <-deliveries:
+			received[delivery.MessageId] = delivery
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Received %d messages, expected 2", len(received))
+		}
+	}
+
+	if delivery := received["order-2"]; string(delivery.Body) != "2" || delivery.Headers["version"] != int32(2) {
+		t.Errorf("Message is %s with headers %v, expected 2 with version 2", delivery.Body, delivery.Headers)
+	}
+
+	waitFor(t, func() bool {
+		var unsent int
+		store.db.QueryRow(`SELECT COUNT(*) FROM volta_outbox WHERE sent_at IS NULL`).Scan(&unsent)
+		return unsent == 0
+	}, "the messages were not marked sent")
+}
+
+func publish(t *testing.T, app *volta.App, store *Store, message volta.OutboxMessage) {
+	t.Helper()
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		t.Fatalf("DB.Begin() error = %v", err)
+	}
+
+	if err := app.PublishOutboxMessage(context.Background(), tx, message); err != nil {
+		t.Fatalf("App.PublishOutboxMessage() error = %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Tx.Commit() error = %v", err)
+	}
+}
+
+func openChannel(t *testing.T, transport *volta.MemoryTransport) volta.Channel {
+	t.Helper()
+
+	connection, err := transport.Dial("")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	channel, err := connection.Channel()
+	if err != nil {
+		t.Fatalf("Channel() error = %v", err)
+	}
+
+	return channel
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	store := &Store{config: configDefault(Config{Placeholder: sqlbind.Dollar})}
+
+	query := store.query(`UPDATE %s SET a = ? WHERE b = ?`)
+	if query != `UPDATE volta_outbox SET a = $1 WHERE b = $2` {
+		t.Errorf("Query is %s", query)
+	}
+}
@@ -0,0 +1,185 @@
+// Package prometheus exposes the volta metrics as Prometheus collectors.
+package prometheus
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/volta-dev/volta"
+)
+
+type Config struct {
+	// Namespace prefixes every metric name
+	Namespace string
+
+	// Registerer the collectors are registered with, the default registerer is used when nil
+	Registerer prometheus.Registerer
+
+	// Buckets of the latency histograms in seconds
+	Buckets []float64
+
+	// ConstLabels are added to every metric
+	ConstLabels prometheus.Labels
+}
+
+var ConfigDefault = Config{
+	Namespace:   "volta",
+	Registerer:  nil,
+	Buckets:     prometheus.DefBuckets,
+	ConstLabels: nil,
+}
+
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		cfg := ConfigDefault
+		cfg.Registerer = prometheus.DefaultRegisterer
+		return cfg
+	}
+
+	cfg := config[0]
+
+	if cfg.Namespace == "" {
+		cfg.Namespace = ConfigDefault.Namespace
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+	if len(cfg.Buckets) == 0 {
+		cfg.Buckets = ConfigDefault.Buckets
+	}
+
+	return cfg
+}
+
+// Metrics implements volta.Metrics with Prometheus collectors
+type Metrics struct {
+	consumed        *prometheus.CounterVec
+	settled         *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	inFlight        *prometheus.GaugeVec
+	published       *prometheus.CounterVec
+	publishFailures *prometheus.CounterVec
+	publishDuration *prometheus.HistogramVec
+	rpcDuration     *prometheus.HistogramVec
+	rpcTimeouts     *prometheus.CounterVec
+	reconnects      prometheus.Counter
+	connected       prometheus.Gauge
+}
+
+var _ volta.Metrics = (*Metrics)(nil)
+
+// New creates the collectors and registers them, set the result as volta.Config.Metrics
+func New(config ...Config) (*Metrics, error) {
+	cfg := configDefault(config...)
+
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.Namespace, Name: name, Help: help, ConstLabels: cfg.ConstLabels,
+		}, labels)
+	}
+	histogram := func(name, help string, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.Namespace, Name: name, Help: help, ConstLabels: cfg.ConstLabels, Buckets: cfg.Buckets,
+		}, labels)
+	}
+
+	m := &Metrics{
+		consumed:        counter("messages_consumed_total", "Number of messages handed to the handlers.", "queue"),
+		settled:         counter("messages_settled_total", "Number of messages settled, by ack, nack or reject.", "queue", "settlement"),
+		handlerDuration: histogram("handler_duration_seconds", "Time spent in the handlers of a message.", "queue", "status"),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.Namespace, Name: "handlers_in_flight", Help: "Number of messages being handled.", ConstLabels: cfg.ConstLabels,
+		}, []string{"queue"}),
+		published:       counter("messages_published_total", "Number of messages published.", "exchange"),
+		publishFailures: counter("publish_failures_total", "Number of messages that could not be published.", "exchange"),
+		publishDuration: histogram("publish_duration_seconds", "Time spent publishing a message.", "exchange"),
+		rpcDuration:     histogram("rpc_duration_seconds", "Round trip time of RPC requests.", "exchange", "status"),
+		rpcTimeouts:     counter("rpc_timeouts_total", "Number of RPC requests without a reply in time.", "exchange"),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.Namespace, Name: "reconnect_attempts_total", Help: "Number of attempts to reconnect to RabbitMQ.", ConstLabels: cfg.ConstLabels,
+		}),
+		connected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: cfg.Namespace, Name: "connection_up", Help: "Whether the connection to RabbitMQ is established.", ConstLabels: cfg.ConstLabels,
+		}),
+	}
+
+	for _, collector := range m.collectors() {
+		if err := cfg.Registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// MustNew is like New but panics if the collectors cannot be registered
+func MustNew(config ...Config) *Metrics {
+	m, err := New(config...)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.consumed, m.settled, m.handlerDuration, m.inFlight,
+		m.published, m.publishFailures, m.publishDuration,
+		m.rpcDuration, m.rpcTimeouts,
+		m.reconnects, m.connected,
+	}
+}
+
+func (m *Metrics) MessageConsumed(queue string) {
+	m.consumed.WithLabelValues(queue).Inc()
+}
+
+func (m *Metrics) MessageSettled(queue string, settlement volta.Settlement) {
+	m.settled.WithLabelValues(queue, string(settlement)).Inc()
+}
+
+func (m *Metrics) HandlerStarted(queue string) {
+	m.inFlight.WithLabelValues(queue).Inc()
+}
+
+func (m *Metrics) HandlerFinished(queue string, duration time.Duration, err error) {
+	m.inFlight.WithLabelValues(queue).Dec()
+	m.handlerDuration.WithLabelValues(queue, status(err)).Observe(duration.Seconds())
+}
+
+func (m *Metrics) MessagePublished(exchange string, duration time.Duration, err error) {
+	m.published.WithLabelValues(exchange).Inc()
+	m.publishDuration.WithLabelValues(exchange).Observe(duration.Seconds())
+	if err != nil {
+		m.publishFailures.WithLabelValues(exchange).Inc()
+	}
+}
+
+func (m *Metrics) RequestFinished(exchange string, duration time.Duration, err error) {
+	m.rpcDuration.WithLabelValues(exchange, status(err)).Observe(duration.Seconds())
+	if errors.Is(err, volta.ErrRequestTimeout) {
+		m.rpcTimeouts.WithLabelValues(exchange).Inc()
+	}
+}
+
+func (m *Metrics) ReconnectAttempt() {
+	m.reconnects.Inc()
+}
+
+func (m *Metrics) ConnectionStateChanged(connected bool) {
+	if connected {
+		m.connected.Set(1)
+	} else {
+		m.connected.Set(0)
+	}
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
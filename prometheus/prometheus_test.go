@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/volta-dev/volta"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New(Config{Registerer: registry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.MessageConsumed("orders")
+	m.HandlerStarted("orders")
+	if value := testutil.ToFloat64(m.inFlight.WithLabelValues("orders")); value != 1 {
+		t.Fatalf("expected 1 handler in flight, got %v", value)
+	}
+	m.HandlerFinished("orders", 10*time.Millisecond, errors.New("failed"))
+	m.MessageSettled("orders", volta.SettlementNack)
+
+	m.MessagePublished("billing", time.Millisecond, nil)
+	m.MessagePublished("billing", time.Millisecond, errors.New("closed"))
+	m.RequestFinished("rpc", time.Second, volta.ErrRequestTimeout)
+	m.ReconnectAttempt()
+	m.ConnectionStateChanged(true)
+
+	tests := []struct {
+		name     string
+		metric   prometheus.Collector
+		expected float64
+	}{
+		{"consumed", m.consumed.WithLabelValues("orders"), 1},
+		{"in flight", m.inFlight.WithLabelValues("orders"), 0},
+		{"settled", m.settled.WithLabelValues("orders", "nack"), 1},
+		{"published", m.published.WithLabelValues("billing"), 2},
+		{"publish failures", m.publishFailures.WithLabelValues("billing"), 1},
+		{"rpc timeouts", m.rpcTimeouts.WithLabelValues("rpc"), 1},
+		{"reconnects", m.reconnects, 1},
+		{"connected", m.connected, 1},
+	}
+
+	for _, test := range tests {
+		if value := testutil.ToFloat64(test.metric); value != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, value)
+		}
+	}
+
+	if count := testutil.CollectAndCount(m.handlerDuration, "volta_handler_duration_seconds"); count != 1 {
+		t.Errorf("expected 1 handler duration series, got %d", count)
+	}
+}
+
+func TestNew_AlreadyRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if _, err := New(Config{Registerer: registry}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(Config{Registerer: registry}); err == nil {
+		t.Fatal("expected an error registering the collectors twice")
+	}
+}
@@ -0,0 +1,38 @@
+// Package sqlbind adapts the statements of the database/sql stores (outbox/sqlstore, the idempotency middleware)
+// to the bind parameters of the driver. The stores keep times as unix milliseconds in BIGINT columns,
+// which every driver reads and writes the same way.
+package sqlbind
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placeholder returns the bind parameter for the n-th argument of a statement, starting at 1
+type Placeholder func(n int) string
+
+// Question is the "?" placeholder used by SQLite and MySQL
+func Question(int) string {
+	return "?"
+}
+
+// Dollar is the "$n" placeholder used by PostgreSQL
+func Dollar(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// Query replaces every "?" of the statement with the placeholder and %s with the table
+func Query(statement, table string, placeholder Placeholder) string {
+	var builder strings.Builder
+	n := 0
+	for _, r := range statement {
+		if r == '?' {
+			n++
+			builder.WriteString(placeholder(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+
+	return fmt.Sprintf(builder.String(), table)
+}
@@ -0,0 +1,19 @@
+package sqlbind
+
+import "testing"
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		placeholder Placeholder
+		expected    string
+	}{
+		{Question, `UPDATE volta_outbox SET a = ? WHERE b = ?`},
+		{Dollar, `UPDATE volta_outbox SET a = $1 WHERE b = $2`},
+	}
+
+	for _, tt := range tests {
+		if query := Query(`UPDATE %s SET a = ? WHERE b = ?`, "volta_outbox", tt.placeholder); query != tt.expected {
+			t.Errorf("Query() = %s, expected %s", query, tt.expected)
+		}
+	}
+}
@@ -3,9 +3,12 @@ package volta
 import (
 	"errors"
 	"fmt"
-	"github.com/fatih/color"
 	"github.com/rabbitmq/amqp091-go"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,9 +18,18 @@ type App struct {
 
 	// RabbitMQ connection
 	connectRetries int
-	baseConnection *amqp091.Connection
+	baseConnection Connection
 	mutex          sync.Mutex
 
+	// Pooled publishing channels
+	pool *channelPool
+
+	// Outbox relay
+	outbox *outboxRelay
+
+	// Scheduled publishing
+	scheduler *scheduler
+
 	// Global Middlewares
 	middlewares []Handler
 
@@ -27,55 +39,100 @@ type App struct {
 	// Queues
 	queues map[string]Queue
 
-	// Handlers
-	handlers map[string][]Handler
+	// Consumers
+	consumers        map[string]*ConsumerHandle
+	consumersStarted bool
+
+	// Batch consumers
+	batchConsumers map[string]*ConsumerHandle
 
 	// Error handlers
 	onBindError OnBindError
+
+	// Lifecycle hooks
+	hooks       hooks
+	connections atomic.Int64
+
+	// Health
+	stateMutex     sync.Mutex
+	consumerStates map[interface{}]*consumerState
+	isConnected    atomic.Bool
+	isDeclared     atomic.Bool
+	isClosed       atomic.Bool
+
+	// Connections of the consumers, closed with the application
+	connectionsMutex sync.Mutex
+	openConnections  map[Connection]struct{}
+	done             chan struct{}
+
+	// hostname is rendered in consumer tags
+	hostname string
 }
 
 // New creates a new App instance
 func New(config Config) *App {
 	// Create a new App instance
-	app := &App{config: config}
+	app := &App{config: config, done: make(chan struct{})}
 
 	// Set the configuration to the given one
 	if config.RabbitMQ == "" {
 		app.config.RabbitMQ = DefaultConfig.RabbitMQ
 	}
+	if config.ResubscribeBackoff <= 0 {
+		app.config.ResubscribeBackoff = DefaultConfig.ResubscribeBackoff
+	}
+	if config.ResubscribeMaxBackoff < app.config.ResubscribeBackoff {
+		app.config.ResubscribeMaxBackoff = max(DefaultConfig.ResubscribeMaxBackoff, app.config.ResubscribeBackoff)
+	}
+	if config.ServiceName == "" {
+		app.config.ServiceName = filepath.Base(os.Args[0])
+	}
 	if config.Marshal == nil {
 		app.config.Marshal = DefaultConfig.Marshal
 	}
 	if config.Unmarshal == nil {
 		app.config.Unmarshal = DefaultConfig.Unmarshal
 	}
+	if config.StreamWindow == 0 {
+		app.config.StreamWindow = DefaultConfig.StreamWindow
+	}
+	if config.PoolSize == 0 {
+		app.config.PoolSize = DefaultConfig.PoolSize
+	}
+	if config.Metrics == nil {
+		app.config.Metrics = noopMetrics{}
+	}
+	if config.Transport == nil {
+		app.config.Transport = amqpTransport{}
+	}
+	if config.Logger == nil {
+		app.config.Logger = defaultLogger()
+	}
+	if config.DisableLogging {
+		app.config.Logger = slog.New(discardHandler{})
+	}
+
+	app.hostname, _ = os.Hostname()
+	app.pool = newChannelPool(app, app.config.PoolSize)
+	app.scheduler = newScheduler(app)
 
 	return app
 }
 
 func (a *App) initExchanges() error {
-	if !a.config.DisableLogging {
-		color.Cyan("\nRegistering exchanges...\n")
-	}
-
 	for _, exchange := range a.exchanges {
 		err := a.declareExchange(exchange)
 		if err != nil {
 			return errors.New(fmt.Sprintf("volta: Problem with declaring exchange %s: %s", exchange.Name, err.Error()))
 		}
 
-		if !a.config.DisableLogging {
-			color.HiWhite("Exchange \"%s\" registered", exchange.Name)
-		}
+		a.config.Logger.Info("Exchange registered", "exchange", exchange.Name, "type", exchange.Type)
 	}
 
 	return nil
 }
 
 func (a *App) initQueues() error {
-	if !a.config.DisableLogging {
-		color.Cyan("\nRegistering queues...\n")
-	}
 	for _, queue := range a.queues {
 		if queue.Exchange != "" {
 			err := a.declareQueue(queue)
@@ -83,13 +140,9 @@ func (a *App) initQueues() error {
 				return errors.New(fmt.Sprintf("volta: Problem with declaring queue %s: %s", queue.Name, err.Error()))
 			}
 
-			if !a.config.DisableLogging {
-				color.HiWhite("Queue \"%s\" registered", queue.Name)
-			}
+			a.config.Logger.Info("Queue registered", "queue", queue.Name, "exchange", queue.Exchange)
 		} else {
-			if !a.config.DisableLogging {
-				color.HiRed("Queue \"%s\" skipped (no exchange)", queue.Name)
-			}
+			a.config.Logger.Warn("Queue skipped, no exchange", "queue", queue.Name)
 		}
 	}
 
@@ -97,49 +150,94 @@ func (a *App) initQueues() error {
 }
 
 func (a *App) initConsumers() error {
-	if !a.config.DisableLogging {
-		color.Cyan("\nRegistering consumers...\n")
+	// Middlewares are resolved once, Use fails from now on
+	a.mutex.Lock()
+	a.consumersStarted = true
+	handles := make([]*ConsumerHandle, 0, len(a.consumers))
+	for _, handle := range a.consumers {
+		if handle.chain == nil {
+			handle.chain = a.chain(handle.consumer)
+		}
+		handles = append(handles, handle)
 	}
-	for rk, handlers := range a.handlers {
-		if err := a.consume(rk, handlers...); err != nil {
-			return errors.New(fmt.Sprintf("volta: Problem with consuming queue %s: %s", rk, err.Error()))
-		} else {
-			if !a.config.DisableLogging {
-				color.HiWhite("Consumer \"%s\" registered", rk)
-			}
+	for _, handle := range a.batchConsumers {
+		handles = append(handles, handle)
+	}
+	a.mutex.Unlock()
+
+	for _, handle := range handles {
+		if err := handle.subscribe(); err != nil {
+			return errors.New(fmt.Sprintf("volta: Problem with consuming queue %s: %s", handle.queue, err.Error()))
 		}
 	}
 
 	return nil
 }
 
-func (a *App) connect() (err error) {
-	if !a.config.DisableLogging {
-		color.Cyan("Connecting to RabbitMQ...\n")
-	}
-	a.baseConnection, err = amqp091.Dial(a.config.RabbitMQ)
+func (a *App) connect() error {
+	a.config.Logger.Info("Connecting to RabbitMQ", "attempt", a.connectRetries+1)
+
+	connection, err := a.dial()
 	if err != nil {
-		if !a.config.DisableLogging {
-			color.HiRed("volta: Problem with connecting to RabbitMQ: %s", err.Error())
-		}
+		a.config.Logger.Error("Problem with connecting to RabbitMQ", "attempt", a.connectRetries+1, "error", err)
 		a.connectRetries++
 		if a.connectRetries > a.config.ConnectRetries {
 			return errors.New("volta: Problem with connecting to RabbitMQ")
 		}
 
+		a.config.Metrics.ReconnectAttempt()
 		time.Sleep(time.Duration(a.config.ConnectRetryInterval) * time.Second)
 
-		connError := a.connect()
-		if connError != nil {
-			return connError
-		}
+		return a.connect()
 	}
 
+	a.connectionsMutex.Lock()
+	a.baseConnection = connection
+	a.connectionsMutex.Unlock()
+
+	a.config.Logger.Info("Connected to RabbitMQ")
+	a.config.Metrics.ConnectionStateChanged(true)
+
+	a.isConnected.Store(true)
+	a.connected(int(a.connections.Add(1)))
+
 	return nil
 }
 
-// Listen starts the application, registers the error handler and connects to RabbitMQ
+// timeout returns Config.Timeout as a duration, DefaultConfig.Timeout when it is not positive
+func (a *App) timeout() time.Duration {
+	if a.config.Timeout <= 0 {
+		return time.Duration(DefaultConfig.Timeout) * time.Second
+	}
+
+	return time.Duration(a.config.Timeout) * time.Second
+}
+
+// Listen starts the application, registers the error handler and connects to RabbitMQ.
+// It blocks until the application is closed.
 func (a *App) Listen() error {
+	if err := a.start(); err != nil {
+		return err
+	}
+
+	// Start the outbox relay
+	if a.outbox != nil {
+		a.outbox.start()
+	}
+
+	// Start the scheduled publishing
+	a.scheduler.start()
+
+	// Check for connection active
+	go a.watch()
+
+	<-a.done
+
+	return nil
+}
+
+// start connects to RabbitMQ, declares the topology and starts the consumers
+func (a *App) start() error {
 	// Connect to RabbitMQ
 	if err := a.connect(); err != nil {
 		return err
@@ -155,34 +253,88 @@ func (a *App) Listen() error {
 		return err
 	}
 
+	a.isDeclared.Store(true)
+	a.topologyDeclared()
+
 	// Register consumers
-	if err := a.initConsumers(); err != nil {
-		return err
-	}
+	return a.initConsumers()
+}
 
-	// Check for connection active
-	go func() {
-		if !a.config.DisableLogging {
-			color.HiWhite("\nConnection watcher registered")
+// watch restarts the application whenever the connection to RabbitMQ is lost, until it is closed
+func (a *App) watch() {
+	a.config.Logger.Debug("Connection watcher registered")
+
+	for {
+		closed := a.connection().NotifyClose(make(chan *amqp091.Error, 1))
+
+		select {
+		case <-a.done:
+			return
+		case <-closed:
+		}
+
+		if a.isClosed.Load() {
+			return
 		}
+
+		a.config.Logger.Warn("Connection to RabbitMQ lost, reconnecting")
+		a.config.Metrics.ConnectionStateChanged(false)
+		a.isConnected.Store(false)
+		a.isDeclared.Store(false)
+		a.disconnected()
+		a.closeConnections()
+
 		for {
-			if a.baseConnection.IsClosed() {
-				if !a.config.DisableLogging {
-					color.HiRed("Connection to RabbitMQ lost, reconnecting...")
-				}
+			err := a.start()
+			if err == nil {
+				break
+			}
+
+			a.config.Logger.Error("Problem with reconnecting to RabbitMQ", "error", err)
 
-				a.Listen()
+			select {
+			case <-a.done:
+				return
+			case <-time.After(time.Duration(max(a.config.ConnectRetryInterval, 1)) * time.Second):
 			}
+		}
+	}
+}
+
+// track remembers a connection opened by a consumer, so it is closed with the application.
+// The connection is forgotten once it closes.
+func (a *App) track(connection Connection) {
+	closed := connection.NotifyClose(make(chan *amqp091.Error, 1))
 
-			time.Sleep(5 * time.Second)
+	a.connectionsMutex.Lock()
+	if a.openConnections == nil {
+		a.openConnections = make(map[Connection]struct{})
+	}
+	a.openConnections[connection] = struct{}{}
+	a.connectionsMutex.Unlock()
+
+	go func() {
+		for range closed {
 		}
+
+		a.connectionsMutex.Lock()
+		delete(a.openConnections, connection)
+		a.connectionsMutex.Unlock()
 	}()
+}
 
-	// Infinite loop
-	forever := make(chan bool)
-	<-forever
+// closeConnections closes the connections opened by the consumers
+func (a *App) closeConnections() {
+	a.connectionsMutex.Lock()
+	connections := a.openConnections
+	a.openConnections = nil
+	a.connectionsMutex.Unlock()
 
-	return nil
+	for connection := range connections {
+		if !connection.IsClosed() {
+			connection.Close()
+		}
+	}
 }
 
 // MustListen starts the application, registers the error handler and connects to RabbitMQ
@@ -195,12 +347,37 @@ func (a *App) MustListen() {
 
 // Close closes the connection to RabbitMQ
 func (a *App) Close() error {
-	err := a.baseConnection.Close()
-	if err != nil {
+	if !a.isClosed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(a.done)
+	a.shutdown()
+
+	if a.outbox != nil {
+		a.outbox.close()
+	}
+	a.scheduler.close()
+
+	a.closeConnections()
+
+	if err := a.pool.close(); err != nil {
 		return err
 	}
 
-	return nil
+	connection := a.connection()
+	if connection == nil || connection.IsClosed() {
+		return nil
+	}
+
+	return connection.Close()
+}
+
+// connection returns the connection used to declare the topology
+func (a *App) connection() Connection {
+	a.connectionsMutex.Lock()
+	defer a.connectionsMutex.Unlock()
+
+	return a.baseConnection
 }
 
 // MustClose closes the connection to RabbitMQ and panics if an error occurs
@@ -210,9 +387,16 @@ func (a *App) MustClose() {
 	}
 }
 
-func (a *App) Use(middlewares ...Handler) {
+// Use adds global middlewares, applied to every consumer before the group and consumer middlewares.
+// It returns ErrConsumersStarted once the consumers are started.
+func (a *App) Use(middlewares ...Handler) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
+	if a.consumersStarted {
+		return ErrConsumersStarted
+	}
+
 	a.middlewares = append(a.middlewares, middlewares...)
+	return nil
 }
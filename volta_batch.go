@@ -0,0 +1,249 @@
+package volta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// BatchHandler handles a batch of messages at once.
+// Returning nil acknowledges the whole batch, returning a BatchError negatively acknowledges
+// only the listed messages, any other error negatively acknowledges the whole batch.
+// Messages the handler settles itself are left as they are.
+type BatchHandler func(batch *BatchCtx) error
+
+type BatchOptions struct {
+	// Maximum number of messages in a batch, also used as the channel prefetch count
+	MaxSize int
+
+	// Maximum time to wait for a batch to fill up after its first message arrived
+	MaxWait time.Duration
+
+	// Requeue failed messages instead of discarding / dead-lettering them
+	Requeue bool
+}
+
+var DefaultBatchOptions = BatchOptions{
+	MaxSize: 100,
+	MaxWait: time.Second,
+	Requeue: false,
+}
+
+// BatchCtx is the context of a batch of messages, Items are in delivery order
+type BatchCtx struct {
+	App     *App
+	Channel Channel
+	Items   []*Ctx
+}
+
+// Len returns the number of messages in the batch
+func (b *BatchCtx) Len() int {
+	return len(b.Items)
+}
+
+// BatchError is returned by a BatchHandler to report the messages that failed,
+// keyed by their index in BatchCtx.Items
+type BatchError map[int]error
+
+func (e BatchError) Error() string {
+	indexes := make([]int, 0, len(e))
+	for i := range e {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	messages := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		messages = append(messages, fmt.Sprintf("#%d: %v", i, e[i]))
+	}
+
+	return "volta: Batch items failed: " + strings.Join(messages, "; ")
+}
+
+type batchConsumer struct {
+	handler BatchHandler
+	options BatchOptions
+}
+
+// AddBatchConsumer adds a consumer that receives the messages of the queue in batches.
+// A batch is handed over when it reaches MaxSize messages or MaxWait after its first message.
+// Batches are handled one at a time and global middlewares are not applied.
+// Like any consumer it subscribes again after a cancellation, see Config.ResubscribeBackoff.
+func (a *App) AddBatchConsumer(queue string, handler BatchHandler, options ...BatchOptions) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.batchConsumers == nil {
+		a.batchConsumers = make(map[string]*ConsumerHandle)
+	}
+
+	a.batchConsumers[queue] = &ConsumerHandle{
+		app:   a,
+		queue: queue,
+		batch: &batchConsumer{handler: handler, options: batchOptions(options...)},
+	}
+}
+
+func batchOptions(options ...BatchOptions) BatchOptions {
+	if len(options) < 1 {
+		return DefaultBatchOptions
+	}
+
+	cfg := options[0]
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultBatchOptions.MaxSize
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = DefaultBatchOptions.MaxWait
+	}
+
+	return cfg
+}
+
+// batchLoop collects the messages of one subscription into batches and hands them to the handler until it ends
+func (h *ConsumerHandle) batchLoop(messages <-chan amqp091.Delivery, channel Channel, state *consumerState, stopped chan struct{}) {
+	a, consumer := h.app, h.batch
+	defer close(stopped)
+
+	batch := &BatchCtx{App: a, Channel: channel}
+	var ends []func(error)
+	var timeout <-chan time.Time
+
+	flush := func() {
+		timeout = nil
+		if batch.Len() == 0 {
+			return
+		}
+
+		h.begin()
+		err := a.handleBatch(batch, *consumer)
+		for i, end := range ends {
+			end(batchItemError(err, i))
+		}
+		h.end()
+
+		batch = &BatchCtx{App: a, Channel: channel}
+		ends = nil
+	}
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				// Unsettled messages are redelivered by the broker
+				state.status.CompareAndSwap(ConsumerActive, ConsumerStopped)
+				return
+			}
+
+			state.delivered()
+			h.delivered.Add(1)
+			h.last.Store(time.Now().UnixNano())
+			a.config.Metrics.MessageConsumed(h.queue)
+
+			ctx := &Ctx{App: a, Delivery: message, Channel: channel, queue: h.queue}
+			tracingCtx, end := a.startConsume(context.Background(), &ctx.Delivery)
+			ctx.SetContext(tracingCtx)
+
+			batch.Items = append(batch.Items, ctx)
+			ends = append(ends, end)
+			if batch.Len() == 1 {
+				timeout = time.After(consumer.options.MaxWait)
+			}
+			if batch.Len() >= consumer.options.MaxSize {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		}
+	}
+}
+
+// handleBatch runs the handler and settles every message of the batch according to its result,
+// it returns the error of the handler
+func (a *App) handleBatch(batch *BatchCtx, consumer batchConsumer) error {
+	last := batch.Items[batch.Len()-1]
+	metrics := last.metrics()
+
+	metrics.HandlerStarted(last.queue)
+	start := time.Now()
+
+	err := a.runBatch(batch, consumer.handler)
+
+	metrics.HandlerFinished(last.queue, time.Since(start), err)
+
+	if err == nil {
+		settleBatch(batch, settledAck, false)
+		return nil
+	}
+
+	var failed BatchError
+	if !errors.As(err, &failed) {
+		settleBatch(batch, settledNack, consumer.options.Requeue)
+		return err
+	}
+
+	for i, item := range batch.Items {
+		if _, ok := failed[i]; ok {
+			item.Nack(false, consumer.options.Requeue)
+		} else {
+			item.Ack(false)
+		}
+	}
+
+	return err
+}
+
+// batchItemError returns the error of the i-th message of a batch the handler returned err for
+func batchItemError(err error, i int) error {
+	var failed BatchError
+	if errors.As(err, &failed) {
+		return failed[i]
+	}
+
+	return err
+}
+
+// runBatch calls the handler, a panic is logged and returned as an error so the batch is negatively acknowledged
+func (a *App) runBatch(batch *BatchCtx, handler BatchHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.config.Logger.Error("Batch handler panicked", "queue", batch.Items[0].queue, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("volta: Batch handler panicked: %v", r)
+		}
+	}()
+
+	return handler(batch)
+}
+
+// settleBatch settles the items the handler left unsettled with a single multiple ack or nack
+// through the last of them, the items it covers are marked settled along with it
+func settleBatch(batch *BatchCtx, how int32, requeue bool) {
+	last := -1
+	for i, item := range batch.Items {
+		if !item.Settled() {
+			last = i
+		}
+	}
+	if last < 0 {
+		return
+	}
+
+	switch how {
+	case settledAck:
+		batch.Items[last].Ack(true)
+	default:
+		batch.Items[last].Nack(true, requeue)
+	}
+
+	for _, item := range batch.Items[:last] {
+		if item.settle(how) {
+			item.metrics().MessageSettled(item.queue, item.Settlement())
+		}
+	}
+}
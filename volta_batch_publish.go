@@ -0,0 +1,287 @@
+package volta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// PublishStatus is the outcome of publishing a single message with confirms
+type PublishStatus int
+
+const (
+	// PublishFailed means the message could not be published or its confirmation never arrived
+	PublishFailed PublishStatus = iota
+
+	// PublishConfirmed means the broker took responsibility for the message
+	PublishConfirmed
+
+	// PublishNacked means the broker refused the message
+	PublishNacked
+
+	// PublishReturned means the message was mandatory and could not be routed to any queue
+	PublishReturned
+)
+
+func (s PublishStatus) String() string {
+	switch s {
+	case PublishConfirmed:
+		return "confirmed"
+	case PublishNacked:
+		return "nacked"
+	case PublishReturned:
+		return "returned"
+	default:
+		return "failed"
+	}
+}
+
+// PublishResult is the outcome of one message of a batch
+type PublishResult struct {
+	Status PublishStatus
+
+	// Err is set when Status is PublishFailed
+	Err error
+
+	// Return holds the returned message when Status is PublishReturned
+	Return *amqp091.Return
+}
+
+// Ok reports whether the message was confirmed by the broker
+func (r PublishResult) Ok() bool {
+	return r.Status == PublishConfirmed
+}
+
+// err returns the reason the message was not confirmed, nil if it was
+func (r PublishResult) err() error {
+	if r.Ok() {
+		return nil
+	}
+	if r.Err != nil {
+		return r.Err
+	}
+
+	return errors.New("volta: Message " + r.Status.String())
+}
+
+// BatchMessage is a message published as part of a Batch
+type BatchMessage struct {
+	Exchange   string
+	RoutingKey string
+
+	// Mandatory messages that cannot be routed are returned instead of dropped
+	Mandatory bool
+
+	// Publishing holds the body and the properties of the message
+	Publishing amqp091.Publishing
+}
+
+// Batch publishes many messages over one pooled channel in confirm mode
+type Batch struct {
+	app      *App
+	messages []BatchMessage
+}
+
+// NewBatch creates an empty batch of messages
+func (a *App) NewBatch() *Batch {
+	return &Batch{app: a}
+}
+
+// Add adds a message with the given routing key, exchange and body to the batch
+func (b *Batch) Add(name, exchange string, body []byte) *Batch {
+	return b.AddMessage(BatchMessage{
+		Exchange:   exchange,
+		RoutingKey: name,
+		Publishing: amqp091.Publishing{ContentType: "text/plain", Body: body},
+	})
+}
+
+// AddJSON marshals body to JSON and adds it to the batch
+func (b *Batch) AddJSON(name, exchange string, body interface{}) error {
+	data, err := b.app.config.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	b.AddMessage(BatchMessage{
+		Exchange:   exchange,
+		RoutingKey: name,
+		Publishing: amqp091.Publishing{ContentType: "application/json", Body: data},
+	})
+
+	return nil
+}
+
+// AddMessage adds a message with its own options to the batch
+func (b *Batch) AddMessage(message BatchMessage) *Batch {
+	b.messages = append(b.messages, message)
+	return b
+}
+
+// Len returns the number of messages in the batch
+func (b *Batch) Len() int {
+	return len(b.messages)
+}
+
+// Publish publishes all messages of the batch without waiting for each confirmation,
+// then waits for the outstanding ones. The result slice is indexed like the messages were added.
+// The error is only set when the batch could not be started at all.
+func (b *Batch) Publish(ctx context.Context) ([]PublishResult, error) {
+	results := make([]PublishResult, len(b.messages))
+	if len(b.messages) == 0 {
+		return results, nil
+	}
+
+	start := time.Now()
+
+	// Every message gets its own span, the tracer works on a copy of the message
+	messages := make([]BatchMessage, len(b.messages))
+	ends := make([]func(error), len(b.messages))
+	for i, message := range b.messages {
+		_, ends[i] = b.app.startPublish(ctx, message.Exchange, message.RoutingKey, &message.Publishing)
+		messages[i] = message
+	}
+
+	channel, err := b.app.pool.get(ctx)
+	if err != nil {
+		for _, end := range ends {
+			end(err)
+		}
+		return nil, err
+	}
+
+	if publishBatch(ctx, channel, messages, results) {
+		b.app.pool.put(channel)
+	} else {
+		b.app.pool.discard(channel)
+	}
+
+	duration := time.Since(start)
+	for i, message := range b.messages {
+		b.app.config.Metrics.MessagePublished(message.Exchange, duration, results[i].err())
+		ends[i](results[i].err())
+
+		if results[i].Status == PublishReturned {
+			b.app.publishReturned(*results[i].Return)
+		}
+	}
+
+	return results, nil
+}
+
+// publishBatch pipelines the messages over the channel and fills in their results.
+// It reports whether the channel is still usable, i.e. no confirmation is left outstanding.
+func publishBatch(ctx context.Context, channel *pooledChannel, messages []BatchMessage, results []PublishResult) bool {
+	pending := make(map[uint64]int)
+
+	// A return carries no delivery tag. The broker sends it before the confirmation of the same message
+	// and in publish order, so the oldest return is matched against each confirmed mandatory message.
+	var returns []amqp091.Return
+	drain := func() {
+		for {
+			select {
+			case r, ok := <-channel.returns:
+				if !ok {
+					return
+				}
+				returns = append(returns, r)
+			default:
+				return
+			}
+		}
+	}
+	matchReturn := func(i int) *amqp091.Return {
+		drain()
+		if len(returns) == 0 || !messages[i].Mandatory || !returnOf(returns[0], messages[i]) {
+			return nil
+		}
+
+		r := returns[0]
+		returns = returns[1:]
+		return &r
+	}
+
+	// collect waits for one confirmation, it returns false when the channel became unusable
+	collect := func() bool {
+		select {
+		case confirmation, ok := <-channel.confirms:
+			if !ok {
+				return false
+			}
+
+			i, known := pending[confirmation.DeliveryTag]
+			if !known {
+				return true
+			}
+			delete(pending, confirmation.DeliveryTag)
+
+			if r := matchReturn(i); r != nil {
+				results[i] = PublishResult{Status: PublishReturned, Return: r}
+			} else if confirmation.Ack {
+				results[i] = PublishResult{Status: PublishConfirmed}
+			} else {
+				results[i] = PublishResult{Status: PublishNacked}
+			}
+		case r, ok := <-channel.returns:
+			if !ok {
+				return false
+			}
+			returns = append(returns, r)
+		case <-ctx.Done():
+			return false
+		}
+
+		return true
+	}
+
+	usable := true
+	for i, message := range messages {
+		for usable && len(pending) >= cap(channel.confirms) {
+			usable = collect()
+		}
+		if !usable {
+			break
+		}
+
+		seq := channel.channel.GetNextPublishSeqNo()
+		err := channel.channel.PublishWithContext(ctx, message.Exchange, message.RoutingKey, message.Mandatory, false, message.Publishing)
+		if err != nil {
+			results[i] = PublishResult{Status: PublishFailed, Err: err}
+			usable = false
+			break
+		}
+
+		pending[seq] = i
+	}
+
+	for usable && len(pending) > 0 {
+		usable = collect()
+	}
+
+	// Returns of messages left unconfirmed must not be matched by the next batch on the channel
+	drain()
+
+	cause := ctx.Err()
+	if cause == nil {
+		cause = amqp091.ErrClosed
+	}
+	for i := range results {
+		if results[i].Status == PublishFailed && results[i].Err == nil {
+			results[i].Err = cause
+		}
+	}
+
+	return usable && len(pending) == 0
+}
+
+// returnOf reports whether r is the returned copy of message
+func returnOf(r amqp091.Return, message BatchMessage) bool {
+	return r.Exchange == message.Exchange &&
+		r.RoutingKey == message.RoutingKey &&
+		r.MessageId == message.Publishing.MessageId &&
+		r.CorrelationId == message.Publishing.CorrelationId &&
+		bytes.Equal(r.Body, message.Publishing.Body)
+}
@@ -0,0 +1,122 @@
+package volta
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestBatch_Publish(t *testing.T) {
+	app := New(Config{
+		Transport:      NewMemoryTransport(),
+		DisableLogging: true,
+	})
+
+	if err := app.connect(); err != nil {
+		t.Fatalf("App.connect() error = %v", err)
+	}
+
+	if err := app.declareExchange(Exchange{Name: "test", Type: "topic"}); err != nil {
+		t.Errorf("App.declareExchange() error = %v", err)
+	}
+
+	if err := app.declareQueue(Queue{Name: "test.batch", RoutingKey: "test.batch", Exchange: "test"}); err != nil {
+		t.Errorf("App.declareQueue() error = %v", err)
+	}
+
+	batch := app.NewBatch().
+		Add("test.batch", "test", []byte("1")).
+		Add("test.batch", "test", []byte("2")).
+		AddMessage(BatchMessage{
+			Exchange:   "test",
+			RoutingKey: "test.unroutable",
+			Mandatory:  true,
+			Publishing: amqp091.Publishing{Body: []byte("3")},
+		})
+
+	results, err := batch.Publish(context.Background())
+	if err != nil {
+		t.Fatalf("Batch.Publish() error = %v", err)
+	}
+
+	expected := []PublishStatus{PublishConfirmed, PublishConfirmed, PublishReturned}
+	for i, result := range results {
+		if result.Status != expected[i] {
+			t.Errorf("Result #%d is %s, expected %s", i, result.Status, expected[i])
+		}
+	}
+
+	if err := app.Close(); err != nil {
+		t.Errorf("App.Close() error = %v", err)
+	}
+}
+
+func TestBatch_Publish_duplicateMessageId(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := New(Config{Transport: transport, DisableLogging: true})
+	if err := app.connect(); err != nil {
+		t.Fatalf("App.connect() error = %v", err)
+	}
+	defer app.Close()
+
+	app.declareExchange(Exchange{Name: "test", Type: "topic"})
+	app.declareQueue(Queue{Name: "test.batch", RoutingKey: "test.batch", Exchange: "test"})
+
+	publishing := amqp091.Publishing{MessageId: "same", Headers: amqp091.Table{"origin": "test"}}
+	results, err := app.NewBatch().
+		AddMessage(BatchMessage{Exchange: "test", RoutingKey: "test.unroutable", Mandatory: true, Publishing: publishing}).
+		AddMessage(BatchMessage{Exchange: "test", RoutingKey: "test.batch", Mandatory: true, Publishing: publishing}).
+		Publish(context.Background())
+	if err != nil {
+		t.Fatalf("Batch.Publish() error = %v", err)
+	}
+
+	if results[0].Status != PublishReturned || results[1].Status != PublishConfirmed {
+		t.Fatalf("Results are %s and %s, expected returned and confirmed", results[0].Status, results[1].Status)
+	}
+	if headers := results[0].Return.Headers; len(headers) != 1 || headers["origin"] != "test" {
+		t.Errorf("Returned headers are %v, expected only the headers of the message", headers)
+	}
+	if len(publishing.Headers) != 1 {
+		t.Error("Batch.Publish() modified the headers of the message")
+	}
+
+	// TEST: the routed message reaches the consumer with its own headers only
+	messages, err := openChannel(t, transport).Consume("test.batch", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if headers := receive(t, messages).Headers; len(headers) != 1 || headers["origin"] != "test" {
+		t.Errorf("Consumed headers are %v, expected only the headers of the message", headers)
+	}
+}
+
+func TestChannelPool_get_cancelled(t *testing.T) {
+	app := New(Config{Transport: NewMemoryTransport(), DisableLogging: true})
+	if err := app.connect(); err != nil {
+		t.Fatalf("App.connect() error = %v", err)
+	}
+	defer app.Close()
+
+	channel, err := app.pool.get(context.Background())
+	if err != nil {
+		t.Fatalf("channelPool.get() error = %v", err)
+	}
+	app.pool.put(channel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := app.pool.get(ctx); err != context.Canceled {
+		t.Errorf("channelPool.get() error = %v with an idle channel, expected %v", err, context.Canceled)
+	}
+}
+
+func TestBatch_PublishEmpty(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	results, err := app.NewBatch().Publish(context.Background())
+	if err != nil || len(results) != 0 {
+		t.Errorf("Batch.Publish() = %v, %v, expected no results", results, err)
+	}
+}
@@ -0,0 +1,200 @@
+package volta
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type recordingAcknowledger struct {
+	acks  map[uint64]bool
+	nacks map[uint64]bool
+}
+
+func (r *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	r.acks[tag] = multiple
+	return nil
+}
+
+func (r *recordingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	r.nacks[tag] = multiple
+	return nil
+}
+
+func (r *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	r.nacks[tag] = false
+	return nil
+}
+
+func newTestBatch(app *App, size int) (*BatchCtx, *recordingAcknowledger) {
+	acknowledger := &recordingAcknowledger{acks: map[uint64]bool{}, nacks: map[uint64]bool{}}
+	batch := &BatchCtx{App: app}
+	for i := 1; i <= size; i++ {
+		batch.Items = append(batch.Items, &Ctx{App: app, Delivery: amqp091.Delivery{
+			Acknowledger: acknowledger,
+			DeliveryTag:  uint64(i),
+		}})
+	}
+
+	return batch, acknowledger
+}
+
+func TestApp_AddBatchConsumer(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	app.AddBatchConsumer("test", func(batch *BatchCtx) error { return nil }, BatchOptions{MaxSize: 10})
+
+	consumer, ok := app.batchConsumers["test"]
+	if !ok {
+		t.Fatal("No batch consumer added")
+	}
+
+	if consumer.batch.options.MaxSize != 10 {
+		t.Errorf("MaxSize is %d, expected 10", consumer.batch.options.MaxSize)
+	}
+
+	if consumer.batch.options.MaxWait != DefaultBatchOptions.MaxWait {
+		t.Errorf("MaxWait is %s, expected %s", consumer.batch.options.MaxWait, DefaultBatchOptions.MaxWait)
+	}
+}
+
+func TestApp_AddBatchConsumer_resubscribe(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := New(Config{Transport: transport, DisableLogging: true, ResubscribeBackoff: 10 * time.Millisecond})
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "orders", Exchange: "test", RoutingKey: "orders"})
+
+	received := make(chan string, 1)
+	app.AddBatchConsumer("orders", func(batch *BatchCtx) error {
+		received <- string(batch.Items[0].Body())
+		return nil
+	}, BatchOptions{MaxSize: 1})
+
+	cancelled := make(chan struct{}, 1)
+	resubscribed := make(chan struct{}, 1)
+	app.OnConsumerCancelled(func(queue, consumerTag string) { cancelled <- struct{}{} })
+	app.OnConsumerResubscribed(func(queue, consumerTag string, attempt int) { resubscribed <- struct{}{} })
+
+	go app.Listen()
+	t.Cleanup(func() { app.Close() })
+	waitHealthy(t, app)
+
+	// TEST: a batch consumer whose queue was deleted declares it again and subscribes again
+	if _, err := openChannel(t, transport).QueueDelete("orders", false, false, false); err != nil {
+		t.Fatalf("QueueDelete() error = %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("OnConsumerCancelled was not called")
+	}
+
+	select {
+	case <-resubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("OnConsumerResubscribed was not called")
+	}
+	waitHealthy(t, app)
+
+	if err := app.Publish("orders", "test", []byte("after delete")); err != nil {
+		t.Fatalf("App.Publish() error = %v", err)
+	}
+
+	select {
+	case body := <-received:
+		if body != "after delete" {
+			t.Errorf("Body is %s, expected after delete", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Batch consumer did not receive messages after resubscribing")
+	}
+}
+
+func TestApp_handleBatch(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	// TEST: a successful batch is acknowledged at once
+	batch, acknowledger := newTestBatch(app, 3)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error { return nil }})
+
+	if multiple, ok := acknowledger.acks[3]; !ok || !multiple || len(acknowledger.acks) != 1 {
+		t.Errorf("Acks are %v, expected a single multiple ack of tag 3", acknowledger.acks)
+	}
+
+	// TEST: only failed items are negatively acknowledged
+	batch, acknowledger = newTestBatch(app, 3)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error {
+		return BatchError{1: errors.New("failed")}
+	}})
+
+	if len(acknowledger.acks) != 2 || len(acknowledger.nacks) != 1 {
+		t.Errorf("Acks are %v and nacks are %v, expected 2 acks and 1 nack", acknowledger.acks, acknowledger.nacks)
+	}
+
+	if _, ok := acknowledger.nacks[2]; !ok {
+		t.Errorf("Nacks are %v, expected tag 2", acknowledger.nacks)
+	}
+
+	// TEST: a wrapped BatchError is recognized
+	batch, acknowledger = newTestBatch(app, 3)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error {
+		return fmt.Errorf("saving: %w", BatchError{0: errors.New("failed")})
+	}})
+
+	if _, ok := acknowledger.nacks[1]; !ok || len(acknowledger.acks) != 2 || len(acknowledger.nacks) != 1 {
+		t.Errorf("Acks are %v and nacks are %v, expected tag 1 alone to be nacked", acknowledger.acks, acknowledger.nacks)
+	}
+
+	// TEST: a panic negatively acknowledges the whole batch
+	batch, acknowledger = newTestBatch(app, 3)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error { panic("boom") }})
+
+	if multiple, ok := acknowledger.nacks[3]; !ok || !multiple || len(acknowledger.acks) != 0 {
+		t.Errorf("Nacks are %v, expected a single multiple nack of tag 3", acknowledger.nacks)
+	}
+
+	// TEST: any other error negatively acknowledges the whole batch
+	batch, acknowledger = newTestBatch(app, 3)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error { return errors.New("failed") }})
+
+	if multiple, ok := acknowledger.nacks[3]; !ok || !multiple || len(acknowledger.acks) != 0 {
+		t.Errorf("Nacks are %v, expected a single multiple nack of tag 3", acknowledger.nacks)
+	}
+}
+
+func TestApp_handleBatch_settledByHandler(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	// TEST: the multiple ack goes through the last item the handler left unsettled
+	batch, acknowledger := newTestBatch(app, 3)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error {
+		return batch.Items[2].Nack(false, true)
+	}})
+
+	if multiple, ok := acknowledger.acks[2]; !ok || !multiple || len(acknowledger.acks) != 1 {
+		t.Errorf("Acks are %v, expected a single multiple ack of tag 2", acknowledger.acks)
+	}
+
+	for i, item := range batch.Items[:2] {
+		if item.Settlement() != SettlementAck {
+			t.Errorf("Item #%d is %q, expected ack", i, item.Settlement())
+		}
+	}
+
+	// TEST: nothing is sent when the handler settled every item
+	batch, acknowledger = newTestBatch(app, 2)
+	app.handleBatch(batch, batchConsumer{handler: func(batch *BatchCtx) error {
+		for _, item := range batch.Items {
+			item.Ack(false)
+		}
+		return errors.New("failed")
+	}})
+
+	if len(acknowledger.acks) != 2 || len(acknowledger.nacks) != 0 {
+		t.Errorf("Acks are %v and nacks are %v, expected the 2 acks of the handler", acknowledger.acks, acknowledger.nacks)
+	}
+}
@@ -0,0 +1,647 @@
+package volta
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultConsumerTag is the consumer tag template used when ConsumerOptions.Tag is empty
+const DefaultConsumerTag = "{service}.{hostname}.{queue}.{instance}"
+
+// ConsumerOptions configures a single consumer
+type ConsumerOptions struct {
+	// Middlewares run before the handlers of the consumer, after the global and group middlewares
+	Middlewares []Handler
+
+	// Tag is the consumer tag template, {service}, {hostname}, {queue} and {instance} are replaced by
+	// Config.ServiceName, the hostname, the queue name and Config.Instance. Defaults: DefaultConsumerTag
+	Tag string
+
+	// Exclusive asks the broker for the only consumer of the queue
+	Exclusive bool
+
+	// Priority of the consumer, sent as x-priority, the broker delivers to lower priorities only
+	// when higher ones are blocked. 0 leaves it unset
+	Priority int
+
+	// Args are additional consumer arguments
+	Args amqp091.Table
+}
+
+// consumerTag renders the tag template for the queue
+func (a *App) consumerTag(template, queue string) string {
+	if template == "" {
+		template = DefaultConsumerTag
+	}
+
+	return strings.NewReplacer(
+		"{service}", a.config.ServiceName,
+		"{hostname}", a.hostname,
+		"{queue}", queue,
+		"{instance}", strconv.Itoa(a.config.Instance),
+	).Replace(template)
+}
+
+// arguments returns the consumer arguments of the options
+func (o ConsumerOptions) arguments() amqp091.Table {
+	if len(o.Args) == 0 && o.Priority == 0 {
+		return nil
+	}
+
+	args := make(amqp091.Table, len(o.Args)+1)
+	for key, value := range o.Args {
+		args[key] = value
+	}
+	if o.Priority != 0 {
+		args["x-priority"] = int32(o.Priority)
+	}
+
+	return args
+}
+
+type consumer struct {
+	group    *Group
+	options  ConsumerOptions
+	handlers []Handler
+}
+
+// ConsumerStats are the counters of a consumer since it was added
+type ConsumerStats struct {
+	Delivered uint64
+	Acked     uint64
+	Nacked    uint64
+	Rejected  uint64
+
+	// Failed counts the messages whose handlers returned an error
+	Failed uint64
+
+	// InFlight is the number of messages being handled
+	InFlight int64
+
+	// LastDelivery is zero until the first message arrived
+	LastDelivery time.Time
+}
+
+// ConsumerHandle controls a consumer, it is returned by StartConsumer and Consumer.
+// Every consumer has its own connection and channel, pausing cancels the subscription with basic.cancel
+// while the channel stays open, so the messages being handled can still be settled.
+// A consumer cancelled by the broker or whose channel closed subscribes again with backoff,
+// see Config.ResubscribeBackoff.
+type ConsumerHandle struct {
+	app      *App
+	queue    string
+	consumer consumer
+
+	// batch is set for a consumer added with AddBatchConsumer, its messages are handled in batches
+	batch *batchConsumer
+
+	mutex      sync.Mutex
+	chain      []Handler
+	connection Connection
+	channel    Channel
+	tag        string
+	state      *consumerState
+	paused     bool
+	removed    bool
+
+	// generation counts the subscriptions, events of an older one are ignored
+	generation int
+
+	// stopped is closed when the loop of the current subscription has seen its deliveries end
+	stopped chan struct{}
+
+	// handling counts the messages being handled, idle is closed whenever it drops to zero
+	handlingMutex sync.Mutex
+	handling      int64
+	idle          chan struct{}
+
+	delivered atomic.Uint64
+	acked     atomic.Uint64
+	nacked    atomic.Uint64
+	rejected  atomic.Uint64
+	failed    atomic.Uint64
+	last      atomic.Int64
+}
+
+// AddConsumerWithOptions is like AddConsumer, options configure the consumer
+func (a *App) AddConsumerWithOptions(routingKey string, options ConsumerOptions, handlers ...Handler) {
+	a.addConsumer(routingKey, consumer{options: options, handlers: handlers})
+}
+
+func (a *App) addConsumer(queue string, c consumer) *ConsumerHandle {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.consumers == nil {
+		a.consumers = make(map[string]*ConsumerHandle)
+	}
+
+	handle := &ConsumerHandle{app: a, queue: queue, consumer: c}
+	a.consumers[queue] = handle
+
+	return handle
+}
+
+// StartConsumer adds a consumer of the queue and starts it right away when the application is listening,
+// otherwise it is started by Listen. It returns ErrConsumerExists if the queue already has a consumer.
+func (a *App) StartConsumer(queue string, handlers ...Handler) (*ConsumerHandle, error) {
+	return a.startConsumer(queue, consumer{handlers: handlers})
+}
+
+// StartConsumerWithOptions is like StartConsumer, options configure the consumer
+func (a *App) StartConsumerWithOptions(queue string, options ConsumerOptions, handlers ...Handler) (*ConsumerHandle, error) {
+	return a.startConsumer(queue, consumer{options: options, handlers: handlers})
+}
+
+func (a *App) startConsumer(queue string, c consumer) (*ConsumerHandle, error) {
+	a.mutex.Lock()
+	if _, ok := a.consumers[queue]; ok {
+		a.mutex.Unlock()
+		return nil, ErrConsumerExists
+	}
+	if a.consumers == nil {
+		a.consumers = make(map[string]*ConsumerHandle)
+	}
+
+	handle := &ConsumerHandle{app: a, queue: queue, consumer: c}
+	a.consumers[queue] = handle
+	started := a.consumersStarted
+	if started {
+		handle.chain = a.chain(c)
+	}
+	a.mutex.Unlock()
+
+	if !started {
+		return handle, nil
+	}
+
+	if err := handle.subscribe(); err != nil {
+		a.mutex.Lock()
+		delete(a.consumers, queue)
+		a.mutex.Unlock()
+
+		return nil, err
+	}
+
+	return handle, nil
+}
+
+// Consumer returns the handle of the consumer of the queue, nil if there is none
+func (a *App) Consumer(queue string) *ConsumerHandle {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.consumers[queue]
+}
+
+// RemoveConsumer stops and removes the consumer of the queue, see ConsumerHandle.Remove
+func (a *App) RemoveConsumer(queue string) error {
+	handle := a.Consumer(queue)
+	if handle == nil {
+		return ErrConsumerNotFound
+	}
+
+	return handle.Remove()
+}
+
+// chain returns the handlers a message of the consumer runs through, see Group for the order.
+// It must be called with a.mutex held.
+func (a *App) chain(c consumer) []Handler {
+	chain := make([]Handler, 0)
+	chain = append(chain, a.middlewares...)
+	chain = append(chain, c.group.chain()...)
+	chain = append(chain, c.options.Middlewares...)
+	chain = append(chain, c.handlers...)
+
+	return chain
+}
+
+// Queue returns the name of the consumed queue
+func (h *ConsumerHandle) Queue() string {
+	return h.queue
+}
+
+// ConsumerTag returns the tag of the current subscription, empty while there is none
+func (h *ConsumerHandle) ConsumerTag() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.tag
+}
+
+// Status returns ConsumerActive, ConsumerPaused, ConsumerCancelled or ConsumerStopped
+func (h *ConsumerHandle) Status() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch {
+	case h.removed:
+		return ConsumerStopped
+	case h.paused:
+		return ConsumerPaused
+	case h.state == nil:
+		return ConsumerStopped
+	}
+
+	return h.state.status.Load().(string)
+}
+
+// Stats returns the counters of the consumer
+func (h *ConsumerHandle) Stats() ConsumerStats {
+	stats := ConsumerStats{
+		Delivered: h.delivered.Load(),
+		Acked:     h.acked.Load(),
+		Nacked:    h.nacked.Load(),
+		Rejected:  h.rejected.Load(),
+		Failed:    h.failed.Load(),
+	}
+
+	h.handlingMutex.Lock()
+	stats.InFlight = h.handling
+	h.handlingMutex.Unlock()
+
+	if last := h.last.Load(); last != 0 {
+		stats.LastDelivery = time.Unix(0, last)
+	}
+
+	return stats
+}
+
+// Pause stops the delivery of new messages, the messages being handled can still be settled
+func (h *ConsumerHandle) Pause() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.removed {
+		return ErrConsumerNotFound
+	}
+	if h.paused {
+		return nil
+	}
+
+	h.paused = true
+	if h.channel == nil || h.tag == "" {
+		return nil
+	}
+
+	h.state.status.Store(ConsumerPaused)
+	tag := h.tag
+	h.tag = ""
+
+	return h.channel.Cancel(tag, false)
+}
+
+// Resume restarts the delivery of messages to a paused consumer
+func (h *ConsumerHandle) Resume() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.removed {
+		return ErrConsumerNotFound
+	}
+	if !h.paused {
+		return nil
+	}
+
+	h.paused = false
+	if h.channel == nil || h.channel.IsClosed() {
+		// Started by Listen or on the next reconnect
+		return nil
+	}
+
+	return h.consume()
+}
+
+// Remove stops the consumer and removes it from the application.
+// It waits up to Config.Timeout seconds for the messages being handled, unsettled messages are requeued by the broker.
+func (h *ConsumerHandle) Remove() error {
+	h.mutex.Lock()
+	if h.removed {
+		h.mutex.Unlock()
+		return nil
+	}
+	h.removed = true
+
+	connection, channel, tag, stopped := h.connection, h.channel, h.tag, h.stopped
+	h.tag = ""
+	h.mutex.Unlock()
+
+	h.app.mutex.Lock()
+	if h.app.consumers[h.queue] == h {
+		delete(h.app.consumers, h.queue)
+	}
+	h.app.mutex.Unlock()
+	h.app.untrackConsumer(h)
+
+	if channel == nil {
+		return nil
+	}
+	if tag != "" && !channel.IsClosed() {
+		channel.Cancel(tag, false)
+	}
+
+	h.wait(stopped, h.app.timeout())
+
+	if connection.IsClosed() {
+		return nil
+	}
+	return connection.Close()
+}
+
+// wait waits for the loop to hand over the deliveries buffered before the cancellation, nil when there is none,
+// then for the messages being handled, at most for timeout
+func (h *ConsumerHandle) wait(stopped <-chan struct{}, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	if stopped != nil {
+		select {
+		case <-stopped:
+		case <-timer.C:
+			return
+		}
+	}
+
+	select {
+	case <-h.idled():
+	case <-timer.C:
+	}
+}
+
+// begin counts a message being handled
+func (h *ConsumerHandle) begin() {
+	h.handlingMutex.Lock()
+	defer h.handlingMutex.Unlock()
+
+	if h.handling == 0 {
+		h.idle = make(chan struct{})
+	}
+	h.handling++
+}
+
+// end counts a handled message
+func (h *ConsumerHandle) end() {
+	h.handlingMutex.Lock()
+	defer h.handlingMutex.Unlock()
+
+	h.handling--
+	if h.handling == 0 {
+		close(h.idle)
+	}
+}
+
+// idled returns a channel closed once no message is being handled
+func (h *ConsumerHandle) idled() <-chan struct{} {
+	h.handlingMutex.Lock()
+	defer h.handlingMutex.Unlock()
+
+	if h.handling == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+
+	return h.idle
+}
+
+// subscribe opens the connection and the channel of the consumer and consumes the queue unless it is paused
+func (h *ConsumerHandle) subscribe() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.subscribeLocked()
+}
+
+// subscribeLocked is subscribe, it must be called with h.mutex held
+func (h *ConsumerHandle) subscribeLocked() error {
+	if h.removed {
+		return nil
+	}
+
+	if h.connection != nil && !h.connection.IsClosed() {
+		h.connection.Close()
+	}
+
+	connection, err := h.app.dial()
+	if err != nil {
+		return err
+	}
+	h.app.track(connection)
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	if h.batch != nil {
+		if err := channel.Qos(h.batch.options.MaxSize, 0, false); err != nil {
+			connection.Close()
+			return err
+		}
+	}
+
+	h.generation++
+	h.connection, h.channel, h.tag = connection, channel, ""
+	h.watch(channel, h.generation)
+
+	if h.paused {
+		return nil
+	}
+
+	return h.consume()
+}
+
+// consume subscribes the channel to the queue, it must be called with h.mutex held
+func (h *ConsumerHandle) consume() error {
+	options := h.consumer.options
+	tag := h.app.consumerTag(options.Tag, h.queue)
+	messages, err := h.channel.Consume(h.queue, tag, false, options.Exclusive, false, false, options.arguments())
+	if err != nil {
+		return err
+	}
+
+	h.tag = tag
+	h.state = h.app.trackConsumer(h, h.queue, tag)
+
+	h.app.config.Logger.Info("Consumer registered", "queue", h.queue, "consumer_tag", tag)
+	h.app.consumerStarted(h.queue, tag)
+
+	h.stopped = make(chan struct{})
+	if h.batch != nil {
+		go h.batchLoop(messages, h.channel, h.state, h.stopped)
+	} else {
+		go h.loop(messages, h.channel, h.state, h.stopped)
+	}
+
+	return nil
+}
+
+// watch reports the subscription cancelled by the broker, e.g. because its queue was deleted,
+// or the channel closed by the broker, and subscribes again
+func (h *ConsumerHandle) watch(channel Channel, generation int) {
+	cancels := channel.NotifyCancel(make(chan string, 1))
+	closed := channel.NotifyClose(make(chan *amqp091.Error, 1))
+
+	go func() {
+		for {
+			var tag string
+			var reason *amqp091.Error
+
+			select {
+			case consumerTag, ok := <-cancels:
+				if !ok {
+					cancels = nil
+					continue
+				}
+				tag = consumerTag
+			case err, ok := <-closed:
+				if !ok || err == nil {
+					// Closed by the application
+					return
+				}
+				reason = err
+			}
+
+			h.mutex.Lock()
+			current := generation == h.generation && !h.removed && (reason != nil || tag != "" && tag == h.tag)
+			if current {
+				tag = h.tag
+				h.tag = ""
+				if h.state != nil {
+					h.state.status.Store(ConsumerCancelled)
+				}
+			}
+			h.mutex.Unlock()
+
+			if reason == nil && !current {
+				continue
+			}
+			// The connection watcher restarts the consumers when the whole connection is lost
+			if !current || h.app.isClosed.Load() || !h.app.isConnected.Load() {
+				return
+			}
+
+			if reason != nil {
+				h.app.config.Logger.Warn("Consumer channel closed", "queue", h.queue, "consumer_tag", tag, "error", reason)
+			} else {
+				h.app.config.Logger.Warn("Consumer cancelled by the broker", "queue", h.queue, "consumer_tag", tag)
+			}
+			h.app.consumerCancelled(h.queue, tag)
+
+			h.resubscribe(generation)
+			return
+		}
+	}()
+}
+
+// resubscribe subscribes again after a cancellation, re-declaring the queue when it is part of the topology.
+// It gives up when the application is closed or disconnected, the connection watcher restarts the consumers then.
+func (h *ConsumerHandle) resubscribe(generation int) {
+	a := h.app
+	backoff := a.config.ResubscribeBackoff
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-a.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		if !a.isConnected.Load() {
+			return
+		}
+
+		err := h.redeclare()
+		if err == nil {
+			h.mutex.Lock()
+			if h.removed || generation != h.generation {
+				h.mutex.Unlock()
+				return
+			}
+			err = h.subscribeLocked()
+			if err != nil {
+				// The channel of a failed subscription closes, its watcher must not start over with the first attempt
+				h.generation++
+				generation = h.generation
+			}
+			tag := h.tag
+			h.mutex.Unlock()
+
+			if err == nil {
+				a.config.Logger.Info("Consumer resubscribed", "queue", h.queue, "consumer_tag", tag, "attempt", attempt)
+				a.consumerResubscribed(h.queue, tag, attempt)
+				return
+			}
+		}
+
+		a.config.Logger.Error("Problem with resubscribing consumer", "queue", h.queue, "attempt", attempt, "error", err)
+		backoff = min(backoff*2, a.config.ResubscribeMaxBackoff)
+	}
+}
+
+// redeclare declares the queue again when it was added with AddQueue, it may have been deleted
+func (h *ConsumerHandle) redeclare() error {
+	h.app.mutex.Lock()
+	queue, ok := h.app.queues[h.queue]
+	h.app.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return h.app.declareQueue(queue)
+}
+
+// loop handles the messages of one subscription until it ends
+func (h *ConsumerHandle) loop(messages <-chan amqp091.Delivery, channel Channel, state *consumerState, stopped chan struct{}) {
+	a := h.app
+	defer close(stopped)
+
+	for message := range messages {
+		state.delivered()
+		h.delivered.Add(1)
+		h.last.Store(time.Now().UnixNano())
+		h.begin()
+
+		go func(msg amqp091.Delivery) {
+			defer h.end()
+
+			ctx := &Ctx{App: a, Delivery: msg, handlers: h.chain, Channel: channel, queue: h.queue, consumer: h}
+
+			tracingCtx, end := a.startConsume(context.Background(), &ctx.Delivery)
+			ctx.SetContext(tracingCtx)
+
+			a.config.Metrics.MessageConsumed(h.queue)
+			a.config.Metrics.HandlerStarted(h.queue)
+			start := time.Now()
+
+			err := h.chain[0](ctx)
+			if err != nil {
+				h.failed.Add(1)
+			}
+
+			a.config.Metrics.HandlerFinished(h.queue, time.Since(start), err)
+			end(err)
+		}(message)
+	}
+
+	state.status.CompareAndSwap(ConsumerActive, ConsumerStopped)
+}
+
+// settled counts a settlement of a message of the consumer
+func (h *ConsumerHandle) settled(settlement Settlement) {
+	switch settlement {
+	case SettlementAck:
+		h.acked.Add(1)
+	case SettlementNack:
+		h.nacked.Add(1)
+	case SettlementReject:
+		h.rejected.Add(1)
+	}
+}
@@ -0,0 +1,421 @@
+package volta
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// listening starts an application on transport with the exchange "test" and the queue "orders",
+// config sets further options
+func listening(t *testing.T, transport *MemoryTransport, config ...Config) *App {
+	t.Helper()
+
+	var cfg Config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.Transport, cfg.DisableLogging = transport, true
+
+	app := New(cfg)
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "orders", Exchange: "test", RoutingKey: "orders"})
+
+	go app.Listen()
+	t.Cleanup(func() { app.Close() })
+
+	waitHealthy(t, app)
+	return app
+}
+
+func waitFor(t *testing.T, condition func() bool, format string, args ...interface{}) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestApp_StartConsumer(t *testing.T) {
+	app := listening(t, NewMemoryTransport())
+
+	received := make(chan string, 1)
+	handle, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		received <- string(ctx.Body())
+		return ctx.Ack(false)
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	if handle.Status() != ConsumerActive || handle.ConsumerTag() == "" {
+		t.Errorf("consumer is %s with tag %q, expected it to be active", handle.Status(), handle.ConsumerTag())
+	}
+	if app.Consumer("orders") != handle {
+		t.Error("App.Consumer() does not return the started consumer")
+	}
+
+	if err := app.Publish("orders", "test", []byte("order")); err != nil {
+		t.Fatalf("App.Publish() error = %v", err)
+	}
+
+	select {
+	case body := <-received:
+		if body != "order" {
+			t.Errorf("Body is %s, expected order", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("runtime consumer received nothing")
+	}
+
+	waitFor(t, func() bool { return handle.Stats().Acked == 1 }, "stats are %+v, expected 1 acked", handle.Stats())
+	if stats := handle.Stats(); stats.Delivered != 1 || stats.LastDelivery.IsZero() {
+		t.Errorf("stats are %+v, expected 1 delivered", stats)
+	}
+
+	if _, err := app.StartConsumer("orders", func(ctx *Ctx) error { return nil }); !errors.Is(err, ErrConsumerExists) {
+		t.Errorf("App.StartConsumer() error = %v, expected %v", err, ErrConsumerExists)
+	}
+}
+
+func TestConsumerHandle_PauseResume(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport)
+
+	received := make(chan string, 2)
+	handle, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		received <- string(ctx.Body())
+		return ctx.Ack(false)
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	if err := handle.Pause(); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if handle.Status() != ConsumerPaused {
+		t.Errorf("consumer is %s, expected %s", handle.Status(), ConsumerPaused)
+	}
+	if status := app.Health().Status; status != HealthOk {
+		t.Errorf("health is %s with a paused consumer, expected %s", status, HealthOk)
+	}
+
+	app.Publish("orders", "test", []byte("while paused"))
+
+	select {
+	case <-received:
+		t.Fatal("paused consumer received a message")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if length := transport.QueueLength("orders"); length != 1 {
+		t.Errorf("queue length is %d, expected the message to wait in the queue", length)
+	}
+
+	if err := handle.Resume(); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+
+	select {
+	case body := <-received:
+		if body != "while paused" {
+			t.Errorf("Body is %s, expected while paused", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resumed consumer received nothing")
+	}
+	if handle.Status() != ConsumerActive {
+		t.Errorf("consumer is %s, expected %s", handle.Status(), ConsumerActive)
+	}
+}
+
+func TestConsumerHandle_Remove(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport)
+
+	if _, err := app.StartConsumer("orders", func(ctx *Ctx) error { return ctx.Ack(false) }); err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	if err := app.RemoveConsumer("orders"); err != nil {
+		t.Fatalf("App.RemoveConsumer() error = %v", err)
+	}
+	if app.Consumer("orders") != nil {
+		t.Error("removed consumer is still registered")
+	}
+	if consumers := app.Health().Consumers; len(consumers) != 0 {
+		t.Errorf("health reports %v, expected no consumers", consumers)
+	}
+
+	app.Publish("orders", "test", []byte("after remove"))
+	if length := transport.QueueLength("orders"); length != 1 {
+		t.Errorf("queue length is %d, expected the message to wait in the queue", length)
+	}
+
+	if err := app.RemoveConsumer("orders"); !errors.Is(err, ErrConsumerNotFound) {
+		t.Errorf("App.RemoveConsumer() error = %v, expected %v", err, ErrConsumerNotFound)
+	}
+}
+
+func TestConsumerHandle_Remove_waits(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport)
+
+	release := make(chan struct{})
+	handle, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		<-release
+		return ctx.Ack(false)
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		app.Publish("orders", "test", []byte("order"))
+	}
+	waitFor(t, func() bool { return handle.Stats().InFlight == 3 }, "stats are %+v, expected 3 in flight", handle.Stats())
+
+	// TEST: Remove returns once the messages being handled are settled
+	removed := make(chan error, 1)
+	go func() { removed <- handle.Remove() }()
+
+	select {
+	case <-removed:
+		t.Fatal("Remove() returned while messages were handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-removed:
+		if err != nil {
+			t.Errorf("Remove() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Remove() did not return after the messages were handled")
+	}
+
+	if stats := handle.Stats(); stats.Acked != 3 || stats.InFlight != 0 {
+		t.Errorf("stats are %+v, expected 3 acked", stats)
+	}
+}
+
+func TestConsumerHandle_pausedBeforeListen(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := New(Config{Transport: transport, DisableLogging: true})
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "orders", Exchange: "test", RoutingKey: "orders"})
+
+	handle, err := app.StartConsumer("orders", func(ctx *Ctx) error { return ctx.Ack(false) })
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+	handle.Pause()
+
+	go app.Listen()
+	defer app.Close()
+	waitHealthy(t, app)
+
+	if handle.Status() != ConsumerPaused || handle.ConsumerTag() != "" {
+		t.Errorf("consumer is %s with tag %q, expected it to stay paused", handle.Status(), handle.ConsumerTag())
+	}
+}
+
+func TestConsumerHandle_resubscribeAfterQueueDeleted(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport, Config{ResubscribeBackoff: 10 * time.Millisecond})
+
+	cancelled := make(chan string, 1)
+	resubscribed := make(chan string, 1)
+	app.OnConsumerCancelled(func(queue, consumerTag string) { cancelled <- consumerTag })
+	app.OnConsumerResubscribed(func(queue, consumerTag string, attempt int) { resubscribed <- consumerTag })
+
+	received := make(chan string, 1)
+	handle, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		received <- string(ctx.Body())
+		return ctx.Ack(false)
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	tag := handle.ConsumerTag()
+	channel := openChannel(t, transport)
+	if _, err := channel.QueueDelete("orders", false, false, false); err != nil {
+		t.Fatalf("QueueDelete() error = %v", err)
+	}
+
+	select {
+	case consumerTag := <-cancelled:
+		if consumerTag != tag {
+			t.Errorf("cancelled tag is %s, expected %s", consumerTag, tag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConsumerCancelled was not called")
+	}
+
+	select {
+	case consumerTag := <-resubscribed:
+		if consumerTag != tag {
+			t.Errorf("resubscribed with tag %q, expected %q", consumerTag, tag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConsumerResubscribed was not called")
+	}
+	if handle.Status() != ConsumerActive {
+		t.Errorf("consumer is %s, expected %s", handle.Status(), ConsumerActive)
+	}
+
+	if err := app.Publish("orders", "test", []byte("after delete")); err != nil {
+		t.Fatalf("App.Publish() error = %v", err)
+	}
+
+	select {
+	case body := <-received:
+		if body != "after delete" {
+			t.Errorf("Body is %s, expected after delete", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resubscribed consumer received nothing")
+	}
+}
+
+func TestConsumerHandle_resubscribeAfterChannelClosed(t *testing.T) {
+	app := listening(t, NewMemoryTransport(), Config{ResubscribeBackoff: 10 * time.Millisecond})
+
+	attempts := make(chan int, 1)
+	app.OnConsumerResubscribed(func(queue, consumerTag string, attempt int) { attempts <- attempt })
+
+	deliveries := make(chan bool, 2)
+	_, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		if !ctx.Delivery.Redelivered {
+			// An inequivalent declaration closes the channel with a precondition failure
+			ctx.Channel.QueueDeclare("orders", true, false, false, false, nil)
+			deliveries <- false
+			return nil
+		}
+
+		deliveries <- true
+		return ctx.Ack(false)
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	app.Publish("orders", "test", []byte("order"))
+
+	for _, redelivered := range []bool{false, true} {
+		select {
+		case got := <-deliveries:
+			if got != redelivered {
+				t.Errorf("Redelivered is %t, expected %t", got, redelivered)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no delivery with Redelivered %t", redelivered)
+		}
+	}
+
+	select {
+	case attempt := <-attempts:
+		if attempt != 1 {
+			t.Errorf("resubscribed after %d attempts, expected 1", attempt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConsumerResubscribed was not called")
+	}
+}
+
+func TestConsumerHandle_resubscribeBackoff(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport, Config{ResubscribeBackoff: 10 * time.Millisecond, ResubscribeMaxBackoff: 20 * time.Millisecond})
+
+	attempts := make(chan int, 1)
+	app.OnConsumerResubscribed(func(queue, consumerTag string, attempt int) { attempts <- attempt })
+
+	// The queue is not part of the topology, it is not declared again when the consumer resubscribes
+	channel := openChannel(t, transport)
+	if _, err := channel.QueueDeclare("payments", false, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare() error = %v", err)
+	}
+
+	if _, err := app.StartConsumer("payments", func(ctx *Ctx) error { return ctx.Ack(false) }); err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	if _, err := channel.QueueDelete("payments", false, false, false); err != nil {
+		t.Fatalf("QueueDelete() error = %v", err)
+	}
+
+	// TEST: the failing attempts are counted across the closed channels
+	time.Sleep(100 * time.Millisecond)
+	if _, err := channel.QueueDeclare("payments", false, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare() error = %v", err)
+	}
+
+	select {
+	case attempt := <-attempts:
+		if attempt < 3 {
+			t.Errorf("resubscribed after %d attempts, expected at least 3", attempt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnConsumerResubscribed was not called")
+	}
+}
+
+func TestApp_consumerTag(t *testing.T) {
+	app := New(Config{Transport: NewMemoryTransport(), DisableLogging: true, ServiceName: "billing", Instance: 2})
+	app.hostname = "host"
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "billing.host.orders.2"},
+		{"{service}-{queue}", "billing-orders"},
+		{"static", "static"},
+	}
+	for _, tt := range tests {
+		if got := app.consumerTag(tt.template, "orders"); got != tt.want {
+			t.Errorf("consumerTag(%q) = %s, expected %s", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestConsumerOptions_arguments(t *testing.T) {
+	if args := (ConsumerOptions{}).arguments(); args != nil {
+		t.Errorf("arguments() = %v, expected nil", args)
+	}
+
+	options := ConsumerOptions{Priority: 10, Args: amqp091.Table{"x-stream-offset": "first"}}
+	args := options.arguments()
+	if args["x-priority"] != int32(10) || args["x-stream-offset"] != "first" {
+		t.Errorf("arguments() = %v, expected x-priority and x-stream-offset", args)
+	}
+	if _, ok := options.Args["x-priority"]; ok {
+		t.Error("arguments() modified ConsumerOptions.Args")
+	}
+}
+
+func TestApp_StartConsumerWithOptions_exclusive(t *testing.T) {
+	app := listening(t, NewMemoryTransport())
+
+	handle, err := app.StartConsumerWithOptions("orders", ConsumerOptions{Tag: "{queue}-worker", Exclusive: true}, func(ctx *Ctx) error {
+		return ctx.Ack(false)
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumerWithOptions() error = %v", err)
+	}
+	if tag := handle.ConsumerTag(); tag != "orders-worker" {
+		t.Errorf("ConsumerTag() = %s, expected orders-worker", tag)
+	}
+
+	if _, err := app.ConsumeNative("orders"); err == nil {
+		t.Error("App.ConsumeNative() consumed a queue with an exclusive consumer")
+	}
+}
@@ -4,60 +4,155 @@ import (
 	"context"
 	"encoding/xml"
 	"github.com/rabbitmq/amqp091-go"
+	"sync/atomic"
 	"time"
 )
 
 type Ctx struct {
 	App           *App
 	Delivery      amqp091.Delivery
-	Channel       *amqp091.Channel
+	Channel       Channel
 	handlers      []Handler
 	handlerCursor int
 
-	locals map[string]interface{}
+	locals  map[string]interface{}
+	context context.Context
+	settled int32
+
+	// queue the message was consumed from
+	queue string
+
+	// params of the route matched by a Router
+	params map[string]string
+
+	// consumer the message was delivered to, nil for batches and contexts created with NewCtx
+	consumer *ConsumerHandle
+
+	// origin is the context a detached copy settles the message through
+	origin *Ctx
 }
 
-func (ctx *Ctx) Reply(data []byte) error {
-	replyCtx, cancel := context.WithTimeout(context.Background(), time.Duration(ctx.App.config.Timeout)*time.Second)
-	defer cancel()
+// NewCtx creates the context of a delivery consumed from queue and handled by handlers,
+// e.g. to run handlers without a broker, see the voltatest package.
+// The chain is started by calling the first handler with the context.
+func NewCtx(app *App, channel Channel, queue string, delivery amqp091.Delivery, handlers ...Handler) *Ctx {
+	return &Ctx{App: app, Delivery: delivery, Channel: channel, handlers: handlers, queue: queue}
+}
 
-	ctx.Channel.PublishWithContext(
-		replyCtx,
-		"",
-		ctx.Delivery.ReplyTo,
-		false,
-		false,
-		amqp091.Publishing{
-			CorrelationId: ctx.Delivery.CorrelationId,
-			Body:          data,
-		},
-	)
+// ReplyOptions controls the properties of a reply and when the delivery is acknowledged
+type ReplyOptions struct {
+	// ContentType of the reply, set by ReplyJSON / ReplyXML when empty
+	ContentType string
 
-	return ctx.Ack(false)
+	// Type of the reply message, it is never set automatically
+	Type string
+
+	// Headers of the reply message
+	Headers amqp091.Table
+
+	// NoAck leaves the acknowledgement of the delivery to the handler,
+	// e.g. to acknowledge only after a database commit
+	NoAck bool
 }
 
-func (ctx *Ctx) ReplyJSON(data interface{}) error {
+// Reply publishes data to the reply_to queue of the message and acknowledges the delivery
+// unless ReplyOptions.NoAck is set. The delivery is not acknowledged if the reply could not be published.
+func (ctx *Ctx) Reply(data []byte, options ...ReplyOptions) error {
+	cfg := replyOptions(options...)
+	if cfg.ContentType == "" {
+		cfg.ContentType = "text/plain"
+	}
+
+	return ctx.reply(data, cfg)
+}
+
+// ReplyJSON marshals data to JSON and replies with it, see Reply
+func (ctx *Ctx) ReplyJSON(data interface{}, options ...ReplyOptions) error {
 	jsonData, err := ctx.App.config.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	return ctx.Reply(jsonData)
+	cfg := replyOptions(options...)
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+
+	return ctx.reply(jsonData, cfg)
 }
 
-func (ctx *Ctx) ReplyXML(data interface{}) error {
+// ReplyXML marshals data to XML and replies with it, see Reply
+func (ctx *Ctx) ReplyXML(data interface{}, options ...ReplyOptions) error {
 	xmlData, err := xml.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	return ctx.Reply(xmlData)
+	cfg := replyOptions(options...)
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/xml"
+	}
+
+	return ctx.reply(xmlData, cfg)
 }
 
+func (ctx *Ctx) reply(data []byte, options ReplyOptions) error {
+	if ctx.Delivery.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+
+	if ctx.Settled() {
+		return ErrAlreadySettled
+	}
+
+	msg := amqp091.Publishing{
+		ContentType:   options.ContentType,
+		Type:          options.Type,
+		Headers:       options.Headers,
+		CorrelationId: ctx.Delivery.CorrelationId,
+		Body:          data,
+	}
+
+	replyCtx, end := ctx.App.startPublish(ctx.Context(), "", ctx.Delivery.ReplyTo, &msg)
+	start := time.Now()
+
+	replyCtx, cancel := context.WithTimeout(replyCtx, ctx.App.timeout())
+	defer cancel()
+
+	err := ctx.Channel.PublishWithContext(
+		replyCtx,
+		"",
+		ctx.Delivery.ReplyTo,
+		false,
+		false,
+		msg,
+	)
+	ctx.metrics().MessagePublished("", time.Since(start), err)
+	end(err)
+	if err != nil {
+		return err
+	}
+
+	if options.NoAck {
+		return nil
+	}
+
+	return ctx.Ack(false)
+}
+
+func replyOptions(options ...ReplyOptions) ReplyOptions {
+	if len(options) < 1 {
+		return ReplyOptions{}
+	}
+
+	return options[0]
+}
+
+// Next executes the next handler in the chain and returns its error
 func (ctx *Ctx) Next() error {
 	ctx.handlerCursor++
 	if ctx.handlerCursor < len(ctx.handlers) {
-		ctx.handlers[ctx.handlerCursor](ctx)
+		return ctx.handlers[ctx.handlerCursor](ctx)
 	}
 
 	return nil
@@ -115,15 +210,115 @@ func GenericBindXML[T any](ctx *Ctx) T {
 	return data
 }
 
+// Context returns the context of the message, it carries the deadline set by middlewares such as timeout
+func (ctx *Ctx) Context() context.Context {
+	if ctx.context == nil {
+		return context.Background()
+	}
+
+	return ctx.context
+}
+
+// SetContext replaces the context of the message
+func (ctx *Ctx) SetContext(c context.Context) {
+	ctx.context = c
+}
+
+// Detach returns a copy of the context running the rest of the chain, e.g. on a goroutine that may outlive
+// the current handler. The copy has its own locals and position in the chain but shares the settlement,
+// so the message is settled at most once by either.
+func (ctx *Ctx) Detach() *Ctx {
+	detached := *ctx
+	detached.settled = 0
+	detached.origin = ctx.settlement()
+
+	detached.locals = make(map[string]interface{}, len(ctx.locals))
+	for key, value := range ctx.locals {
+		detached.locals[key] = value
+	}
+
+	return &detached
+}
+
+// settlement returns the context holding the settlement of the message
+func (ctx *Ctx) settlement() *Ctx {
+	if ctx.origin != nil {
+		return ctx.origin
+	}
+
+	return ctx
+}
+
+// Settled reports whether the message was already acknowledged, negatively acknowledged or rejected
+func (ctx *Ctx) Settled() bool {
+	return atomic.LoadInt32(&ctx.settlement().settled) != 0
+}
+
+// Settlement returns how the message was settled, empty while it is not
+func (ctx *Ctx) Settlement() Settlement {
+	switch atomic.LoadInt32(&ctx.settlement().settled) {
+	case settledAck:
+		return SettlementAck
+	case settledNack:
+		return SettlementNack
+	case settledReject:
+		return SettlementReject
+	}
+
+	return ""
+}
+
+// Values of Ctx.settled
+const (
+	settledAck int32 = iota + 1
+	settledNack
+	settledReject
+)
+
+// settle marks the message as settled, it reports false if it already was
+func (ctx *Ctx) settle(how int32) bool {
+	return atomic.CompareAndSwapInt32(&ctx.settlement().settled, 0, how)
+}
+
+// recordSettlement reports the settlement to the metrics and to the statistics of the consumer
+func (ctx *Ctx) recordSettlement(settlement Settlement) {
+	ctx.metrics().MessageSettled(ctx.queue, settlement)
+
+	if ctx.consumer != nil {
+		ctx.consumer.settled(settlement)
+	}
+}
+
+// Ack acknowledges the message, settling a message twice returns ErrAlreadySettled
 func (ctx *Ctx) Ack(multiple bool) error {
+	if !ctx.settle(settledAck) {
+		return ErrAlreadySettled
+	}
+
+	ctx.recordSettlement(SettlementAck)
+
 	return ctx.Delivery.Ack(multiple)
 }
 
+// Nack negatively acknowledges the message, settling a message twice returns ErrAlreadySettled
 func (ctx *Ctx) Nack(multiple, requeue bool) error {
+	if !ctx.settle(settledNack) {
+		return ErrAlreadySettled
+	}
+
+	ctx.recordSettlement(SettlementNack)
+
 	return ctx.Delivery.Nack(multiple, requeue)
 }
 
+// Reject rejects the message, settling a message twice returns ErrAlreadySettled
 func (ctx *Ctx) Reject(requeue bool) error {
+	if !ctx.settle(settledReject) {
+		return ErrAlreadySettled
+	}
+
+	ctx.recordSettlement(SettlementReject)
+
 	return ctx.Delivery.Reject(requeue)
 }
 
@@ -186,3 +381,8 @@ func (ctx *Ctx) Exchange() string {
 func (ctx *Ctx) RoutingKey() string {
 	return ctx.Delivery.RoutingKey
 }
+
+// Queue returns the name of the queue the message was consumed from
+func (ctx *Ctx) Queue() string {
+	return ctx.queue
+}
@@ -0,0 +1,150 @@
+package volta
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestCtx_ReplyWithoutReplyTo(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	ctx := &Ctx{App: app, Delivery: amqp091.Delivery{CorrelationId: "test"}}
+
+	if err := ctx.Reply([]byte("test")); !errors.Is(err, ErrNoReplyTo) {
+		t.Errorf("Ctx.Reply() error = %v, expected %v", err, ErrNoReplyTo)
+	}
+
+	if err := ctx.ReplyJSON(Map{"test": true}, ReplyOptions{NoAck: true}); !errors.Is(err, ErrNoReplyTo) {
+		t.Errorf("Ctx.ReplyJSON() error = %v, expected %v", err, ErrNoReplyTo)
+	}
+
+	if _, err := ctx.ReplyStream(); !errors.Is(err, ErrNoReplyTo) {
+		t.Errorf("Ctx.ReplyStream() error = %v, expected %v", err, ErrNoReplyTo)
+	}
+}
+
+func TestCtx_Reply(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	if _, err := channel.QueueDeclare("replies", false, false, false, false, nil); err != nil {
+		t.Fatalf("QueueDeclare() error = %v", err)
+	}
+	replies, err := channel.Consume("replies", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	type body struct{ Test bool }
+
+	tests := []struct {
+		name        string
+		reply       func(ctx *Ctx) error
+		contentType string
+		acked       bool
+	}{
+		{"reply", func(ctx *Ctx) error { return ctx.Reply([]byte("test")) }, "text/plain", true},
+		{"json", func(ctx *Ctx) error { return ctx.ReplyJSON(Map{"test": true}) }, "application/json", true},
+		{"xml", func(ctx *Ctx) error { return ctx.ReplyXML(body{Test: true}) }, "application/xml", true},
+		{"content type", func(ctx *Ctx) error {
+			return ctx.ReplyJSON(Map{"test": true}, ReplyOptions{ContentType: "application/vnd.test+json"})
+		}, "application/vnd.test+json", true},
+		{"no ack", func(ctx *Ctx) error { return ctx.Reply([]byte("test"), ReplyOptions{NoAck: true}) }, "text/plain", false},
+	}
+
+	for _, test := range tests {
+		acknowledger := &recordingAcknowledger{acks: map[uint64]bool{}, nacks: map[uint64]bool{}}
+		ctx := NewCtx(app, channel, "test", amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, ReplyTo: "replies", CorrelationId: test.name})
+
+		if err := test.reply(ctx); err != nil {
+			t.Fatalf("%s: error = %v", test.name, err)
+		}
+
+		reply := receive(t, replies)
+		if reply.ContentType != test.contentType || reply.CorrelationId != test.name {
+			t.Errorf("%s: reply is %s with correlation id %s, expected %s with %s", test.name, reply.ContentType, reply.CorrelationId, test.contentType, test.name)
+		}
+
+		// TEST: the type is only set by the caller
+		if reply.Type != "" {
+			t.Errorf("%s: reply type is %s, expected none", test.name, reply.Type)
+		}
+
+		if _, acked := acknowledger.acks[1]; acked != test.acked || ctx.Settled() != test.acked {
+			t.Errorf("%s: delivery acked = %v, expected %v", test.name, acked, test.acked)
+		}
+	}
+}
+
+func TestCtx_Reply_failed(t *testing.T) {
+	channel := openChannel(t, NewMemoryTransport())
+	if err := channel.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	acknowledger := &recordingAcknowledger{acks: map[uint64]bool{}, nacks: map[uint64]bool{}}
+	ctx := NewCtx(New(Config{DisableLogging: true}), channel, "test", amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: 1, ReplyTo: "replies"})
+
+	// TEST: the delivery is not acknowledged when the reply could not be published
+	if err := ctx.Reply([]byte("test")); !errors.Is(err, amqp091.ErrClosed) {
+		t.Errorf("Ctx.Reply() error = %v, expected %v", err, amqp091.ErrClosed)
+	}
+
+	if len(acknowledger.acks) != 0 || ctx.Settled() {
+		t.Error("Delivery was acknowledged after a failed reply")
+	}
+}
+
+func TestCtx_Next(t *testing.T) {
+	failed := errors.New("failed")
+
+	var returned error
+	ctx := NewCtx(New(Config{DisableLogging: true}), nil, "test", amqp091.Delivery{},
+		func(ctx *Ctx) error {
+			returned = ctx.Next()
+			return returned
+		},
+		func(ctx *Ctx) error {
+			return failed
+		},
+	)
+
+	if err := ctx.handlers[0](ctx); !errors.Is(err, failed) {
+		t.Errorf("handler error = %v, expected %v", err, failed)
+	}
+
+	if !errors.Is(returned, failed) {
+		t.Errorf("Ctx.Next() error = %v, expected %v", returned, failed)
+	}
+
+	if err := ctx.Next(); err != nil {
+		t.Errorf("Ctx.Next() error = %v at the end of the chain, expected nil", err)
+	}
+}
+
+func TestCtx_Detach(t *testing.T) {
+	ctx := NewCtx(New(Config{DisableLogging: true}), nil, "test", amqp091.Delivery{Acknowledger: &recordingAcknowledger{acks: map[uint64]bool{}, nacks: map[uint64]bool{}}})
+	ctx.Locals("key", "value")
+
+	detached := ctx.Detach()
+	detached.Locals("key", "changed")
+
+	if ctx.Locals("key") != "value" || detached.Locals("key") != "changed" {
+		t.Errorf("Locals are %v and %v, expected value and changed", ctx.Locals("key"), detached.Locals("key"))
+	}
+
+	// TEST: the copies share the settlement
+	if err := detached.Nack(false, true); err != nil {
+		t.Fatalf("Ctx.Nack() error = %v", err)
+	}
+
+	if err := ctx.Ack(false); !errors.Is(err, ErrAlreadySettled) {
+		t.Errorf("Ctx.Ack() error = %v, expected %v", err, ErrAlreadySettled)
+	}
+
+	if ctx.Settlement() != SettlementNack || detached.Detach().Settlement() != SettlementNack {
+		t.Errorf("Settlement is %s, expected nack", ctx.Settlement())
+	}
+}
@@ -0,0 +1,148 @@
+package volta
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// DelayMode selects how PublishAt and PublishAfter delay messages
+type DelayMode int
+
+const (
+	// DelayQueues holds a delayed message in a queue per exchange and delay, whose x-message-ttl dead-letters it
+	// into the exchange. It needs no plugin, the queues expire a minute after their last use.
+	// Delays are rounded up to two significant digits so they share queues, a message is at most 10% late.
+	DelayQueues DelayMode = iota
+
+	// DelayPlugin publishes a delayed message with an x-delay header to an x-delayed-message exchange bound to
+	// the exchange, it needs the rabbitmq_delayed_message_exchange plugin. It cannot delay the default exchange.
+	DelayPlugin
+)
+
+// Prefix of the exchanges and queues declared for delayed messages
+const delayPrefix = "volta.delay."
+
+// Time an unused delay queue is kept after its delay passed
+const delayQueueExpiry = time.Minute
+
+// PublishAt publishes a message to be delivered by the exchange at the given time, see PublishAfter
+func (a *App) PublishAt(name, exchange string, body []byte, at time.Time) error {
+	return a.PublishAfterWithContext(context.Background(), name, exchange, body, time.Until(at))
+}
+
+// PublishAfter publishes a message to be delivered by the exchange once delay passed, with a precision of a millisecond
+// with DelayPlugin and of two significant digits with DelayQueues. A delay that is not positive publishes right away.
+// The topology is declared as needed, see Config.DelayMode.
+func (a *App) PublishAfter(name, exchange string, body []byte, delay time.Duration) error {
+	return a.PublishAfterWithContext(context.Background(), name, exchange, body, delay)
+}
+
+// PublishAfterWithContext is like PublishAfter, ctx carries the trace context of the message.
+func (a *App) PublishAfterWithContext(ctx context.Context, name, exchange string, body []byte, delay time.Duration) (err error) {
+	delay = delay.Round(time.Millisecond)
+	if delay <= 0 {
+		return a.PublishWithContext(ctx, name, exchange, body)
+	}
+	if a.config.DelayMode == DelayPlugin && exchange == "" {
+		return ErrDelayDefaultExchange
+	}
+
+	msg := amqp091.Publishing{
+		ContentType: "text/plain",
+		Body:        body,
+	}
+
+	ctx, end := a.startPublish(ctx, exchange, name, &msg)
+	start := time.Now()
+	defer func() {
+		a.config.Metrics.MessagePublished(exchange, time.Since(start), err)
+		end(err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout())
+	defer cancel()
+
+	connection, err := a.dial()
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	var target string
+	if a.config.DelayMode == DelayPlugin {
+		target, err = declareDelayedExchange(channel, exchange)
+		if msg.Headers == nil {
+			msg.Headers = amqp091.Table{}
+		}
+		msg.Headers["x-delay"] = delay.Milliseconds()
+	} else {
+		target, err = declareDelayQueue(channel, exchange, delayBucket(delay))
+	}
+	if err != nil {
+		return err
+	}
+
+	return channel.PublishWithContext(
+		ctx,
+		target,
+		name,
+		false,
+		false,
+		msg)
+}
+
+// declareDelayedExchange declares the x-delayed-message exchange forwarding to the exchange and returns its name
+func declareDelayedExchange(channel Channel, exchange string) (string, error) {
+	name := delayPrefix + exchange
+
+	err := channel.ExchangeDeclare(name, "x-delayed-message", true, false, false, false, amqp091.Table{"x-delayed-type": amqp091.ExchangeFanout})
+	if err != nil {
+		return "", err
+	}
+
+	return name, channel.ExchangeBind(exchange, "", name, false, nil)
+}
+
+// delayBucket rounds delay up to two significant digits of milliseconds, e.g. 1234ms to 1.3s.
+// A message is at most 10% late and an exchange needs at most 90 delay queues per order of magnitude
+// instead of one per millisecond.
+func delayBucket(delay time.Duration) time.Duration {
+	ms := delay.Milliseconds()
+
+	step := int64(1)
+	for ms/step >= 100 {
+		step *= 10
+	}
+
+	return time.Duration((ms+step-1)/step*step) * time.Millisecond
+}
+
+// declareDelayQueue declares the fanout exchange and the queue holding the messages of the exchange for delay
+// and returns the name of the exchange. The queue dead-letters them with their routing key into the exchange.
+func declareDelayQueue(channel Channel, exchange string, delay time.Duration) (string, error) {
+	name := delayPrefix + exchange + "." + strconv.FormatInt(delay.Milliseconds(), 10)
+
+	if err := channel.ExchangeDeclare(name, amqp091.ExchangeFanout, true, true, false, false, nil); err != nil {
+		return "", err
+	}
+
+	_, err := channel.QueueDeclare(name, true, false, false, false, amqp091.Table{
+		"x-message-ttl":          delay.Milliseconds(),
+		"x-expires":              (delay + delayQueueExpiry).Milliseconds(),
+		"x-dead-letter-exchange": exchange,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return name, channel.QueueBind(name, "", name, false, nil)
+}
@@ -0,0 +1,77 @@
+package volta
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApp_PublishAfter(t *testing.T) {
+	for name, mode := range map[string]DelayMode{"queues": DelayQueues, "plugin": DelayPlugin} {
+		t.Run(name, func(t *testing.T) {
+			transport := NewMemoryTransport()
+			app := listening(t, transport, Config{DelayMode: mode})
+
+			if err := app.PublishAfter("orders", "test", []byte("later"), 100*time.Millisecond); err != nil {
+				t.Fatalf("App.PublishAfter() error = %v", err)
+			}
+			if err := app.PublishAfter("orders", "test", []byte("sooner"), 20*time.Millisecond); err != nil {
+				t.Fatalf("App.PublishAfter() error = %v", err)
+			}
+			if length := transport.QueueLength("orders"); length != 0 {
+				t.Errorf("queue length is %d right after publishing, expected 0", length)
+			}
+
+			waitFor(t, func() bool { return transport.QueueLength("orders") == 1 }, "the sooner message was not delivered")
+			waitFor(t, func() bool { return transport.QueueLength("orders") == 2 }, "the later message was not delivered")
+
+			messages, err := openChannel(t, transport).Consume("orders", "", true, false, false, false, nil)
+			if err != nil {
+				t.Fatalf("Consume() error = %v", err)
+			}
+			first := receive(t, messages)
+			if body := string(first.Body); body != "sooner" || first.RoutingKey != "orders" {
+				t.Errorf("first message is %s with routing key %s, expected sooner with orders", body, first.RoutingKey)
+			}
+		})
+	}
+}
+
+func TestApp_PublishAfter_defaultExchange(t *testing.T) {
+	app := listening(t, NewMemoryTransport(), Config{DelayMode: DelayPlugin})
+
+	if err := app.PublishAfter("orders", "", []byte("later"), time.Second); !errors.Is(err, ErrDelayDefaultExchange) {
+		t.Errorf("App.PublishAfter() error = %v, expected %v", err, ErrDelayDefaultExchange)
+	}
+}
+
+func TestApp_PublishAt_past(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport)
+
+	if err := app.PublishAt("orders", "test", []byte("now"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("App.PublishAt() error = %v", err)
+	}
+	if length := transport.QueueLength("orders"); length != 1 {
+		t.Errorf("queue length is %d, expected the message to be published right away", length)
+	}
+}
+
+func TestDelayBucket(t *testing.T) {
+	tests := []struct {
+		delay    time.Duration
+		expected time.Duration
+	}{
+		{20 * time.Millisecond, 20 * time.Millisecond},
+		{99 * time.Millisecond, 99 * time.Millisecond},
+		{1234 * time.Millisecond, 1300 * time.Millisecond},
+		{1200 * time.Millisecond, 1200 * time.Millisecond},
+		{90*time.Minute + time.Millisecond, 5500 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if bucket := delayBucket(tt.delay); bucket != tt.expected {
+			t.Errorf("delayBucket(%s) = %s, expected %s", tt.delay, bucket, tt.expected)
+		}
+	}
+}
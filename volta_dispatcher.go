@@ -0,0 +1,112 @@
+package volta
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownType is returned by UnknownType when no handler is registered for the type of a message
+var ErrUnknownType = errors.New("volta: No handler for the message type")
+
+// Dispatcher dispatches the messages of one consumer to typed handlers by their message type,
+// read from the type property or from a header
+type Dispatcher struct {
+	config DispatcherConfig
+
+	mutex    sync.RWMutex
+	handlers map[string][]Handler
+}
+
+type DispatcherConfig struct {
+	// TypeHeader is the header holding the message type, the type property is used when empty
+	TypeHeader string
+
+	// Unknown handles the messages of a type without handler
+	Unknown Handler
+}
+
+var DefaultDispatcherConfig = DispatcherConfig{
+	TypeHeader: "",
+	Unknown:    UnknownType,
+}
+
+// UnknownType rejects the message without requeueing, it is dropped or dead-lettered
+func UnknownType(ctx *Ctx) error {
+	if err := ctx.Reject(false); err != nil {
+		return err
+	}
+
+	return ErrUnknownType
+}
+
+// NewDispatcher creates a dispatcher, its Handle method is the handler of the consumer
+func NewDispatcher(config ...DispatcherConfig) *Dispatcher {
+	cfg := DefaultDispatcherConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.Unknown == nil {
+		cfg.Unknown = DefaultDispatcherConfig.Unknown
+	}
+
+	return &Dispatcher{config: cfg, handlers: make(map[string][]Handler)}
+}
+
+// On registers the callback for the messages of the given type, replacing the previous one.
+// The body is decoded into Data with XMLConsumer when the content type is XML and with JSONConsumer otherwise,
+// middlewares run before decoding.
+func On[Data any](d *Dispatcher, messageType string, callback func(ctx *Ctx, body Data) error, middlewares ...Handler) *Dispatcher {
+	jsonHandler := JSONConsumer(callback)
+	xmlHandler := XMLConsumer(callback)
+
+	handler := func(ctx *Ctx) error {
+		if isXML(ctx.ContentType()) {
+			return xmlHandler(ctx)
+		}
+
+		return jsonHandler(ctx)
+	}
+
+	handlers := make([]Handler, 0, len(middlewares)+1)
+	handlers = append(handlers, middlewares...)
+	handlers = append(handlers, handler)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.handlers[messageType] = handlers
+
+	return d
+}
+
+func isXML(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// messageType returns the type of the message the handler is chosen by
+func (d *Dispatcher) messageType(ctx *Ctx) string {
+	if d.config.TypeHeader == "" {
+		return ctx.Type()
+	}
+
+	value, _ := ctx.Delivery.Headers[d.config.TypeHeader].(string)
+	return value
+}
+
+// Handle dispatches the message to the handler of its type, or to the unknown handler.
+// The typed handler replaces the rest of the chain, so the dispatcher is the last handler of a consumer or route.
+func (d *Dispatcher) Handle(ctx *Ctx) error {
+	d.mutex.RLock()
+	handlers, ok := d.handlers[d.messageType(ctx)]
+	d.mutex.RUnlock()
+
+	if !ok {
+		return d.config.Unknown(ctx)
+	}
+
+	return ctx.dispatch(ctx.params, handlers)
+}
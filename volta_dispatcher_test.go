@@ -0,0 +1,126 @@
+package volta
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type orderCreated struct {
+	Id     int    `json:"id" xml:"id"`
+	Region string `json:"region" xml:"region"`
+}
+
+type orderCancelled struct {
+	Id     int    `json:"id"`
+	Reason string `json:"reason"`
+}
+
+func dispatched(app *App, dispatcher *Dispatcher, delivery amqp091.Delivery) (*Ctx, error) {
+	delivery.Acknowledger = &recordingAcknowledger{acks: map[uint64]bool{}, nacks: map[uint64]bool{}}
+	delivery.DeliveryTag = 1
+
+	ctx := NewCtx(app, nil, "events", delivery, dispatcher.Handle)
+	return ctx, dispatcher.Handle(ctx)
+}
+
+func TestDispatcher_On(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	dispatcher := NewDispatcher()
+
+	var created orderCreated
+	var cancelled orderCancelled
+	On(dispatcher, "order.created", func(ctx *Ctx, body orderCreated) error {
+		created = body
+		return ctx.Ack(false)
+	})
+	On(dispatcher, "order.cancelled", func(ctx *Ctx, body orderCancelled) error {
+		cancelled = body
+		return ctx.Ack(false)
+	})
+
+	if _, err := dispatched(app, dispatcher, amqp091.Delivery{Type: "order.created", ContentType: "application/json", Body: []byte(`{"id":1,"region":"eu"}`)}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if created.Id != 1 || created.Region != "eu" {
+		t.Errorf("order.created body is %+v", created)
+	}
+
+	if _, err := dispatched(app, dispatcher, amqp091.Delivery{Type: "order.cancelled", Body: []byte(`{"id":2,"reason":"late"}`)}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if cancelled.Id != 2 || cancelled.Reason != "late" {
+		t.Errorf("order.cancelled body is %+v", cancelled)
+	}
+
+	if _, err := dispatched(app, dispatcher, amqp091.Delivery{Type: "order.created", ContentType: "application/xml", Body: []byte(`<order><id>3</id><region>us</region></order>`)}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if created.Id != 3 || created.Region != "us" {
+		t.Errorf("XML order.created body is %+v", created)
+	}
+}
+
+func TestDispatcher_typeHeader(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	dispatcher := NewDispatcher(DispatcherConfig{TypeHeader: "x-event"})
+
+	var calls []string
+	On(dispatcher, "order.created", func(ctx *Ctx, body orderCreated) error {
+		calls = append(calls, "handler")
+		return nil
+	}, func(ctx *Ctx) error {
+		calls = append(calls, "middleware")
+		return ctx.Next()
+	})
+
+	delivery := amqp091.Delivery{Type: "ignored", Headers: amqp091.Table{"x-event": "order.created"}, Body: []byte(`{}`)}
+	if _, err := dispatched(app, dispatcher, delivery); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if len(calls) != 2 || calls[0] != "middleware" || calls[1] != "handler" {
+		t.Errorf("calls are %v, expected the middleware then the handler", calls)
+	}
+}
+
+func TestDispatcher_unknown(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	dispatcher := NewDispatcher()
+	On(dispatcher, "order.created", func(ctx *Ctx, body orderCreated) error { return nil })
+
+	ctx, err := dispatched(app, dispatcher, amqp091.Delivery{Type: "user.created"})
+	if !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Handle() error = %v, expected %v", err, ErrUnknownType)
+	}
+	if ctx.Settlement() != SettlementReject {
+		t.Errorf("Settlement is %q, expected %q", ctx.Settlement(), SettlementReject)
+	}
+
+	fallback := NewDispatcher(DispatcherConfig{Unknown: func(ctx *Ctx) error { return ctx.Ack(false) }})
+	if ctx, err := dispatched(app, fallback, amqp091.Delivery{Type: "user.created"}); err != nil || ctx.Settlement() != SettlementAck {
+		t.Errorf("fallback settled %q with %v, expected an ack", ctx.Settlement(), err)
+	}
+}
+
+func TestDispatcher_bindError(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	dispatcher := NewDispatcher()
+	On(dispatcher, "order.created", func(ctx *Ctx, body orderCreated) error {
+		t.Error("handler called with an invalid body")
+		return nil
+	})
+
+	if _, err := dispatched(app, dispatcher, amqp091.Delivery{Type: "order.created", Body: []byte(`{`)}); err == nil {
+		t.Error("Handle() returned no error for an invalid body")
+	}
+
+	app.OnBindError(func(ctx *Ctx, err error) error {
+		return ctx.Reject(false)
+	})
+
+	ctx, err := dispatched(app, dispatcher, amqp091.Delivery{Type: "order.created", Body: []byte(`{`)})
+	if err != nil || ctx.Settlement() != SettlementReject {
+		t.Errorf("OnBindError settled %q with %v, expected a reject", ctx.Settlement(), err)
+	}
+}
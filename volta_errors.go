@@ -1,5 +1,53 @@
 package volta
 
+import "errors"
+
+var (
+	// ErrNoReplyTo is returned when replying to a message that has no reply_to property
+	ErrNoReplyTo = errors.New("volta: Cannot reply to a message without reply_to")
+
+	// ErrAlreadySettled is returned when acknowledging or replying to a message that was already settled,
+	// e.g. by a middleware after a timeout
+	ErrAlreadySettled = errors.New("volta: Message is already settled")
+
+	// ErrStreamClosed is returned when writing to or reading from a stream that has already ended
+	ErrStreamClosed = errors.New("volta: Stream is closed")
+
+	// ErrStreamCancelled is returned when the requester cancelled the stream
+	ErrStreamCancelled = errors.New("volta: Stream is cancelled")
+
+	// ErrStreamTimeout is returned when the requester did not grant credit in time
+	ErrStreamTimeout = errors.New("volta: Stream timed out waiting for credit")
+
+	// ErrStreamOutOfOrder is returned when a chunk arrives out of sequence
+	ErrStreamOutOfOrder = errors.New("volta: Stream chunk out of order")
+
+	// ErrRequestTimeout is returned when no reply arrived within Config.Timeout
+	ErrRequestTimeout = errors.New("volta: Request timed out waiting for reply")
+
+	// ErrConsumersStarted is returned when adding middlewares after the consumers started
+	ErrConsumersStarted = errors.New("volta: Cannot add middlewares after the consumers started")
+
+	// ErrConsumerExists is returned when starting a consumer of a queue that already has one
+	ErrConsumerExists = errors.New("volta: Queue already has a consumer")
+
+	// ErrConsumerNotFound is returned when controlling a consumer that does not exist or was removed
+	ErrConsumerNotFound = errors.New("volta: Consumer not found")
+
+	// ErrDelayDefaultExchange is returned when delaying a message of the default exchange with DelayPlugin,
+	// the default exchange cannot be bound to the delayed message exchange
+	ErrDelayDefaultExchange = errors.New("volta: Cannot delay the default exchange with DelayPlugin")
+)
+
 func (a *App) OnBindError(handler OnBindError) {
 	a.onBindError = handler
 }
+
+// bindError hands a decoding error to the OnBindError handler, or returns it when there is none
+func (a *App) bindError(ctx *Ctx, err error) error {
+	if a == nil || a.onBindError == nil {
+		return err
+	}
+
+	return a.onBindError(ctx, err)
+}
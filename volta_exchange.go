@@ -18,7 +18,7 @@ func (a *App) AddExchanges(exchange ...Exchange) {
 // declareExchange declares the given exchange to RabbitMQ
 // Internal use only
 func (a *App) declareExchange(exchange Exchange) error {
-	channel, err := a.baseConnection.Channel()
+	channel, err := a.connection().Channel()
 	if err != nil {
 		return err
 	}
@@ -31,7 +31,7 @@ func (a *App) declareExchange(exchange Exchange) error {
 // If force is true, the exchange will be deleted even if it is in use
 // If force is false, the exchange will be deleted only if it is not in use
 func (a *App) PurgeExchange(name string, force bool) error {
-	channel, err := a.baseConnection.Channel()
+	channel, err := a.connection().Channel()
 	if err != nil {
 		return err
 	}
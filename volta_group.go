@@ -0,0 +1,53 @@
+package volta
+
+// Group adds consumers whose queue names share a prefix and applies its middlewares to them.
+// Middlewares run in a deterministic order: the global ones added with App.Use, those of the groups
+// from the outermost to the innermost, those of ConsumerOptions, then the handlers of the consumer.
+type Group struct {
+	app         *App
+	parent      *Group
+	prefix      string
+	middlewares []Handler
+}
+
+// Group creates a group of consumers, prefix is prepended to the queue names of its consumers
+func (a *App) Group(prefix string, middlewares ...Handler) *Group {
+	return &Group{app: a, prefix: prefix, middlewares: middlewares}
+}
+
+// Group creates a nested group, its prefix and middlewares follow those of g
+func (g *Group) Group(prefix string, middlewares ...Handler) *Group {
+	return &Group{app: g.app, parent: g, prefix: g.prefix + prefix, middlewares: middlewares}
+}
+
+// Use adds middlewares to the group, it returns ErrConsumersStarted once the consumers are started
+func (g *Group) Use(middlewares ...Handler) error {
+	g.app.mutex.Lock()
+	defer g.app.mutex.Unlock()
+
+	if g.app.consumersStarted {
+		return ErrConsumersStarted
+	}
+
+	g.middlewares = append(g.middlewares, middlewares...)
+	return nil
+}
+
+// AddConsumer adds a consumer of the queue named prefix + name
+func (g *Group) AddConsumer(name string, handlers ...Handler) {
+	g.app.addConsumer(g.prefix+name, consumer{group: g, handlers: handlers})
+}
+
+// AddConsumerWithOptions adds a consumer of the queue named prefix + name, see App.AddConsumerWithOptions
+func (g *Group) AddConsumerWithOptions(name string, options ConsumerOptions, handlers ...Handler) {
+	g.app.addConsumer(g.prefix+name, consumer{group: g, options: options, handlers: handlers})
+}
+
+// chain returns the middlewares of the group and of its parents, the outermost first
+func (g *Group) chain() []Handler {
+	if g == nil {
+		return nil
+	}
+
+	return append(g.parent.chain(), g.middlewares...)
+}
@@ -0,0 +1,76 @@
+package volta
+
+import (
+	"errors"
+	"testing"
+)
+
+func recordingHandler(calls *[]string, name string) Handler {
+	return func(ctx *Ctx) error {
+		*calls = append(*calls, name)
+		return ctx.Next()
+	}
+}
+
+func TestApp_Group(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	var calls []string
+	app.Use(recordingHandler(&calls, "global"))
+
+	billing := app.Group("billing.", recordingHandler(&calls, "billing"))
+	invoices := billing.Group("invoices.", recordingHandler(&calls, "invoices"))
+	invoices.Use(recordingHandler(&calls, "invoices use"))
+
+	invoices.AddConsumerWithOptions("created", ConsumerOptions{
+		Middlewares: []Handler{recordingHandler(&calls, "consumer")},
+	}, recordingHandler(&calls, "handler"))
+	billing.AddConsumer("refunds", recordingHandler(&calls, "refunds"))
+
+	// Added after the consumer, still applied
+	app.Use(recordingHandler(&calls, "global late"))
+
+	c, ok := app.consumers["billing.invoices.created"]
+	if !ok {
+		t.Fatalf("consumers are %v, expected billing.invoices.created", app.consumers)
+	}
+	if _, ok := app.consumers["billing.refunds"]; !ok {
+		t.Fatalf("consumers are %v, expected billing.refunds", app.consumers)
+	}
+
+	chain := app.chain(c.consumer)
+	if err := chain[0](&Ctx{handlers: chain}); err != nil {
+		t.Fatalf("chain error = %v", err)
+	}
+
+	expected := []string{"global", "global late", "billing", "invoices", "invoices use", "consumer", "handler"}
+	if len(calls) != len(expected) {
+		t.Fatalf("calls are %v, expected %v", calls, expected)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("calls are %v, expected %v", calls, expected)
+		}
+	}
+}
+
+func TestApp_Use_afterStart(t *testing.T) {
+	app := New(Config{DisableLogging: true, Transport: NewMemoryTransport()})
+	group := app.Group("billing.")
+
+	if err := app.connect(); err != nil {
+		t.Fatalf("App.connect() error = %v", err)
+	}
+	if err := app.initConsumers(); err != nil {
+		t.Fatalf("App.initConsumers() error = %v", err)
+	}
+
+	if err := app.Use(func(ctx *Ctx) error { return ctx.Next() }); !errors.Is(err, ErrConsumersStarted) {
+		t.Errorf("App.Use() error = %v, expected %v", err, ErrConsumersStarted)
+	}
+	if err := group.Use(func(ctx *Ctx) error { return ctx.Next() }); !errors.Is(err, ErrConsumersStarted) {
+		t.Errorf("Group.Use() error = %v, expected %v", err, ErrConsumersStarted)
+	}
+
+	app.Close()
+}
@@ -0,0 +1,163 @@
+package volta
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Health statuses
+const (
+	HealthOk       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// Consumer statuses
+const (
+	ConsumerActive    = "active"
+	ConsumerPaused    = "paused"
+	ConsumerCancelled = "cancelled"
+	ConsumerStopped   = "stopped"
+)
+
+// Health is a snapshot of the state of the application
+type Health struct {
+	// Status is HealthOk when connected, declared and all consumers are active or paused,
+	// HealthDegraded when connected otherwise and HealthDown when disconnected
+	Status           string           `json:"status"`
+	Connected        bool             `json:"connected"`
+	TopologyDeclared bool             `json:"topology_declared"`
+	Consumers        []ConsumerHealth `json:"consumers"`
+	Pool             PoolHealth       `json:"pool"`
+}
+
+// ConsumerHealth is the state of one consumer
+type ConsumerHealth struct {
+	Queue        string     `json:"queue"`
+	ConsumerTag  string     `json:"consumer_tag"`
+	Status       string     `json:"status"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+}
+
+// PoolHealth is the usage of the publishing channel pool
+type PoolHealth struct {
+	InUse      int     `json:"in_use"`
+	Size       int     `json:"size"`
+	Saturation float64 `json:"saturation"`
+}
+
+// consumerState tracks a running consumer for the health report
+type consumerState struct {
+	queue        string
+	consumerTag  string
+	status       atomic.Value
+	lastDelivery atomic.Int64
+}
+
+func (s *consumerState) delivered() {
+	s.lastDelivery.Store(time.Now().UnixNano())
+}
+
+// trackConsumer starts tracking a subscription of owner, a consumer handle or a batch consumer,
+// replacing the previous subscription of the same owner, e.g. after a reconnect
+func (a *App) trackConsumer(owner interface{}, queue, consumerTag string) *consumerState {
+	state := &consumerState{queue: queue, consumerTag: consumerTag}
+	state.status.Store(ConsumerActive)
+
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+
+	if a.consumerStates == nil {
+		a.consumerStates = make(map[interface{}]*consumerState)
+	}
+	a.consumerStates[owner] = state
+
+	return state
+}
+
+// untrackConsumer stops tracking the consumer of owner once it is removed
+func (a *App) untrackConsumer(owner interface{}) {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+
+	delete(a.consumerStates, owner)
+}
+
+// Health reports the state of the connection, the topology, the consumers and the publishing pool
+func (a *App) Health() Health {
+	health := Health{
+		Connected:        a.isConnected.Load(),
+		TopologyDeclared: a.isDeclared.Load(),
+		Consumers:        []ConsumerHealth{},
+	}
+
+	a.stateMutex.Lock()
+	for _, state := range a.consumerStates {
+		consumer := ConsumerHealth{
+			Queue:       state.queue,
+			ConsumerTag: state.consumerTag,
+			Status:      state.status.Load().(string),
+		}
+		if last := state.lastDelivery.Load(); last != 0 {
+			at := time.Unix(0, last)
+			consumer.LastDelivery = &at
+		}
+		health.Consumers = append(health.Consumers, consumer)
+	}
+	a.stateMutex.Unlock()
+
+	sort.Slice(health.Consumers, func(i, j int) bool {
+		if health.Consumers[i].Queue != health.Consumers[j].Queue {
+			return health.Consumers[i].Queue < health.Consumers[j].Queue
+		}
+		return health.Consumers[i].ConsumerTag < health.Consumers[j].ConsumerTag
+	})
+
+	inUse, size := a.pool.stats()
+	health.Pool = PoolHealth{InUse: inUse, Size: size}
+	if size > 0 {
+		health.Pool.Saturation = float64(inUse) / float64(size)
+	}
+
+	switch {
+	case !health.Connected:
+		health.Status = HealthDown
+	case !health.TopologyDeclared:
+		health.Status = HealthDegraded
+	default:
+		health.Status = HealthOk
+		for _, consumer := range health.Consumers {
+			if consumer.Status != ConsumerActive && consumer.Status != ConsumerPaused {
+				health.Status = HealthDegraded
+			}
+		}
+	}
+
+	return health
+}
+
+// HealthHandler returns an HTTP handler writing the Health as JSON.
+// It answers 200 when the status is ok and 503 otherwise, suitable for a readiness probe.
+// With the query parameter probe=live it answers 200 until the application is closed, suitable for a liveness probe.
+func (a *App) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := a.Health()
+
+		code := http.StatusOK
+		if r.URL.Query().Get("probe") == "live" {
+			if a.isClosed.Load() {
+				code = http.StatusServiceUnavailable
+			}
+		} else if health.Status != HealthOk {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(health)
+	})
+}
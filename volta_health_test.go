@@ -0,0 +1,126 @@
+package volta
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApp_Health(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	if health := app.Health(); health.Status != HealthDown {
+		t.Fatalf("status before connecting is %s", health.Status)
+	}
+
+	app.isConnected.Store(true)
+	if health := app.Health(); health.Status != HealthDegraded {
+		t.Fatalf("status before declaring is %s", health.Status)
+	}
+
+	app.isDeclared.Store(true)
+	orders := app.trackConsumer(1, "orders", "tag-1")
+	app.trackConsumer(2, "billing", "tag-2")
+	orders.delivered()
+
+	health := app.Health()
+	if health.Status != HealthOk {
+		t.Fatalf("status is %s", health.Status)
+	}
+	if len(health.Consumers) != 2 || health.Consumers[0].Queue != "billing" {
+		t.Fatalf("consumers are %+v", health.Consumers)
+	}
+	if health.Consumers[0].LastDelivery != nil || health.Consumers[1].LastDelivery == nil {
+		t.Fatal("last delivery is not reported")
+	}
+	if health.Pool.Size != app.config.PoolSize {
+		t.Fatalf("pool size is %d", health.Pool.Size)
+	}
+
+	orders.status.Store(ConsumerCancelled)
+	if health := app.Health(); health.Status != HealthDegraded {
+		t.Fatalf("status with a cancelled consumer is %s", health.Status)
+	}
+}
+
+func TestApp_Health_sameQueue(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := New(Config{Transport: transport, DisableLogging: true})
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "orders", Exchange: "test", RoutingKey: "orders"})
+	app.AddConsumer("orders", func(ctx *Ctx) error { return ctx.Ack(false) })
+	app.AddBatchConsumer("orders", func(batch *BatchCtx) error { return nil })
+
+	reconnected := make(chan struct{})
+	app.OnReconnect(func(int) { close(reconnected) })
+
+	go app.Listen()
+	t.Cleanup(func() { app.Close() })
+	waitHealthy(t, app)
+
+	// TEST: a batch consumer and a consumer of the same queue are reported separately
+	if consumers := app.Health().Consumers; len(consumers) != 2 {
+		t.Fatalf("consumers are %+v, expected 2", consumers)
+	}
+
+	// TEST: reconnecting replaces the subscriptions instead of adding to them
+	transport.CloseConnections()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("application did not reconnect")
+	}
+	waitHealthy(t, app)
+	if consumers := app.Health().Consumers; len(consumers) != 2 {
+		t.Fatalf("consumers after reconnecting are %+v, expected 2", consumers)
+	}
+
+	// TEST: removing the consumer keeps the batch consumer
+	if err := app.Consumer("orders").Remove(); err != nil {
+		t.Fatalf("ConsumerHandle.Remove() error = %v", err)
+	}
+	if consumers := app.Health().Consumers; len(consumers) != 1 || consumers[0].Status != ConsumerActive {
+		t.Fatalf("consumers after removing one are %+v, expected the active batch consumer", consumers)
+	}
+}
+
+func TestApp_HealthHandler(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	handler := app.HealthHandler()
+
+	tests := []struct {
+		name      string
+		target    string
+		connected bool
+		closed    bool
+		code      int
+	}{
+		{"ready", "/health", true, false, http.StatusOK},
+		{"not ready", "/health", false, false, http.StatusServiceUnavailable},
+		{"live while disconnected", "/health?probe=live", false, false, http.StatusOK},
+		{"closed", "/health?probe=live", false, true, http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		app.isConnected.Store(test.connected)
+		app.isDeclared.Store(test.connected)
+		app.isClosed.Store(test.closed)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+		if recorder.Code != test.code {
+			t.Errorf("%s: code is %d, expected %d", test.name, recorder.Code, test.code)
+		}
+
+		var health Health
+		if err := json.Unmarshal(recorder.Body.Bytes(), &health); err != nil {
+			t.Errorf("%s: body is not JSON: %s", test.name, recorder.Body.String())
+		}
+		if health.Connected != test.connected {
+			t.Errorf("%s: connected is %v", test.name, health.Connected)
+		}
+	}
+}
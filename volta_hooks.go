@@ -0,0 +1,200 @@
+package volta
+
+import (
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// OnConnectHandler is called when the connection to RabbitMQ is established
+type OnConnectHandler func()
+
+// OnDisconnectHandler is called when the connection to RabbitMQ is lost
+type OnDisconnectHandler func()
+
+// OnReconnectHandler is called when the connection is established again after it was lost,
+// attempt counts the successful connections so far
+type OnReconnectHandler func(attempt int)
+
+// OnTopologyDeclaredHandler is called when all exchanges and queues are declared
+type OnTopologyDeclaredHandler func()
+
+// OnConsumerStartedHandler is called when a consumer starts receiving messages of queue
+type OnConsumerStartedHandler func(queue, consumerTag string)
+
+// OnConsumerCancelledHandler is called when the broker cancels a consumer, e.g. because its queue was deleted,
+// or closes the channel of a consumer
+type OnConsumerCancelledHandler func(queue, consumerTag string)
+
+// OnConsumerResubscribedHandler is called when a cancelled consumer receives messages again,
+// attempt counts the tries it took
+type OnConsumerResubscribedHandler func(queue, consumerTag string, attempt int)
+
+// OnPublishReturnedHandler is called for a mandatory message that could not be routed
+type OnPublishReturnedHandler func(amqp091.Return)
+
+// OnShutdownHandler is called when the application is closed
+type OnShutdownHandler func()
+
+// hooks holds the registered lifecycle handlers, a panicking handler is recovered and logged
+type hooks struct {
+	mutex sync.RWMutex
+
+	onConnect              []OnConnectHandler
+	onDisconnect           []OnDisconnectHandler
+	onReconnect            []OnReconnectHandler
+	onTopologyDeclared     []OnTopologyDeclaredHandler
+	onConsumerStarted      []OnConsumerStartedHandler
+	onConsumerCancelled    []OnConsumerCancelledHandler
+	onConsumerResubscribed []OnConsumerResubscribedHandler
+	onPublishReturned      []OnPublishReturnedHandler
+	onShutdown             []OnShutdownHandler
+}
+
+// OnConnect registers a handler called when the connection to RabbitMQ is established
+func (a *App) OnConnect(handler OnConnectHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onConnect = append(a.hooks.onConnect, handler)
+}
+
+// OnDisconnect registers a handler called when the connection to RabbitMQ is lost
+func (a *App) OnDisconnect(handler OnDisconnectHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onDisconnect = append(a.hooks.onDisconnect, handler)
+}
+
+// OnReconnect registers a handler called when the connection is established again after it was lost
+func (a *App) OnReconnect(handler OnReconnectHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onReconnect = append(a.hooks.onReconnect, handler)
+}
+
+// OnTopologyDeclared registers a handler called when all exchanges and queues are declared
+func (a *App) OnTopologyDeclared(handler OnTopologyDeclaredHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onTopologyDeclared = append(a.hooks.onTopologyDeclared, handler)
+}
+
+// OnConsumerStarted registers a handler called when a consumer starts receiving messages
+func (a *App) OnConsumerStarted(handler OnConsumerStartedHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onConsumerStarted = append(a.hooks.onConsumerStarted, handler)
+}
+
+// OnConsumerCancelled registers a handler called when the broker cancels a consumer or closes its channel
+func (a *App) OnConsumerCancelled(handler OnConsumerCancelledHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onConsumerCancelled = append(a.hooks.onConsumerCancelled, handler)
+}
+
+// OnConsumerResubscribed registers a handler called when a cancelled consumer receives messages again
+func (a *App) OnConsumerResubscribed(handler OnConsumerResubscribedHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onConsumerResubscribed = append(a.hooks.onConsumerResubscribed, handler)
+}
+
+// OnPublishReturned registers a handler called for a mandatory message that could not be routed.
+// Only Batch.Publish sends mandatory messages, Publish, PublishWithContext and Reply never trigger it.
+func (a *App) OnPublishReturned(handler OnPublishReturnedHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onPublishReturned = append(a.hooks.onPublishReturned, handler)
+}
+
+// OnShutdown registers a handler called when the application is closed
+func (a *App) OnShutdown(handler OnShutdownHandler) {
+	a.hooks.mutex.Lock()
+	defer a.hooks.mutex.Unlock()
+
+	a.hooks.onShutdown = append(a.hooks.onShutdown, handler)
+}
+
+// registered returns the handlers registered so far. They are called after the lock is released,
+// so a handler may register others; appending never changes the handlers within the returned length.
+func registered[T any](h *hooks, handlers *[]T) []T {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return *handlers
+}
+
+// runHook calls a lifecycle handler, recovering and logging a panic so it cannot take down the application
+func (a *App) runHook(name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.config.Logger.Error("Lifecycle hook panicked", "hook", name, "panic", r)
+		}
+	}()
+
+	hook()
+}
+
+// connected runs the hooks of the given successful connection, counted from 1
+func (a *App) connected(connections int) {
+	for _, handler := range registered(&a.hooks, &a.hooks.onConnect) {
+		a.runHook("OnConnect", handler)
+	}
+
+	if connections > 1 {
+		for _, handler := range registered(&a.hooks, &a.hooks.onReconnect) {
+			a.runHook("OnReconnect", func() { handler(connections - 1) })
+		}
+	}
+}
+
+func (a *App) disconnected() {
+	for _, handler := range registered(&a.hooks, &a.hooks.onDisconnect) {
+		a.runHook("OnDisconnect", handler)
+	}
+}
+
+func (a *App) topologyDeclared() {
+	for _, handler := range registered(&a.hooks, &a.hooks.onTopologyDeclared) {
+		a.runHook("OnTopologyDeclared", handler)
+	}
+}
+
+func (a *App) consumerStarted(queue, consumerTag string) {
+	for _, handler := range registered(&a.hooks, &a.hooks.onConsumerStarted) {
+		a.runHook("OnConsumerStarted", func() { handler(queue, consumerTag) })
+	}
+}
+
+func (a *App) consumerCancelled(queue, consumerTag string) {
+	for _, handler := range registered(&a.hooks, &a.hooks.onConsumerCancelled) {
+		a.runHook("OnConsumerCancelled", func() { handler(queue, consumerTag) })
+	}
+}
+
+func (a *App) consumerResubscribed(queue, consumerTag string, attempt int) {
+	for _, handler := range registered(&a.hooks, &a.hooks.onConsumerResubscribed) {
+		a.runHook("OnConsumerResubscribed", func() { handler(queue, consumerTag, attempt) })
+	}
+}
+
+func (a *App) publishReturned(r amqp091.Return) {
+	for _, handler := range registered(&a.hooks, &a.hooks.onPublishReturned) {
+		a.runHook("OnPublishReturned", func() { handler(r) })
+	}
+}
+
+func (a *App) shutdown() {
+	for _, handler := range registered(&a.hooks, &a.hooks.onShutdown) {
+		a.runHook("OnShutdown", handler)
+	}
+}
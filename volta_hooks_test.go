@@ -0,0 +1,116 @@
+package volta
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestApp_hooks(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := New(Config{Transport: transport, DisableLogging: true, ResubscribeBackoff: 10 * time.Millisecond})
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "orders", Exchange: "test", RoutingKey: "orders"})
+	app.AddConsumer("orders", func(ctx *Ctx) error { return ctx.Ack(false) })
+
+	events := make(chan string, 64)
+	app.OnConnect(func() { events <- "connect" })
+	app.OnReconnect(func(attempt int) { events <- fmt.Sprint("reconnect ", attempt) })
+	app.OnDisconnect(func() { events <- "disconnect" })
+	app.OnTopologyDeclared(func() { events <- "topology" })
+	app.OnConsumerStarted(func(queue, consumerTag string) { events <- "started " + queue })
+	app.OnConsumerCancelled(func(queue, consumerTag string) { events <- "cancelled " + queue })
+	app.OnPublishReturned(func(r amqp091.Return) { events <- "returned " + r.RoutingKey })
+	app.OnShutdown(func() { events <- "shutdown" })
+
+	// expect waits for the events in order, other events may happen in between
+	expect := func(expected ...string) {
+		t.Helper()
+
+		for _, event := range expected {
+			timeout := time.After(5 * time.Second)
+			for received := ""; received != event; {
+				select {
+				case received = <-events:
+				case <-timeout:
+					t.Fatalf("Hook for %q was not called", event)
+				}
+			}
+		}
+	}
+
+	go app.Listen()
+	t.Cleanup(func() { app.Close() })
+	expect("connect", "topology", "started orders")
+
+	// TEST: a mandatory message without a queue is returned
+	_, err := app.NewBatch().AddMessage(BatchMessage{
+		Exchange:   "test",
+		RoutingKey: "unroutable",
+		Mandatory:  true,
+		Publishing: amqp091.Publishing{Body: []byte("test")},
+	}).Publish(context.Background())
+	if err != nil {
+		t.Fatalf("Batch.Publish() error = %v", err)
+	}
+	expect("returned unroutable")
+
+	// TEST: losing the connection reconnects and restarts the consumers
+	waitHealthy(t, app)
+	transport.CloseConnections()
+	expect("disconnect", "connect", "reconnect 1", "topology", "started orders")
+
+	// TEST: deleting the queue cancels its consumer
+	waitHealthy(t, app)
+	if _, err := openChannel(t, transport).QueueDelete("orders", false, false, false); err != nil {
+		t.Fatalf("Channel.QueueDelete() error = %v", err)
+	}
+	expect("cancelled orders", "started orders")
+
+	app.Close()
+	expect("shutdown")
+}
+
+func TestApp_hookPanic(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	called := false
+	app.OnTopologyDeclared(func() { panic("broken hook") })
+	app.OnTopologyDeclared(func() { called = true })
+
+	app.topologyDeclared()
+
+	if !called {
+		t.Fatal("a panicking hook prevented the next one from running")
+	}
+}
+
+func TestApp_hookRegistersHook(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	// TEST: a hook may register hooks, they run from the next event on
+	calls := 0
+	app.OnConnect(func() {
+		app.OnConnect(func() { calls++ })
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.connected(1)
+		app.connected(2)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("registering a hook from a hook deadlocked")
+	}
+
+	if calls != 1 {
+		t.Errorf("registered hook was called %d times, expected 1", calls)
+	}
+}
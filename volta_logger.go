@@ -0,0 +1,34 @@
+package volta
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// Logger receives the lifecycle and error events of the application as structured key-value pairs.
+// *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// defaultLogger writes text entries to stdout
+func defaultLogger() Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+}
+
+// discardHandler is a slog.Handler dropping every entry, used when logging is disabled
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Logger returns the logger of the application, e.g. to log from handlers with the same output
+func (a *App) Logger() Logger {
+	return a.config.Logger
+}
@@ -9,15 +9,9 @@ import (
 	"github.com/rabbitmq/amqp091-go"
 )
 
+// AddConsumer adds a consumer of the queue with the given name, handlers run in order after the middlewares
 func (a *App) AddConsumer(routingKey string, handlers ...Handler) {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
-	if a.handlers == nil {
-		a.handlers = make(map[string][]Handler)
-	}
-
-	a.handlers[routingKey] = handlers
+	a.addConsumer(routingKey, consumer{handlers: handlers})
 }
 
 // JSONConsumer is a helper function that creates a handler that will unmarshal the request body to the given type.
@@ -25,7 +19,7 @@ func JSONConsumer[Data any](callback func(ctx *Ctx, body Data) error) Handler {
 	return func(ctx *Ctx) error {
 		var body Data
 		if err := ctx.BindJSON(&body); err != nil {
-			return ctx.App.onBindError(ctx, err)
+			return ctx.App.bindError(ctx, err)
 		}
 		return callback(ctx, body)
 	}
@@ -36,64 +30,36 @@ func XMLConsumer[Data any](callback func(ctx *Ctx, body Data) error) Handler {
 	return func(ctx *Ctx) error {
 		var body Data
 		if err := ctx.BindXML(&body); err != nil {
-			return ctx.App.onBindError(ctx, err)
+			return ctx.App.bindError(ctx, err)
 		}
 		return callback(ctx, body)
 	}
 }
 
-// Consume consumes messages from the queue with the given routing key.
-// Handlers are the functions that will be executed when a message is received.
-// Handlers are executed in the order they are passed.
-func (a *App) consume(routingKey string, handlers ...Handler) error {
-	connection, err := amqp091.Dial(a.config.RabbitMQ)
-	if err != nil {
-		return err
-	}
-
-	channel, err := connection.Channel()
-	if err != nil {
-		return err
-	}
-
-	messages, err := channel.Consume(routingKey, randomString(12), false, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-
-	handlersWithMiddlewares := make([]Handler, 0)
-	handlersWithMiddlewares = append(handlersWithMiddlewares, a.middlewares...)
-	handlersWithMiddlewares = append(handlersWithMiddlewares, handlers...)
-
-	go func() {
-		for message := range messages {
-			go func(msg amqp091.Delivery, h []Handler, channel *amqp091.Channel) {
-				h[0](&Ctx{App: a, Delivery: msg, handlers: h, Channel: channel})
-			}(message, handlersWithMiddlewares, channel)
-		}
-
-		defer connection.Close()
-		defer channel.Close()
-	}()
-
-	return nil
-}
-
 // ConsumeNative consumes messages from the specified routing key using the AMQP 0.9.1 protocol.
 // It returns a channel of message deliveries and an error if any occurred.
-func (a *App) ConsumeNative(routingKey string) (<-chan amqp091.Delivery, error) {
-	connection, err := amqp091.Dial(a.config.RabbitMQ)
+// Options set the tag, exclusivity, priority and arguments of the consumer, their middlewares are ignored.
+func (a *App) ConsumeNative(routingKey string, options ...ConsumerOptions) (<-chan amqp091.Delivery, error) {
+	var opts ConsumerOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	connection, err := a.dial()
 	if err != nil {
 		return nil, err
 	}
+	a.track(connection)
 
 	channel, err := connection.Channel()
 	if err != nil {
+		connection.Close()
 		return nil, err
 	}
 
-	messages, err := channel.Consume(routingKey, randomString(12), false, false, false, false, nil)
+	messages, err := channel.Consume(routingKey, a.consumerTag(opts.Tag, routingKey), false, opts.Exclusive, false, false, opts.arguments())
 	if err != nil {
+		connection.Close()
 		return nil, err
 	}
 
@@ -114,10 +80,27 @@ func randomString(length int) string {
 // No wait for response.
 // Returns error if something went wrong.
 func (a *App) Publish(name, exchange string, body []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.config.Timeout)*time.Second)
+	return a.PublishWithContext(context.Background(), name, exchange, body)
+}
+
+// PublishWithContext is like Publish, ctx carries the trace context of the message.
+func (a *App) PublishWithContext(ctx context.Context, name, exchange string, body []byte) (err error) {
+	msg := amqp091.Publishing{
+		ContentType: "text/plain",
+		Body:        body,
+	}
+
+	ctx, end := a.startPublish(ctx, exchange, name, &msg)
+	start := time.Now()
+	defer func() {
+		a.config.Metrics.MessagePublished(exchange, time.Since(start), err)
+		end(err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout())
 	defer cancel()
 
-	connection, err := amqp091.Dial(a.config.RabbitMQ)
+	connection, err := a.dial()
 	if err != nil {
 		return err
 	}
@@ -135,20 +118,38 @@ func (a *App) Publish(name, exchange string, body []byte) error {
 		name,
 		false,
 		false,
-		amqp091.Publishing{
-			ContentType: "text/plain",
-			Body:        body,
-		})
+		msg)
 }
 
 // Request publishes a message to the exchange with the given name, body is the message body
 // and exchange is the exchange name.
 // Waits for response.
 func (a *App) Request(name, exchange string, body []byte) (data []byte, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.config.Timeout)*time.Second)
+	return a.RequestWithContext(context.Background(), name, exchange, body)
+}
+
+// RequestWithContext is like Request, ctx carries the trace context of the request.
+func (a *App) RequestWithContext(ctx context.Context, name, exchange string, body []byte) (data []byte, err error) {
+	corrId := randomString(32)
+
+	msg := amqp091.Publishing{
+		ContentType:   "text/plain",
+		CorrelationId: corrId,
+		Body:          body,
+	}
+
+	var reply *amqp091.Delivery
+	ctx, end := a.startRequest(ctx, exchange, name, &msg)
+	start := time.Now()
+	defer func() {
+		a.config.Metrics.RequestFinished(exchange, time.Since(start), err)
+		end(reply, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout())
 	defer cancel()
 
-	connection, err := amqp091.Dial(a.config.RabbitMQ)
+	connection, err := a.dial()
 	if err != nil {
 		return nil, err
 	}
@@ -172,7 +173,7 @@ func (a *App) Request(name, exchange string, body []byte) (data []byte, err erro
 		return nil, err
 	}
 
-	corrId := randomString(32)
+	msg.ReplyTo = queue.Name
 
 	err = channel.PublishWithContext(
 		ctx,
@@ -180,12 +181,7 @@ func (a *App) Request(name, exchange string, body []byte) (data []byte, err erro
 		name,
 		false,
 		false,
-		amqp091.Publishing{
-			ContentType:   "text/plain",
-			CorrelationId: corrId,
-			ReplyTo:       queue.Name,
-			Body:          body,
-		})
+		msg)
 	if err != nil {
 		return nil, err
 	}
@@ -203,13 +199,21 @@ func (a *App) Request(name, exchange string, body []byte) (data []byte, err erro
 		return nil, err
 	}
 
-	for message := range messages {
-		if message.CorrelationId == corrId {
-			return message.Body, nil
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return nil, amqp091.ErrClosed
+			}
+
+			if message.CorrelationId == corrId {
+				reply = &message
+				return message.Body, nil
+			}
+		case <-ctx.Done():
+			return nil, ErrRequestTimeout
 		}
 	}
-
-	return nil, nil
 }
 
 // PublishJSON publishes a message to the exchange with the given name, body will be marshaled to JSON
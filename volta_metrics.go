@@ -0,0 +1,61 @@
+package volta
+
+import "time"
+
+// Settlement is how a consumed message was settled
+type Settlement string
+
+const (
+	SettlementAck    Settlement = "ack"
+	SettlementNack   Settlement = "nack"
+	SettlementReject Settlement = "reject"
+)
+
+// Metrics collects measurements of consumers, publishers and the connection.
+// See github.com/volta-dev/volta/prometheus for a Prometheus implementation.
+type Metrics interface {
+	// MessageConsumed is called when a message of queue is handed to its handlers
+	MessageConsumed(queue string)
+
+	// MessageSettled is called when a message of queue is acknowledged, negatively acknowledged or rejected
+	MessageSettled(queue string, settlement Settlement)
+
+	// HandlerStarted is called before the handlers of queue run
+	HandlerStarted(queue string)
+
+	// HandlerFinished is called after the handlers of queue returned
+	HandlerFinished(queue string, duration time.Duration, err error)
+
+	// MessagePublished is called after a message was published to exchange
+	MessagePublished(exchange string, duration time.Duration, err error)
+
+	// RequestFinished is called with the round trip of an RPC request, err is ErrRequestTimeout when no reply arrived in time
+	RequestFinished(exchange string, duration time.Duration, err error)
+
+	// ReconnectAttempt is called before every retry to connect to RabbitMQ
+	ReconnectAttempt()
+
+	// ConnectionStateChanged is called when the connection to RabbitMQ is established or lost
+	ConnectionStateChanged(connected bool)
+}
+
+// noopMetrics is the default Metrics, it discards everything
+type noopMetrics struct{}
+
+func (noopMetrics) MessageConsumed(string)                        {}
+func (noopMetrics) MessageSettled(string, Settlement)             {}
+func (noopMetrics) HandlerStarted(string)                         {}
+func (noopMetrics) HandlerFinished(string, time.Duration, error)  {}
+func (noopMetrics) MessagePublished(string, time.Duration, error) {}
+func (noopMetrics) RequestFinished(string, time.Duration, error)  {}
+func (noopMetrics) ReconnectAttempt()                             {}
+func (noopMetrics) ConnectionStateChanged(bool)                   {}
+
+// metrics returns the configured Metrics, also for a Ctx created outside of a consumer
+func (ctx *Ctx) metrics() Metrics {
+	if ctx.App == nil || ctx.App.config.Metrics == nil {
+		return noopMetrics{}
+	}
+
+	return ctx.App.config.Metrics
+}
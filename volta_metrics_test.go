@@ -0,0 +1,64 @@
+package volta
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	noopMetrics
+
+	mutex   sync.Mutex
+	settled map[Settlement]int
+	handled int
+}
+
+func (m *recordingMetrics) MessageSettled(queue string, settlement Settlement) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.settled[settlement]++
+}
+
+func (m *recordingMetrics) HandlerFinished(queue string, duration time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.handled++
+}
+
+func TestCtx_SettleMetrics(t *testing.T) {
+	metrics := &recordingMetrics{settled: map[Settlement]int{}}
+	app := New(Config{DisableLogging: true, Metrics: metrics})
+
+	batch, _ := newTestBatch(app, 3)
+	batch.Items[0].Ack(false)
+	batch.Items[0].Ack(false)
+	batch.Items[1].Nack(false, true)
+	batch.Items[2].Reject(false)
+
+	if metrics.settled[SettlementAck] != 1 || metrics.settled[SettlementNack] != 1 || metrics.settled[SettlementReject] != 1 {
+		t.Fatalf("unexpected settlements %v", metrics.settled)
+	}
+}
+
+func TestApp_handleBatchMetrics(t *testing.T) {
+	metrics := &recordingMetrics{settled: map[Settlement]int{}}
+	app := New(Config{DisableLogging: true, Metrics: metrics})
+
+	batch, _ := newTestBatch(app, 5)
+	app.handleBatch(batch, batchConsumer{handler: func(*BatchCtx) error { return nil }, options: DefaultBatchOptions})
+
+	if metrics.settled[SettlementAck] != 5 {
+		t.Fatalf("expected 5 acks, got %v", metrics.settled)
+	}
+	if metrics.handled != 1 {
+		t.Fatalf("expected 1 handled batch, got %d", metrics.handled)
+	}
+	for _, item := range batch.Items {
+		if !item.Settled() {
+			t.Fatal("item of an acknowledged batch is not settled")
+		}
+	}
+}
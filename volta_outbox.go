@@ -0,0 +1,275 @@
+package volta
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// OutboxMessage is a message waiting in the outbox to be published
+type OutboxMessage struct {
+	ID          string
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+
+	// Headers of the published message, a store must keep the types of their values
+	Headers amqp091.Table
+	Body    []byte
+
+	// Number of failed publish attempts
+	Attempts int
+
+	CreatedAt time.Time
+}
+
+// OutboxStore persists outbox messages next to the application data,
+// so a database change and the event describing it are committed atomically.
+type OutboxStore interface {
+	// Add stores a pending message as part of tx, the caller's transaction.
+	// The type of tx depends on the store, e.g. *sql.Tx for a database/sql store.
+	Add(ctx context.Context, tx interface{}, message OutboxMessage) error
+
+	// Claim returns up to limit pending messages that are due and hides them from other
+	// relays until lease has passed
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error)
+
+	// MarkSent marks the message as published
+	MarkSent(ctx context.Context, id string) error
+
+	// MarkFailed records a failed attempt and schedules the next one
+	MarkFailed(ctx context.Context, id string, retryAt time.Time, cause error) error
+}
+
+type OutboxOptions struct {
+	// Interval between two polls of the store when the outbox is empty
+	PollInterval time.Duration
+
+	// Maximum number of messages published per poll
+	BatchSize int
+
+	// Delay before the first retry, doubled after every failed attempt
+	RetryBackoff time.Duration
+
+	// Maximum delay between two attempts
+	MaxRetryBackoff time.Duration
+}
+
+var DefaultOutboxOptions = OutboxOptions{
+	PollInterval:    time.Second,
+	BatchSize:       100,
+	RetryBackoff:    time.Second,
+	MaxRetryBackoff: 5 * time.Minute,
+}
+
+type outboxRelay struct {
+	app     *App
+	store   OutboxStore
+	options OutboxOptions
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// UseOutbox registers the outbox store. The relay starts with Listen and publishes
+// the stored messages with confirms, retrying failed ones with exponential backoff.
+func (a *App) UseOutbox(store OutboxStore, options ...OutboxOptions) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.outbox = &outboxRelay{
+		app:     a,
+		store:   store,
+		options: outboxOptions(options...),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func outboxOptions(options ...OutboxOptions) OutboxOptions {
+	if len(options) < 1 {
+		return DefaultOutboxOptions
+	}
+
+	cfg := options[0]
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultOutboxOptions.PollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultOutboxOptions.BatchSize
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = DefaultOutboxOptions.RetryBackoff
+	}
+	if cfg.MaxRetryBackoff <= 0 {
+		cfg.MaxRetryBackoff = DefaultOutboxOptions.MaxRetryBackoff
+	}
+
+	return cfg
+}
+
+// PublishOutbox stores a message in the outbox as part of tx instead of publishing it directly.
+// It is published by the relay once tx is committed.
+func (a *App) PublishOutbox(ctx context.Context, tx interface{}, name, exchange string, body []byte) error {
+	return a.addOutbox(ctx, tx, OutboxMessage{
+		Exchange:    exchange,
+		RoutingKey:  name,
+		ContentType: "text/plain",
+		Body:        body,
+	})
+}
+
+// PublishOutboxJSON marshals body to JSON and stores it in the outbox as part of tx
+func (a *App) PublishOutboxJSON(ctx context.Context, tx interface{}, name, exchange string, body interface{}) error {
+	data, err := a.config.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return a.addOutbox(ctx, tx, OutboxMessage{
+		Exchange:    exchange,
+		RoutingKey:  name,
+		ContentType: "application/json",
+		Body:        data,
+	})
+}
+
+// PublishOutboxMessage stores a message with its own properties, e.g. headers, in the outbox as part of tx.
+// ID becomes the message_id of the published message, a random one is used when it is empty.
+func (a *App) PublishOutboxMessage(ctx context.Context, tx interface{}, message OutboxMessage) error {
+	message.Attempts = 0
+	return a.addOutbox(ctx, tx, message)
+}
+
+func (a *App) addOutbox(ctx context.Context, tx interface{}, message OutboxMessage) error {
+	if a.outbox == nil {
+		return errors.New("volta: No outbox store registered, call UseOutbox first")
+	}
+
+	if message.ID == "" {
+		message.ID = randomString(32)
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+
+	return a.outbox.store.Add(ctx, tx, message)
+}
+
+// start runs the relay in the background, calling it again is a no-op
+func (r *outboxRelay) start() {
+	r.startOnce.Do(func() {
+		go r.run()
+	})
+}
+
+// close stops the relay and waits for the current poll to finish
+func (r *outboxRelay) close() {
+	r.stopOnce.Do(func() { close(r.stop) })
+
+	// A relay that never started must not start anymore
+	r.startOnce.Do(func() { close(r.done) })
+	<-r.done
+}
+
+func (r *outboxRelay) run() {
+	defer close(r.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-r.stop
+		cancel()
+	}()
+
+	for {
+		published, err := r.relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.app.config.Logger.Error("Problem with relaying the outbox", "error", err)
+		}
+
+		// Keep draining without waiting while the outbox is full
+		if err == nil && published >= r.options.BatchSize {
+			continue
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(r.options.PollInterval):
+		}
+	}
+}
+
+// relay publishes one batch of due messages and returns how many were claimed
+func (r *outboxRelay) relay(ctx context.Context) (int, error) {
+	lease := r.app.timeout() + r.options.PollInterval
+
+	messages, err := r.store.Claim(ctx, r.options.BatchSize, lease)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	batch := r.app.NewBatch()
+	for _, message := range messages {
+		batch.AddMessage(BatchMessage{
+			Exchange:   message.Exchange,
+			RoutingKey: message.RoutingKey,
+			Publishing: amqp091.Publishing{
+				MessageId:   message.ID,
+				ContentType: message.ContentType,
+				Headers:     message.Headers,
+				Timestamp:   message.CreatedAt,
+				Body:        message.Body,
+			},
+		})
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, r.app.timeout())
+	defer cancel()
+
+	results, err := batch.Publish(publishCtx)
+	if err != nil {
+		// Nothing was published, the messages become due again when their lease expires
+		return 0, err
+	}
+
+	for i, result := range results {
+		message := messages[i]
+		if result.Ok() {
+			err = r.store.MarkSent(ctx, message.ID)
+		} else {
+			cause := result.Err
+			if cause == nil {
+				cause = errors.New("volta: Message " + result.Status.String())
+			}
+			err = r.store.MarkFailed(ctx, message.ID, time.Now().Add(r.backoff(message.Attempts)), cause)
+		}
+
+		if err != nil {
+			return len(messages), err
+		}
+	}
+
+	return len(messages), nil
+}
+
+// backoff returns the delay before the next attempt of a message that already failed attempts times
+func (r *outboxRelay) backoff(attempts int) time.Duration {
+	delay := r.options.RetryBackoff
+	for i := 0; i < attempts && delay < r.options.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.options.MaxRetryBackoff {
+		return r.options.MaxRetryBackoff
+	}
+
+	return delay
+}
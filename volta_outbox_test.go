@@ -0,0 +1,47 @@
+package volta
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestApp_PublishOutbox(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+
+	if err := app.PublishOutbox(context.Background(), nil, "test", "test", []byte("test")); err == nil {
+		t.Error("App.PublishOutbox() succeeded without an outbox store")
+	}
+}
+
+func TestOutboxRelay_backoff(t *testing.T) {
+	relay := &outboxRelay{options: outboxOptions(OutboxOptions{
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: 10 * time.Second,
+	})}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for attempts, delay := range expected {
+		if backoff := relay.backoff(attempts); backoff != delay {
+			t.Errorf("Backoff after %d attempts is %s, expected %s", attempts, backoff, delay)
+		}
+	}
+}
+
+func TestOutboxRelay_close(t *testing.T) {
+	app := New(Config{DisableLogging: true})
+	app.UseOutbox(nil)
+
+	done := make(chan struct{})
+	go func() {
+		app.outbox.close()
+		app.outbox.start()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Closing a relay that never started blocked")
+	}
+}
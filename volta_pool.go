@@ -0,0 +1,154 @@
+package volta
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Size of the confirmation and return buffers of a pooled channel,
+// it is also the number of unconfirmed messages a borrower keeps in flight
+const pooledChannelBuffer = 256
+
+// pooledChannel is a publishing channel in confirm mode
+type pooledChannel struct {
+	channel  Channel
+	confirms chan amqp091.Confirmation
+	returns  chan amqp091.Return
+}
+
+// channelPool lends confirm-mode channels of a dedicated publishing connection.
+// A channel is used by one borrower at a time, so confirmations always belong to the borrower.
+type channelPool struct {
+	app  *App
+	size int
+
+	mutex      sync.Mutex
+	connection Connection
+	idle       chan *pooledChannel
+	created    int
+}
+
+func newChannelPool(app *App, size int) *channelPool {
+	return &channelPool{app: app, size: size, idle: make(chan *pooledChannel, size)}
+}
+
+// get borrows a channel, waiting for one to be released when the pool is exhausted
+func (p *channelPool) get(ctx context.Context) (*pooledChannel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case channel := <-p.idle:
+		if !channel.channel.IsClosed() {
+			return channel, nil
+		}
+		p.discard(channel)
+	default:
+	}
+
+	p.mutex.Lock()
+	if p.created < p.size {
+		p.created++
+		p.mutex.Unlock()
+
+		channel, err := p.open()
+		if err != nil {
+			p.mutex.Lock()
+			p.created--
+			p.mutex.Unlock()
+			return nil, err
+		}
+
+		return channel, nil
+	}
+	p.mutex.Unlock()
+
+	select {
+	case channel := <-p.idle:
+		if channel.channel.IsClosed() {
+			p.discard(channel)
+			return p.get(ctx)
+		}
+		return channel, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// put releases a borrowed channel
+func (p *channelPool) put(channel *pooledChannel) {
+	if channel.channel.IsClosed() {
+		p.discard(channel)
+		return
+	}
+
+	p.idle <- channel
+}
+
+// discard drops a channel that can no longer be used, e.g. because its confirmations are out of sync
+func (p *channelPool) discard(channel *pooledChannel) {
+	channel.channel.Close()
+
+	p.mutex.Lock()
+	p.created--
+	p.mutex.Unlock()
+}
+
+// open creates a new confirm-mode channel, dialing the publishing connection if needed
+func (p *channelPool) open() (*pooledChannel, error) {
+	p.mutex.Lock()
+	if p.connection == nil || p.connection.IsClosed() {
+		connection, err := p.app.dial()
+		if err != nil {
+			p.mutex.Unlock()
+			return nil, err
+		}
+		p.connection = connection
+	}
+	connection := p.connection
+	p.mutex.Unlock()
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	return &pooledChannel{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp091.Confirmation, pooledChannelBuffer)),
+		returns:  channel.NotifyReturn(make(chan amqp091.Return, pooledChannelBuffer)),
+	}, nil
+}
+
+// stats returns the number of borrowed channels and the pool size
+func (p *channelPool) stats() (inUse, size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.created - len(p.idle), p.size
+}
+
+// close closes the publishing connection and all its channels
+func (p *channelPool) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for len(p.idle) > 0 {
+		<-p.idle
+		p.created--
+	}
+
+	if p.connection == nil || p.connection.IsClosed() {
+		return nil
+	}
+
+	return p.connection.Close()
+}
@@ -14,7 +14,7 @@ func (a *App) AddQueue(queue ...Queue) {
 }
 
 func (a *App) declareQueue(q Queue) error {
-	channel, err := a.baseConnection.Channel()
+	channel, err := a.connection().Channel()
 	if err != nil {
 		return err
 	}
@@ -29,7 +29,7 @@ func (a *App) declareQueue(q Queue) error {
 }
 
 func (a *App) PurgeQueue(name string, noWait bool) error {
-	channel, err := a.baseConnection.Channel()
+	channel, err := a.connection().Channel()
 	if err != nil {
 		return err
 	}
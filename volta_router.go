@@ -0,0 +1,228 @@
+package volta
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrRouteNotFound is returned by RouteNotFound when no route matches the routing key of a message
+var ErrRouteNotFound = errors.New("volta: No route matches the routing key")
+
+// Router dispatches the messages of one consumer to the route whose AMQP topic pattern matches their routing key.
+// Patterns are dot-separated words where * matches exactly one word, # matches zero or more words
+// and {name} matches one word available to the route handlers through Ctx.Param.
+//
+// When several patterns match, the most specific one wins: words are compared from left to right
+// and a literal word beats * or {name}, which beat the end of a shorter pattern, which beats #.
+// Routes equally specific are tried in the order they were added.
+type Router struct {
+	config RouterConfig
+
+	mutex  sync.RWMutex
+	routes []*route
+}
+
+type RouterConfig struct {
+	// NotFound handles the messages no route matches
+	NotFound Handler
+}
+
+var DefaultRouterConfig = RouterConfig{
+	NotFound: RouteNotFound,
+}
+
+// RouteNotFound rejects the message without requeueing, it is dropped or dead-lettered
+func RouteNotFound(ctx *Ctx) error {
+	if err := ctx.Reject(false); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ErrRouteNotFound, ctx.RoutingKey())
+}
+
+// NewRouter creates a router, its Handle method is the handler of the consumer
+func NewRouter(config ...RouterConfig) *Router {
+	cfg := DefaultRouterConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.NotFound == nil {
+		cfg.NotFound = DefaultRouterConfig.NotFound
+	}
+
+	return &Router{config: cfg}
+}
+
+// Kinds of pattern words, in order of precedence. wordEnd stands for the end of a shorter pattern
+const (
+	wordLiteral = iota
+	wordOne
+	wordEnd
+	wordMany
+)
+
+type routeWord struct {
+	kind  int
+	value string
+}
+
+type route struct {
+	words    []routeWord
+	params   []string
+	handlers []Handler
+}
+
+// Add adds a route, handlers run in order like the handlers of a consumer and the first ones act as route-level middlewares.
+// It panics if the pattern is invalid.
+func (r *Router) Add(pattern string, handlers ...Handler) *Router {
+	if len(handlers) < 1 {
+		panic(fmt.Sprintf("volta: Route %s has no handlers", pattern))
+	}
+
+	rt := &route{handlers: handlers}
+	for _, word := range strings.Split(pattern, ".") {
+		switch {
+		case word == "*":
+			rt.words = append(rt.words, routeWord{kind: wordOne})
+		case word == "#":
+			rt.words = append(rt.words, routeWord{kind: wordMany})
+		case strings.HasPrefix(word, "{") && strings.HasSuffix(word, "}"):
+			name := word[1 : len(word)-1]
+			if name == "" {
+				panic(fmt.Sprintf("volta: Route %s has an unnamed parameter", pattern))
+			}
+			for _, param := range rt.params {
+				if param == name {
+					panic(fmt.Sprintf("volta: Route %s has the parameter %s twice", pattern, name))
+				}
+			}
+
+			rt.words = append(rt.words, routeWord{kind: wordOne, value: name})
+			rt.params = append(rt.params, name)
+		default:
+			rt.words = append(rt.words, routeWord{kind: wordLiteral, value: word})
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.routes = append(r.routes, rt)
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].before(r.routes[j])
+	})
+
+	return r
+}
+
+// before reports whether the route takes precedence over the other one
+func (rt *route) before(other *route) bool {
+	for i := 0; i < len(rt.words) || i < len(other.words); i++ {
+		if kind, otherKind := rt.kind(i), other.kind(i); kind != otherKind {
+			return kind < otherKind
+		}
+	}
+
+	return false
+}
+
+func (rt *route) kind(i int) int {
+	if i >= len(rt.words) {
+		return wordEnd
+	}
+
+	return rt.words[i].kind
+}
+
+// match reports whether the routing key matches the route and returns the values of its parameters
+func (rt *route) match(key []string) ([]string, bool) {
+	values := make([]string, 0, len(rt.params))
+	if !matchWords(rt.words, key, &values) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+func matchWords(words []routeWord, key []string, values *[]string) bool {
+	if len(words) == 0 {
+		return len(key) == 0
+	}
+
+	word := words[0]
+	switch word.kind {
+	case wordMany:
+		for skip := 0; skip <= len(key); skip++ {
+			if matchWords(words[1:], key[skip:], values) {
+				return true
+			}
+		}
+		return false
+	case wordOne:
+		if len(key) == 0 {
+			return false
+		}
+
+		if word.value != "" {
+			*values = append(*values, key[0])
+		}
+		if matchWords(words[1:], key[1:], values) {
+			return true
+		}
+		if word.value != "" {
+			*values = (*values)[:len(*values)-1]
+		}
+		return false
+	default:
+		return len(key) > 0 && key[0] == word.value && matchWords(words[1:], key[1:], values)
+	}
+}
+
+// Handle dispatches the message to the most specific matching route, or to the not-found handler.
+// The route handlers replace the rest of the chain, so the router is the last handler of a consumer.
+func (r *Router) Handle(ctx *Ctx) error {
+	key := strings.Split(ctx.RoutingKey(), ".")
+
+	r.mutex.RLock()
+	var matched *route
+	var values []string
+	for _, rt := range r.routes {
+		if v, ok := rt.match(key); ok {
+			matched, values = rt, v
+			break
+		}
+	}
+	r.mutex.RUnlock()
+
+	if matched == nil {
+		return r.config.NotFound(ctx)
+	}
+
+	params := make(map[string]string, len(values))
+	for i, value := range values {
+		params[matched.params[i]] = value
+	}
+
+	return ctx.dispatch(params, matched.handlers)
+}
+
+// dispatch runs handlers as the rest of the chain with the given route parameters
+func (ctx *Ctx) dispatch(params map[string]string, handlers []Handler) error {
+	previous, cursor, previousParams := ctx.handlers, ctx.handlerCursor, ctx.params
+	defer func() {
+		ctx.handlers, ctx.handlerCursor, ctx.params = previous, cursor, previousParams
+	}()
+
+	ctx.params = params
+	ctx.handlers, ctx.handlerCursor = handlers, 0
+
+	return handlers[0](ctx)
+}
+
+// Param returns the value of a {name} word of the route pattern, empty if the route has no such parameter
+func (ctx *Ctx) Param(name string) string {
+	return ctx.params[name]
+}
@@ -0,0 +1,161 @@
+package volta
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func routed(router *Router, routingKey string) (*Ctx, *recordingAcknowledger, error) {
+	acknowledger := &recordingAcknowledger{acks: map[uint64]bool{}, nacks: map[uint64]bool{}}
+	ctx := NewCtx(nil, nil, "orders", amqp091.Delivery{
+		Acknowledger: acknowledger,
+		DeliveryTag:  1,
+		RoutingKey:   routingKey,
+	}, router.Handle)
+
+	return ctx, acknowledger, router.Handle(ctx)
+}
+
+func TestRouter_precedence(t *testing.T) {
+	router := NewRouter()
+	for _, pattern := range []string{"orders.#", "orders.*.created", "orders.eu.created", "orders", "#", "orders.#.cancelled"} {
+		pattern := pattern
+		router.Add(pattern, func(ctx *Ctx) error {
+			ctx.Locals("route", pattern)
+			return nil
+		})
+	}
+
+	tests := []struct {
+		routingKey string
+		expected   string
+	}{
+		{"orders.eu.created", "orders.eu.created"},
+		{"orders.us.created", "orders.*.created"},
+		{"orders.us.updated", "orders.#"},
+		{"orders.us.cancelled", "orders.#.cancelled"},
+		{"orders", "orders"},
+		{"users.created", "#"},
+	}
+
+	for _, test := range tests {
+		ctx, _, err := routed(router, test.routingKey)
+		if err != nil {
+			t.Errorf("%s: Handle() error = %v", test.routingKey, err)
+		}
+		if route := ctx.Locals("route"); route != test.expected {
+			t.Errorf("%s was routed to %v, expected %s", test.routingKey, route, test.expected)
+		}
+	}
+}
+
+func TestRouter_params(t *testing.T) {
+	router := NewRouter().Add("orders.{region}.#.{event}", func(ctx *Ctx) error {
+		if region := ctx.Param("region"); region != "eu" {
+			t.Errorf("region is %s, expected eu", region)
+		}
+		if event := ctx.Param("event"); event != "created" {
+			t.Errorf("event is %s, expected created", event)
+		}
+		if missing := ctx.Param("missing"); missing != "" {
+			t.Errorf("missing parameter is %s, expected empty", missing)
+		}
+		return nil
+	})
+
+	if _, _, err := routed(router, "orders.eu.shop.web.created"); err != nil {
+		t.Errorf("Handle() error = %v", err)
+	}
+}
+
+func TestRouter_nestedParams(t *testing.T) {
+	inner := NewRouter().Add("orders.*.{event}", func(ctx *Ctx) error {
+		if ctx.Param("event") != "created" || ctx.Param("region") != "" {
+			t.Errorf("params are %v, expected the ones of the inner route", ctx.params)
+		}
+		return nil
+	})
+
+	outer := NewRouter().Add("orders.{region}.*",
+		func(ctx *Ctx) error {
+			err := ctx.Next()
+
+			// TEST: the params of the outer route are restored after the inner router
+			if ctx.Param("region") != "eu" || ctx.Param("event") != "" {
+				t.Errorf("params are %v after the inner router, expected the ones of the outer route", ctx.params)
+			}
+			return err
+		},
+		inner.Handle,
+	)
+
+	ctx, _, err := routed(outer, "orders.eu.created")
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if ctx.params != nil {
+		t.Errorf("params are %v after routing, expected none", ctx.params)
+	}
+}
+
+func TestRouter_middlewares(t *testing.T) {
+	var calls []string
+	router := NewRouter().Add("orders.created",
+		func(ctx *Ctx) error {
+			calls = append(calls, "middleware")
+			return ctx.Next()
+		},
+		func(ctx *Ctx) error {
+			calls = append(calls, "handler")
+			return ctx.Ack(false)
+		},
+	)
+
+	_, acknowledger, err := routed(router, "orders.created")
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if len(calls) != 2 || calls[0] != "middleware" || calls[1] != "handler" {
+		t.Errorf("calls are %v, expected the middleware then the handler", calls)
+	}
+	if _, ok := acknowledger.acks[1]; !ok {
+		t.Error("message was not acknowledged")
+	}
+}
+
+func TestRouter_notFound(t *testing.T) {
+	router := NewRouter().Add("orders.created", func(ctx *Ctx) error { return nil })
+
+	ctx, acknowledger, err := routed(router, "users.created")
+	if !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("Handle() error = %v, expected %v", err, ErrRouteNotFound)
+	}
+	if ctx.Settlement() != SettlementReject {
+		t.Errorf("Settlement is %q, expected %q", ctx.Settlement(), SettlementReject)
+	}
+	if _, ok := acknowledger.nacks[1]; !ok {
+		t.Error("message was not rejected")
+	}
+
+	custom := NewRouter(RouterConfig{NotFound: func(ctx *Ctx) error { return ctx.Nack(false, true) }})
+	if _, _, err := routed(custom, "users.created"); err != nil {
+		t.Errorf("Handle() error = %v, expected the custom not-found handler", err)
+	}
+}
+
+func TestRouter_Add_invalid(t *testing.T) {
+	for _, pattern := range []string{"orders.{}", "orders.{id}.{id}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Add(%q) did not panic", pattern)
+				}
+			}()
+
+			NewRouter().Add(pattern, func(ctx *Ctx) error { return nil })
+		}()
+	}
+}
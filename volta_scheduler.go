@@ -0,0 +1,410 @@
+package volta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// ErrInvalidSchedule is returned by Schedule, ScheduleJSON and ScheduleFunc for a spec that cannot be parsed
+var ErrInvalidSchedule = errors.New("volta: Invalid schedule")
+
+// schedule returns the first time after t a job runs, zero when it never runs again
+type schedule interface {
+	next(t time.Time) time.Time
+}
+
+type scheduledJob struct {
+	spec     string
+	schedule schedule
+	name     string
+	exchange string
+	produce  func(ctx context.Context) ([]byte, error)
+}
+
+// scheduler publishes the scheduled messages from Listen until Close.
+// With Config.SchedulerLock only the replica holding the lock publishes, see elect.
+type scheduler struct {
+	app *App
+
+	mutex   sync.Mutex
+	jobs    []*scheduledJob
+	running bool
+	closed  bool
+	elected bool
+
+	leader atomic.Bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newScheduler(app *App) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{app: app, ctx: ctx, cancel: cancel}
+}
+
+// Schedule publishes body to the exchange with the routing key name on the schedule spec, a cron expression
+// ("*/5 * * * *") or a descriptor ("@hourly", "@every 30s"). It returns ErrInvalidSchedule for an invalid spec.
+func (a *App) Schedule(spec, name, exchange string, body []byte) error {
+	return a.ScheduleFunc(spec, name, exchange, func(context.Context) ([]byte, error) {
+		return body, nil
+	})
+}
+
+// ScheduleJSON is like Schedule, body is marshaled to JSON once
+func (a *App) ScheduleJSON(spec, name, exchange string, body interface{}) error {
+	data, err := a.config.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return a.Schedule(spec, name, exchange, data)
+}
+
+// ScheduleFunc is like Schedule, the body is produced by produce every time the schedule fires.
+// A producer error is logged and nothing is published, ctx is cancelled by Close.
+func (a *App) ScheduleFunc(spec, name, exchange string, produce func(ctx context.Context) ([]byte, error)) error {
+	parsed, err := parseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	a.scheduler.add(&scheduledJob{spec: spec, schedule: parsed, name: name, exchange: exchange, produce: produce})
+	return nil
+}
+
+func (s *scheduler) add(job *scheduledJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.jobs = append(s.jobs, job)
+	if s.running {
+		s.run(job)
+	}
+}
+
+// start runs the jobs, it must be called once
+func (s *scheduler) start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.running = true
+	for _, job := range s.jobs {
+		s.run(job)
+	}
+}
+
+// close stops the jobs and waits for the running ones
+func (s *scheduler) close() {
+	s.mutex.Lock()
+	s.closed = true
+	s.running = false
+	s.mutex.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+}
+
+// run starts the goroutine of a job, it must be called with s.mutex held
+func (s *scheduler) run(job *scheduledJob) {
+	if lock := s.app.config.SchedulerLock; lock != "" && !s.elected {
+		s.elected = true
+		s.wg.Add(1)
+		go s.elect(lock)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			now := time.Now()
+			next := job.schedule.next(now)
+			if next.IsZero() {
+				return
+			}
+
+			timer := time.NewTimer(next.Sub(now))
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			if s.app.config.SchedulerLock != "" && !s.leader.Load() {
+				continue
+			}
+
+			s.fire(job)
+		}
+	}()
+}
+
+func (s *scheduler) fire(job *scheduledJob) {
+	a := s.app
+
+	body, err := job.produce(s.ctx)
+	if err == nil {
+		err = a.PublishWithContext(s.ctx, job.name, job.exchange, body)
+	}
+	if err != nil && s.ctx.Err() == nil {
+		a.config.Logger.Error("Problem with scheduled publishing", "schedule", job.spec, "exchange", job.exchange, "routing_key", job.name, "error", err)
+	}
+}
+
+// elect keeps the replica in the election of the lock queue, a single active consumer queue holding a token message.
+// The replica whose consumer is active receives the token and is the leader until its channel closes,
+// the broker then requeues the token to the next consumer.
+func (s *scheduler) elect(lock string) {
+	defer s.wg.Done()
+
+	backoff := s.app.config.ResubscribeBackoff
+	for {
+		subscribed, err := s.hold(lock)
+		s.leader.Store(false)
+
+		if subscribed {
+			backoff = s.app.config.ResubscribeBackoff
+		}
+
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.app.config.Logger.Error("Problem with the scheduler lock", "queue", lock, "error", err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, s.app.config.ResubscribeMaxBackoff)
+	}
+}
+
+// hold consumes the lock queue until the channel closes or the scheduler is closed,
+// subscribed reports whether it joined the election
+func (s *scheduler) hold(lock string) (subscribed bool, err error) {
+	a := s.app
+
+	connection, err := a.dial()
+	if err != nil {
+		return false, err
+	}
+	a.track(connection)
+	defer connection.Close()
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return false, err
+	}
+	closed := channel.NotifyClose(make(chan *amqp091.Error, 1))
+
+	_, err = channel.QueueDeclare(lock, true, false, false, false, amqp091.Table{
+		"x-single-active-consumer": true,
+		"x-max-length":             1,
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := channel.Qos(1, 0, false); err != nil {
+		return false, err
+	}
+
+	messages, err := channel.Consume(lock, a.consumerTag(DefaultConsumerTag, lock), false, false, false, false, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Every replica adds a token, x-max-length keeps at most one waiting
+	err = channel.PublishWithContext(s.ctx, "", lock, false, false, amqp091.Publishing{Body: []byte("lock")})
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return true, nil
+		case err := <-closed:
+			if err != nil {
+				return true, err
+			}
+			return true, amqp091.ErrClosed
+		case _, ok := <-messages:
+			if !ok {
+				return true, amqp091.ErrClosed
+			}
+			if !s.leader.Swap(true) {
+				a.config.Logger.Info("Scheduler elected leader", "queue", lock)
+			}
+		}
+	}
+}
+
+// parseSchedule parses a cron expression of five fields (minute, hour, day of month, month, day of week)
+// or a descriptor: @yearly, @annually, @monthly, @weekly, @daily, @midnight, @hourly or @every <duration>
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, spec)
+		}
+		return intervalSchedule(interval), nil
+	}
+
+	switch spec {
+	case "@yearly", "@annually":
+		spec = "0 0 1 1 *"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@hourly":
+		spec = "0 * * * *"
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q has %d fields, expected 5", ErrInvalidSchedule, spec, len(fields))
+	}
+
+	var c cronSchedule
+	var err error
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	targets := [5]*cronField{&c.minute, &c.hour, &c.day, &c.month, &c.weekday}
+	for i, field := range fields {
+		if *targets[i], err = parseCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSchedule, spec, err)
+		}
+	}
+
+	// Sunday is 0 or 7
+	if c.weekday.has(7) {
+		c.weekday.bits |= 1
+	}
+	c.restrictDay = !strings.HasPrefix(fields[2], "*")
+	c.restrictWeekday = !strings.HasPrefix(fields[4], "*")
+
+	return c, nil
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronField is the set of values of a cron field
+type cronField struct {
+	bits uint64
+}
+
+func (f cronField) has(value int) bool {
+	return f.bits&(1<<uint(value)) != 0
+}
+
+// parseCronField parses a comma separated list of "*", values and ranges, each with an optional "/step"
+func parseCronField(field string, low, high int) (cronField, error) {
+	var f cronField
+
+	for _, part := range strings.Split(field, ",") {
+		expr, stepExpr, stepped := strings.Cut(part, "/")
+
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+				return f, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		first, last := low, high
+		switch from, to, isRange := strings.Cut(expr, "-"); {
+		case expr == "*":
+		case isRange:
+			var err error
+			if first, err = strconv.Atoi(from); err != nil {
+				return f, fmt.Errorf("invalid range %q", part)
+			}
+			if last, err = strconv.Atoi(to); err != nil {
+				return f, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			if first, err = strconv.Atoi(expr); err != nil {
+				return f, fmt.Errorf("invalid value %q", part)
+			}
+			if !stepped {
+				last = first
+			}
+		}
+
+		if first < low || last > high || first > last {
+			return f, fmt.Errorf("%q is out of range %d-%d", part, low, high)
+		}
+
+		for value := first; value <= last; value += step {
+			f.bits |= 1 << uint(value)
+		}
+	}
+
+	return f, nil
+}
+
+type cronSchedule struct {
+	minute, hour, day, month, weekday cronField
+
+	// A day matches either field when both are restricted, like cron does
+	restrictDay, restrictWeekday bool
+}
+
+func (c cronSchedule) next(t time.Time) time.Time {
+	location := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, location).Add(time.Minute)
+
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+		case !c.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	day := c.day.has(t.Day())
+	weekday := c.weekday.has(int(t.Weekday()))
+
+	if c.restrictDay && c.restrictWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
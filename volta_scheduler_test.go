@@ -0,0 +1,101 @@
+package volta
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 17, 42, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, time.January, 31, 13, 0, 0, 0, time.UTC)},
+		{"30 8 * * 1,5", time.Date(2024, time.February, 2, 8, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Either the day of month or the day of week when both are restricted
+		{"0 0 15 * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, time.January, 31, 10, 19, 12, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		parsed, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if next := parsed.next(from); !next.Equal(tt.next) {
+			t.Errorf("parseSchedule(%q) runs next at %s, expected %s", tt.spec, next, tt.next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@every", "@every -1s", "@often"} {
+		if _, err := parseSchedule(spec); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("parseSchedule(%q) error = %v, expected %v", spec, err, ErrInvalidSchedule)
+		}
+	}
+
+	never, _ := parseSchedule("0 0 30 2 *")
+	if next := never.next(from); !next.IsZero() {
+		t.Errorf("February 30 runs next at %s, expected never", next)
+	}
+}
+
+func TestApp_Schedule(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := listening(t, transport)
+
+	if err := app.Schedule("@every 20ms", "orders", "test", []byte("tick")); err != nil {
+		t.Fatalf("App.Schedule() error = %v", err)
+	}
+	if err := app.Schedule("every minute", "orders", "test", nil); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("App.Schedule() error = %v, expected %v", err, ErrInvalidSchedule)
+	}
+
+	waitFor(t, func() bool { return transport.QueueLength("orders") >= 2 }, "scheduled messages were not published")
+
+	app.Close()
+	published := transport.QueueLength("orders")
+	time.Sleep(50 * time.Millisecond)
+	if length := transport.QueueLength("orders"); length != published {
+		t.Errorf("queue length went from %d to %d after Close, expected the schedule to stop", published, length)
+	}
+}
+
+func TestApp_ScheduleFunc_leaderElection(t *testing.T) {
+	transport := NewMemoryTransport()
+
+	var fired [2]atomic.Int64
+	apps := make([]*App, 2)
+	for i := range apps {
+		app := listening(t, transport, Config{SchedulerLock: "scheduler.lock", ResubscribeBackoff: 10 * time.Millisecond})
+
+		replica := i
+		err := app.ScheduleFunc("@every 10ms", "orders", "test", func(ctx context.Context) ([]byte, error) {
+			fired[replica].Add(1)
+			return []byte("tick"), nil
+		})
+		if err != nil {
+			t.Fatalf("App.ScheduleFunc() error = %v", err)
+		}
+
+		apps[i] = app
+	}
+
+	waitFor(t, func() bool { return fired[0].Load() >= 3 }, "the first replica is not the leader")
+	if n := fired[1].Load(); n != 0 {
+		t.Errorf("the second replica fired %d times while the first one is the leader", n)
+	}
+
+	apps[0].Close()
+	waitFor(t, func() bool { return fired[1].Load() >= 3 }, "the second replica did not take over")
+}
@@ -0,0 +1,481 @@
+package volta
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Headers used by the streaming reply protocol
+const (
+	streamSeqHeader     = "x-volta-stream-seq"
+	streamEndHeader     = "x-volta-stream-end"
+	streamErrorHeader   = "x-volta-stream-error"
+	streamWindowHeader  = "x-volta-stream-window"
+	streamControlHeader = "x-volta-stream-control"
+	streamCommandHeader = "x-volta-stream-command"
+)
+
+// Commands sent by the requester to the control queue of a stream
+const (
+	streamCommandCredit = "credit"
+	streamCommandCancel = "cancel"
+)
+
+// StreamWriter sends a reply as a sequence of chunks.
+// Every Write call is published as one chunk, Close publishes the end-of-stream marker.
+// When the requester announced a window, Write blocks until the requester confirms
+// enough chunks, so a slow reader slows the handler down instead of flooding the reply queue.
+type StreamWriter struct {
+	ctx     *Ctx
+	channel Channel
+	control string
+	window  int64
+
+	mutex     sync.Mutex
+	seq       int64
+	credited  int64
+	cancelled bool
+	closed    bool
+	signal    chan struct{}
+}
+
+// ReplyStream starts a streaming reply to the current message.
+// The delivery is acknowledged when the stream is closed.
+func (ctx *Ctx) ReplyStream() (*StreamWriter, error) {
+	if ctx.Delivery.ReplyTo == "" {
+		return nil, ErrNoReplyTo
+	}
+
+	channel, err := ctx.App.connection().Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	commands, err := channel.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	window, _ := tableInt(ctx.Delivery.Headers, streamWindowHeader)
+
+	writer := &StreamWriter{
+		ctx:     ctx,
+		channel: channel,
+		control: queue.Name,
+		window:  window,
+		signal:  make(chan struct{}, 1),
+	}
+
+	go writer.listen(commands)
+
+	return writer, nil
+}
+
+// listen applies the commands sent by the requester to the control queue
+func (w *StreamWriter) listen(commands <-chan amqp091.Delivery) {
+	for command := range commands {
+		w.mutex.Lock()
+		switch command.Headers[streamCommandHeader] {
+		case streamCommandCredit:
+			if seq, ok := tableInt(command.Headers, streamSeqHeader); ok && seq > w.credited {
+				w.credited = seq
+			}
+		case streamCommandCancel:
+			w.cancelled = true
+		}
+		w.mutex.Unlock()
+
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Write publishes p as the next chunk of the stream
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	if err := w.wait(); err != nil {
+		return 0, err
+	}
+
+	w.mutex.Lock()
+	w.seq++
+	seq := w.seq
+	w.mutex.Unlock()
+
+	if err := w.publish(p, amqp091.Table{streamSeqHeader: seq}); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// WriteJSON marshals data to JSON and publishes it as the next chunk of the stream
+func (w *StreamWriter) WriteJSON(data interface{}) error {
+	jsonData, err := w.ctx.App.config.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(jsonData)
+	return err
+}
+
+// Close publishes the end-of-stream marker and acknowledges the delivery
+func (w *StreamWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError publishes the end-of-stream marker carrying the given error and acknowledges the delivery.
+// The requester receives the error from Stream.Err.
+func (w *StreamWriter) CloseWithError(cause error) error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return ErrStreamClosed
+	}
+	w.closed = true
+	cancelled := w.cancelled
+	seq := w.seq + 1
+	w.mutex.Unlock()
+
+	defer w.channel.Close()
+
+	if !cancelled {
+		headers := amqp091.Table{streamSeqHeader: seq, streamEndHeader: true}
+		if cause != nil {
+			headers[streamErrorHeader] = cause.Error()
+		}
+
+		if err := w.publish(nil, headers); err != nil {
+			return err
+		}
+	}
+
+	return w.ctx.Ack(false)
+}
+
+// wait blocks until the window allows another chunk
+func (w *StreamWriter) wait() error {
+	timeout := time.NewTimer(w.ctx.App.timeout())
+	defer timeout.Stop()
+
+	for {
+		w.mutex.Lock()
+		switch {
+		case w.closed:
+			w.mutex.Unlock()
+			return ErrStreamClosed
+		case w.cancelled:
+			w.mutex.Unlock()
+			return ErrStreamCancelled
+		case w.window <= 0 || w.seq-w.credited < w.window:
+			w.mutex.Unlock()
+			return nil
+		}
+		w.mutex.Unlock()
+
+		select {
+		case <-w.signal:
+		case <-timeout.C:
+			return ErrStreamTimeout
+		}
+	}
+}
+
+func (w *StreamWriter) publish(body []byte, headers amqp091.Table) (err error) {
+	headers[streamControlHeader] = w.control
+
+	msg := amqp091.Publishing{
+		CorrelationId: w.ctx.Delivery.CorrelationId,
+		Headers:       headers,
+		Body:          body,
+	}
+
+	ctx, end := w.ctx.App.startPublish(w.ctx.Context(), "", w.ctx.Delivery.ReplyTo, &msg)
+	defer func() { end(err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, w.ctx.App.timeout())
+	defer cancel()
+
+	return w.channel.PublishWithContext(
+		ctx,
+		"",
+		w.ctx.Delivery.ReplyTo,
+		false,
+		false,
+		msg,
+	)
+}
+
+// Stream is the requester side of a streaming reply.
+// Chunks are delivered in order through the channel returned by Chunks,
+// which is closed when the stream ends, fails or is cancelled.
+type Stream struct {
+	chunks chan []byte
+	cancel context.CancelFunc
+
+	mutex     sync.Mutex
+	err       error
+	cancelled bool
+	ended     sync.Once
+}
+
+// RequestStream publishes a message to the exchange with the given name and
+// receives the reply as a stream of chunks sent by StreamWriter.
+// The stream is bound to ctx: when ctx is done the replier is told to stop.
+// It fails with ErrRequestTimeout when no chunk arrives within Config.Timeout.
+func (a *App) RequestStream(ctx context.Context, name, exchange string, body []byte) (*Stream, error) {
+	connection, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	queue, err := channel.QueueDeclare("", false, false, true, false, nil)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	messages, err := channel.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	corrId := randomString(32)
+
+	msg := amqp091.Publishing{
+		ContentType:   "text/plain",
+		CorrelationId: corrId,
+		ReplyTo:       queue.Name,
+		Headers:       amqp091.Table{streamWindowHeader: int64(a.config.StreamWindow)},
+		Body:          body,
+	}
+
+	requestCtx, end := a.startRequest(ctx, exchange, name, &msg)
+
+	publishCtx, cancelPublish := context.WithTimeout(requestCtx, a.timeout())
+	defer cancelPublish()
+
+	err = channel.PublishWithContext(
+		publishCtx,
+		exchange,
+		name,
+		false,
+		false,
+		msg)
+	if err != nil {
+		end(nil, err)
+		connection.Close()
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream := &Stream{chunks: make(chan []byte), cancel: cancel}
+
+	go func() {
+		defer connection.Close()
+		defer stream.end()
+		defer cancel()
+
+		stream.fail(stream.receive(streamCtx, a, channel, messages, corrId))
+		end(nil, stream.Err())
+	}()
+
+	return stream, nil
+}
+
+// receive forwards the chunks to the stream and grants credit to the replier as they are consumed
+func (s *Stream) receive(ctx context.Context, a *App, channel Channel, messages <-chan amqp091.Delivery, corrId string) error {
+	var control string
+	var received, credited int64
+
+	step := int64(a.config.StreamWindow / 2)
+	if step < 1 {
+		step = 1
+	}
+
+	command := func(name string, seq int64) {
+		if control == "" {
+			return
+		}
+
+		publishCtx, cancel := context.WithTimeout(context.Background(), a.timeout())
+		defer cancel()
+
+		channel.PublishWithContext(publishCtx, "", control, false, false, amqp091.Publishing{
+			Headers: amqp091.Table{streamCommandHeader: name, streamSeqHeader: seq},
+		})
+	}
+
+	// Without a chunk for Config.Timeout the replier is considered gone
+	idle := time.NewTimer(a.timeout())
+	defer idle.Stop()
+
+	for {
+		var message amqp091.Delivery
+		var ok bool
+
+		// The time the requester takes to read a chunk does not count
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(a.timeout())
+
+		select {
+		case <-idle.C:
+			command(streamCommandCancel, received)
+			return ErrRequestTimeout
+		case <-ctx.Done():
+			if control == "" {
+				// The control queue is announced with the first chunk, the replier is told once it arrives
+				s.fail(ctx.Err())
+				s.end()
+				control = awaitControl(messages, corrId, a.timeout())
+			}
+			command(streamCommandCancel, received)
+			return ctx.Err()
+		case message, ok = <-messages:
+			if !ok {
+				return ErrStreamClosed
+			}
+		}
+
+		if message.CorrelationId != corrId {
+			continue
+		}
+
+		if name, ok := message.Headers[streamControlHeader].(string); ok {
+			control = name
+		}
+
+		seq, _ := tableInt(message.Headers, streamSeqHeader)
+		if seq != received+1 {
+			command(streamCommandCancel, received)
+			return ErrStreamOutOfOrder
+		}
+		received = seq
+
+		if end, _ := message.Headers[streamEndHeader].(bool); end {
+			if cause, ok := message.Headers[streamErrorHeader].(string); ok {
+				return errors.New(cause)
+			}
+			return nil
+		}
+
+		select {
+		case s.chunks <- message.Body:
+		case <-ctx.Done():
+			command(streamCommandCancel, received)
+			return ctx.Err()
+		}
+
+		if received-credited >= step {
+			credited = received
+			command(streamCommandCredit, credited)
+		}
+	}
+}
+
+// awaitControl returns the control queue announced by the next chunk of the stream, empty if none arrives before timeout
+func awaitControl(messages <-chan amqp091.Delivery, corrId string, timeout time.Duration) string {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return ""
+			}
+			if name, ok := message.Headers[streamControlHeader].(string); ok && message.CorrelationId == corrId {
+				return name
+			}
+		case <-timer.C:
+			return ""
+		}
+	}
+}
+
+// end closes the Chunks channel
+func (s *Stream) end() {
+	s.ended.Do(func() { close(s.chunks) })
+}
+
+func (s *Stream) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancelled {
+		err = ErrStreamCancelled
+	}
+	s.err = err
+}
+
+// Chunks returns the channel the chunks are delivered through
+func (s *Stream) Chunks() <-chan []byte {
+	return s.chunks
+}
+
+// Err returns the error the stream ended with, it is nil when the stream completed successfully.
+// It should be called after the Chunks channel is closed.
+func (s *Stream) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err
+}
+
+// Cancel stops the stream and tells the replier to stop producing chunks
+func (s *Stream) Cancel() {
+	s.mutex.Lock()
+	s.cancelled = true
+	s.mutex.Unlock()
+
+	s.cancel()
+}
+
+// tableInt reads an integer header regardless of the width it was encoded with
+func tableInt(table amqp091.Table, key string) (int64, bool) {
+	switch v := table[key].(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,177 @@
+package volta
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestApp_RequestStream(t *testing.T) {
+	app := New(Config{
+		Transport:      NewMemoryTransport(),
+		DisableLogging: true,
+		StreamWindow:   2,
+	})
+
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "test.stream", RoutingKey: "test.stream", Exchange: "test"})
+	app.AddConsumer("test.stream", func(ctx *Ctx) error {
+		writer, err := ctx.ReplyStream()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, err := fmt.Fprintf(writer, "chunk-%d", i); err != nil {
+				return writer.CloseWithError(err)
+			}
+		}
+
+		return writer.Close()
+	})
+
+	go func(app *App) {
+		if err := app.Listen(); err != nil {
+			t.Errorf("App.Listen() error = %v", err)
+		}
+	}(app)
+
+	time.Sleep(1 * time.Second)
+
+	stream, err := app.RequestStream(context.Background(), "test.stream", "test", []byte("test"))
+	if err != nil {
+		t.Fatalf("App.RequestStream() error = %v", err)
+	}
+
+	i := 0
+	for chunk := range stream.Chunks() {
+		if string(chunk) != fmt.Sprintf("chunk-%d", i) {
+			t.Errorf("Chunk is %s, expected chunk-%d", chunk, i)
+		}
+		i++
+	}
+
+	if err := stream.Err(); err != nil {
+		t.Errorf("Stream.Err() error = %v", err)
+	}
+
+	if i != 5 {
+		t.Errorf("Received %d chunks, expected 5", i)
+	}
+
+	if err := app.Close(); err != nil {
+		t.Errorf("App.Close() error = %v", err)
+	}
+}
+
+func TestStream_Cancel_beforeFirstChunk(t *testing.T) {
+	app := New(Config{
+		Transport:      NewMemoryTransport(),
+		DisableLogging: true,
+		StreamWindow:   2,
+		Timeout:        2,
+	})
+
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "test.stream", RoutingKey: "test.stream", Exchange: "test"})
+
+	start := make(chan struct{})
+	result := make(chan error, 1)
+	app.AddConsumer("test.stream", func(ctx *Ctx) error {
+		writer, err := ctx.ReplyStream()
+		if err != nil {
+			return err
+		}
+
+		<-start
+		for {
+			if _, err := writer.Write([]byte("chunk")); err != nil {
+				result <- err
+				return writer.Close()
+			}
+		}
+	})
+
+	go app.Listen()
+	defer app.Close()
+	waitHealthy(t, app)
+
+	stream, err := app.RequestStream(context.Background(), "test.stream", "test", []byte("test"))
+	if err != nil {
+		t.Fatalf("App.RequestStream() error = %v", err)
+	}
+
+	stream.Cancel()
+	select {
+	case <-stream.Chunks():
+	case <-time.After(time.Second):
+		t.Fatal("Chunks was not closed by Cancel")
+	}
+	close(start)
+
+	select {
+	case err := <-result:
+		if err != ErrStreamCancelled {
+			t.Errorf("StreamWriter.Write() error = %v, expected %v", err, ErrStreamCancelled)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the replier was not cancelled")
+	}
+
+	if err := stream.Err(); err != ErrStreamCancelled {
+		t.Errorf("Stream.Err() error = %v, expected %v", err, ErrStreamCancelled)
+	}
+}
+
+func TestApp_RequestStream_timeout(t *testing.T) {
+	app := listening(t, NewMemoryTransport(), Config{Timeout: 1})
+
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	_, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	stream, err := app.RequestStream(context.Background(), "orders", "test", []byte("test"))
+	if err != nil {
+		t.Fatalf("App.RequestStream() error = %v", err)
+	}
+
+	// TEST: the stream fails when the replier never sends a chunk
+	select {
+	case <-stream.Chunks():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Chunks was not closed after Config.Timeout")
+	}
+
+	if err := stream.Err(); err != ErrRequestTimeout {
+		t.Errorf("Stream.Err() error = %v, expected %v", err, ErrRequestTimeout)
+	}
+}
+
+func TestTableInt(t *testing.T) {
+	table := amqp091.Table{"int32": int32(3), "int64": int64(4), "string": "5"}
+
+	if v, ok := tableInt(table, "int32"); !ok || v != 3 {
+		t.Errorf("tableInt() = %d, %v, expected 3, true", v, ok)
+	}
+
+	if v, ok := tableInt(table, "int64"); !ok || v != 4 {
+		t.Errorf("tableInt() = %d, %v, expected 4, true", v, ok)
+	}
+
+	if _, ok := tableInt(table, "string"); ok {
+		t.Error("tableInt() accepted a string header")
+	}
+
+	if _, ok := tableInt(table, "missing"); ok {
+		t.Error("tableInt() accepted a missing header")
+	}
+}
@@ -0,0 +1,47 @@
+package volta
+
+import (
+	"context"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Tracer instruments publishing and consuming, e.g. to propagate trace context through headers.
+// See github.com/volta-dev/volta/otel for an OpenTelemetry implementation.
+type Tracer interface {
+	// StartPublish is called before a message is published, it may modify the message (e.g. its headers).
+	// The returned function is called with the result of the publish.
+	StartPublish(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(error))
+
+	// StartRequest is called before an RPC request is published, it may modify the message.
+	// The returned function is called with the reply, or the error the request failed with.
+	StartRequest(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(*amqp091.Delivery, error))
+
+	// StartConsume is called when a message is received, the returned context becomes Ctx.Context.
+	// The returned function is called with the error of the handler chain.
+	StartConsume(ctx context.Context, delivery *amqp091.Delivery) (context.Context, func(error))
+}
+
+func (a *App) startPublish(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(error)) {
+	if a.config.Tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	return a.config.Tracer.StartPublish(ctx, exchange, routingKey, msg)
+}
+
+func (a *App) startRequest(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(*amqp091.Delivery, error)) {
+	if a.config.Tracer == nil {
+		return ctx, func(*amqp091.Delivery, error) {}
+	}
+
+	return a.config.Tracer.StartRequest(ctx, exchange, routingKey, msg)
+}
+
+func (a *App) startConsume(ctx context.Context, delivery *amqp091.Delivery) (context.Context, func(error)) {
+	if a.config.Tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	return a.config.Tracer.StartConsume(ctx, delivery)
+}
@@ -0,0 +1,154 @@
+package volta
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type tracedKey struct{}
+
+// recordingTracer counts the spans started and ended per operation
+type recordingTracer struct {
+	mutex   sync.Mutex
+	started map[string]int
+	ended   map[string]int
+}
+
+func newRecordingTracer() *recordingTracer {
+	return &recordingTracer{started: map[string]int{}, ended: map[string]int{}}
+}
+
+func (r *recordingTracer) start(operation string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.started[operation]++
+}
+
+func (r *recordingTracer) end(operation string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.ended[operation]++
+}
+
+func (r *recordingTracer) count(operation string) (started, ended int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.started[operation], r.ended[operation]
+}
+
+func (r *recordingTracer) StartPublish(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(error)) {
+	r.start("publish")
+	return ctx, func(error) { r.end("publish") }
+}
+
+func (r *recordingTracer) StartRequest(ctx context.Context, exchange, routingKey string, msg *amqp091.Publishing) (context.Context, func(*amqp091.Delivery, error)) {
+	r.start("request")
+	return ctx, func(*amqp091.Delivery, error) { r.end("request") }
+}
+
+func (r *recordingTracer) StartConsume(ctx context.Context, delivery *amqp091.Delivery) (context.Context, func(error)) {
+	r.start("consume")
+	return context.WithValue(ctx, tracedKey{}, true), func(error) { r.end("consume") }
+}
+
+func TestBatch_Publish_tracing(t *testing.T) {
+	tracer := newRecordingTracer()
+	app := listening(t, NewMemoryTransport(), Config{Tracer: tracer})
+
+	// TEST: every message of a batch is traced
+	results, err := app.NewBatch().Add("orders", "test", []byte("1")).Add("orders", "test", []byte("2")).Publish(context.Background())
+	if err != nil || !results[0].Ok() || !results[1].Ok() {
+		t.Fatalf("Batch.Publish() = %v, %v", results, err)
+	}
+
+	if started, ended := tracer.count("publish"); started != 2 || ended != 2 {
+		t.Errorf("%d publish spans started and %d ended, expected 2", started, ended)
+	}
+}
+
+func TestApp_AddBatchConsumer_tracing(t *testing.T) {
+	tracer := newRecordingTracer()
+	app := New(Config{Transport: NewMemoryTransport(), DisableLogging: true, Tracer: tracer})
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "orders", Exchange: "test", RoutingKey: "orders"})
+
+	// TEST: every message of a batch gets its own context carrying the span
+	traced := make(chan bool, 2)
+	app.AddBatchConsumer("orders", func(batch *BatchCtx) error {
+		for _, item := range batch.Items {
+			traced <- item.Context().Value(tracedKey{}) == true
+		}
+		return nil
+	}, BatchOptions{MaxSize: 2, MaxWait: 10 * time.Millisecond})
+
+	go app.Listen()
+	t.Cleanup(func() { app.Close() })
+	waitHealthy(t, app)
+
+	for i := 0; i < 2; i++ {
+		if err := app.Publish("orders", "test", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("App.Publish() error = %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case ok := <-traced:
+			if !ok {
+				t.Errorf("Item #%d has no span in its context", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Batch was not handled")
+		}
+	}
+
+	waitFor(t, func() bool {
+		started, ended := tracer.count("consume")
+		return started == 2 && ended == 2
+	}, "the consume spans of the batch were not ended")
+}
+
+func TestApp_RequestStream_tracing(t *testing.T) {
+	tracer := newRecordingTracer()
+	app := listening(t, NewMemoryTransport(), Config{Tracer: tracer})
+
+	_, err := app.StartConsumer("orders", func(ctx *Ctx) error {
+		writer, err := ctx.ReplyStream()
+		if err != nil {
+			return err
+		}
+
+		if _, err := writer.Write([]byte("chunk")); err != nil {
+			return writer.CloseWithError(err)
+		}
+		return writer.Close()
+	})
+	if err != nil {
+		t.Fatalf("App.StartConsumer() error = %v", err)
+	}
+
+	stream, err := app.RequestStream(context.Background(), "orders", "test", []byte("test"))
+	if err != nil {
+		t.Fatalf("App.RequestStream() error = %v", err)
+	}
+	for range stream.Chunks() {
+	}
+
+	// TEST: the request spans the stream, every chunk is a publish
+	waitFor(t, func() bool {
+		started, ended := tracer.count("request")
+		return started == 1 && ended == 1
+	}, "the request span was not ended with the stream")
+
+	if started, ended := tracer.count("publish"); started != 2 || ended != 2 {
+		t.Errorf("%d publish spans started and %d ended, expected 2 for the chunk and the end of the stream", started, ended)
+	}
+}
@@ -0,0 +1,82 @@
+package volta
+
+import (
+	"context"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Transport opens connections to the broker. The default speaks AMQP 0.9.1 to RabbitMQ,
+// NewMemoryTransport returns an in-memory broker for tests.
+type Transport interface {
+	Dial(url string) (Connection, error)
+}
+
+// Connection is a connection to the broker, see amqp091.Connection
+type Connection interface {
+	Channel() (Channel, error)
+	NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error
+	IsClosed() bool
+	Close() error
+}
+
+// Channel is a channel of a Connection, see amqp091.Channel which implements it
+type Channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error
+	ExchangeDelete(name string, ifUnused, noWait bool) error
+	ExchangeBind(destination, key, source string, noWait bool, args amqp091.Table) error
+
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error
+	QueueUnbind(name, key, exchange string, args amqp091.Table) error
+	QueuePurge(name string, noWait bool) (int, error)
+	QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error)
+
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error
+	Confirm(noWait bool) error
+	GetNextPublishSeqNo() uint64
+
+	NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation
+	NotifyReturn(returns chan amqp091.Return) chan amqp091.Return
+	NotifyCancel(cancellations chan string) chan string
+	NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error
+
+	IsClosed() bool
+	Close() error
+}
+
+var _ Channel = (*amqp091.Channel)(nil)
+
+// amqpTransport connects to RabbitMQ with amqp091
+type amqpTransport struct{}
+
+func (amqpTransport) Dial(url string) (Connection, error) {
+	connection, err := amqp091.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return amqpConnection{connection}, nil
+}
+
+type amqpConnection struct {
+	*amqp091.Connection
+}
+
+func (c amqpConnection) Channel() (Channel, error) {
+	channel, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return channel, nil
+}
+
+// dial opens a connection with the configured transport
+func (a *App) dial() (Connection, error) {
+	return a.config.Transport.Dial(a.config.RabbitMQ)
+}
@@ -0,0 +1,1379 @@
+package volta
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryTransport is an in-memory broker implementing Transport, so handlers and topology can be tested without RabbitMQ.
+// It models direct, fanout, topic and headers routing, exchange to exchange bindings, acknowledgements, requeues,
+// prefetch, publisher confirms, mandatory returns, message TTL (x-message-ttl and expiration), x-max-length,
+// dead-lettering, single active consumer queues and the x-delayed-message exchange of the delayed message exchange plugin.
+// Nothing is persisted and every connection shares the same broker, whatever URL is dialed.
+type MemoryTransport struct {
+	mutex       sync.Mutex
+	exchanges   map[string]*memoryExchange
+	queues      map[string]*memoryQueue
+	connections map[*memoryConnection]struct{}
+	sequence    int
+
+	// after holds the notifications collected while locked, they are sent by unlock
+	after []func()
+}
+
+type memoryExchange struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	args       amqp091.Table
+	bindings   []memoryBinding
+}
+
+// memoryBinding binds a queue, or an exchange when destination is set, to an exchange
+type memoryBinding struct {
+	queue       string
+	destination string
+	key         string
+	args        amqp091.Table
+}
+
+// delayedExchange is the exchange type of the delayed message exchange plugin
+const delayedExchange = "x-delayed-message"
+
+// routing returns the type the exchange routes with, x-delayed-type for a delayed exchange
+func (e *memoryExchange) routing() string {
+	if e.kind == delayedExchange {
+		kind, _ := e.args["x-delayed-type"].(string)
+		return kind
+	}
+
+	return e.kind
+}
+
+type memoryQueue struct {
+	name       string
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	owner      *memoryConnection
+	args       amqp091.Table
+	deleted    bool
+
+	messages     []*memoryMessage
+	consumers    []*memoryConsumer
+	next         int
+	hadConsumers bool
+}
+
+type memoryMessage struct {
+	exchange    string
+	routingKey  string
+	publishing  amqp091.Publishing
+	redelivered bool
+	expiresAt   time.Time
+}
+
+type memoryConsumer struct {
+	tag       string
+	queue     *memoryQueue
+	channel   *memoryChannel
+	autoAck   bool
+	exclusive bool
+	prefetch  int
+	unacked   int
+	cancelled bool
+
+	deliveries chan amqp091.Delivery
+	buffer     []amqp091.Delivery
+	wake       chan struct{}
+	done       chan struct{}
+}
+
+type memoryUnacked struct {
+	message  *memoryMessage
+	queue    *memoryQueue
+	consumer *memoryConsumer
+}
+
+// NewMemoryTransport creates an empty broker with the default and the amq.* exchanges declared
+func NewMemoryTransport() *MemoryTransport {
+	t := &MemoryTransport{
+		exchanges:   make(map[string]*memoryExchange),
+		queues:      make(map[string]*memoryQueue),
+		connections: make(map[*memoryConnection]struct{}),
+	}
+
+	for name, kind := range map[string]string{
+		"":            amqp091.ExchangeDirect,
+		"amq.direct":  amqp091.ExchangeDirect,
+		"amq.fanout":  amqp091.ExchangeFanout,
+		"amq.topic":   amqp091.ExchangeTopic,
+		"amq.headers": amqp091.ExchangeHeaders,
+		"amq.match":   amqp091.ExchangeHeaders,
+	} {
+		t.exchanges[name] = &memoryExchange{name: name, kind: kind, durable: true}
+	}
+
+	return t
+}
+
+func (t *MemoryTransport) Dial(string) (Connection, error) {
+	t.mutex.Lock()
+	defer t.unlock()
+
+	connection := &memoryConnection{transport: t, channels: make(map[*memoryChannel]struct{})}
+	t.connections[connection] = struct{}{}
+
+	return connection, nil
+}
+
+// QueueLength returns the number of messages ready for delivery in the queue, -1 if it does not exist
+func (t *MemoryTransport) QueueLength(name string) int {
+	t.mutex.Lock()
+	defer t.unlock()
+
+	q, ok := t.queues[name]
+	if !ok {
+		return -1
+	}
+	t.expire(q)
+
+	return len(q.messages)
+}
+
+// CloseConnections closes every connection as if the broker went away, e.g. to test reconnecting
+func (t *MemoryTransport) CloseConnections() {
+	t.mutex.Lock()
+	defer t.unlock()
+
+	for connection := range t.connections {
+		t.closeConnection(connection, &amqp091.Error{
+			Code:    amqp091.ConnectionForced,
+			Reason:  "CONNECTION_FORCED - broker forced connection closure",
+			Server:  true,
+			Recover: true,
+		})
+	}
+}
+
+// unlock releases the broker and sends the notifications collected meanwhile
+func (t *MemoryTransport) unlock() {
+	after := t.after
+	t.after = nil
+	t.mutex.Unlock()
+
+	for _, notify := range after {
+		notify()
+	}
+}
+
+func (t *MemoryTransport) generateName(prefix string) string {
+	t.sequence++
+	return prefix + strconv.Itoa(t.sequence) + "-" + randomString(8)
+}
+
+// route returns the queues a message is routed to by the exchange, following exchange to exchange bindings
+func (t *MemoryTransport) route(exchange *memoryExchange, key string, headers amqp091.Table) []*memoryQueue {
+	if exchange.name == "" {
+		if q, ok := t.queues[key]; ok {
+			return []*memoryQueue{q}
+		}
+		return nil
+	}
+
+	var queues []*memoryQueue
+	seen := make(map[string]bool)
+	visited := map[string]bool{exchange.name: true}
+
+	var walk func(exchange *memoryExchange)
+	walk = func(exchange *memoryExchange) {
+		for _, binding := range exchange.bindings {
+			if !bindingMatches(exchange.routing(), binding, key, headers) {
+				continue
+			}
+
+			if binding.destination != "" {
+				if destination, ok := t.exchanges[binding.destination]; ok && !visited[destination.name] {
+					visited[destination.name] = true
+					walk(destination)
+				}
+				continue
+			}
+
+			if q, ok := t.queues[binding.queue]; ok && !seen[binding.queue] {
+				seen[binding.queue] = true
+				queues = append(queues, q)
+			}
+		}
+	}
+	walk(exchange)
+
+	return queues
+}
+
+func bindingMatches(kind string, binding memoryBinding, key string, headers amqp091.Table) bool {
+	switch kind {
+	case amqp091.ExchangeFanout:
+		return true
+	case amqp091.ExchangeTopic:
+		return topicMatches(strings.Split(binding.key, "."), strings.Split(key, "."))
+	case amqp091.ExchangeHeaders:
+		return headersMatch(binding.args, headers)
+	default:
+		return binding.key == key
+	}
+}
+
+// topicMatches matches the words of a routing key against a binding pattern,
+// "*" matches exactly one word and "#" zero or more words
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+// headersMatch compares the binding arguments with the headers of a message, x-match is "all" unless set to "any"
+func headersMatch(args, headers amqp091.Table) bool {
+	matchAny := args["x-match"] == "any" || args["x-match"] == "any-with-x"
+
+	matched := 0
+	total := 0
+	for key, expected := range args {
+		if strings.HasPrefix(key, "x-") {
+			continue
+		}
+		total++
+
+		value, ok := headers[key]
+		if ok && (expected == nil || fmt.Sprint(value) == fmt.Sprint(expected)) {
+			matched++
+		}
+	}
+
+	if matchAny {
+		return matched > 0
+	}
+	return matched == total
+}
+
+// delay routes a message published to a delayed exchange after its x-delay header in milliseconds
+func (t *MemoryTransport) delay(exchange *memoryExchange, key string, msg amqp091.Publishing) {
+	delay, _ := tableInt(msg.Headers, "x-delay")
+
+	time.AfterFunc(time.Duration(max(delay, 0))*time.Millisecond, func() {
+		t.mutex.Lock()
+		defer t.unlock()
+
+		if t.exchanges[exchange.name] != exchange {
+			return
+		}
+		for _, q := range t.route(exchange, key, msg.Headers) {
+			t.enqueue(q, &memoryMessage{exchange: exchange.name, routingKey: key, publishing: clonePublishing(msg)})
+		}
+	})
+}
+
+// enqueue appends a message to the queue, applying its TTL and length limit, then dispatches it
+func (t *MemoryTransport) enqueue(q *memoryQueue, message *memoryMessage) {
+	if ttl, ok := messageTTL(q.args, message.publishing.Expiration); ok {
+		message.expiresAt = time.Now().Add(ttl)
+
+		time.AfterFunc(ttl, func() {
+			t.mutex.Lock()
+			defer t.unlock()
+
+			t.expire(q)
+		})
+	}
+
+	q.messages = append(q.messages, message)
+
+	if limit, ok := tableInt(q.args, "x-max-length"); ok {
+		for int64(len(q.messages)) > limit {
+			head := q.messages[0]
+			q.messages = q.messages[1:]
+			t.deadLetter(q, head, "maxlen")
+		}
+	}
+
+	t.dispatch(q)
+}
+
+// clonePublishing copies the headers and the body, so neither the publisher nor a consumer can change a queued message
+func clonePublishing(msg amqp091.Publishing) amqp091.Publishing {
+	if msg.Headers != nil {
+		headers := make(amqp091.Table, len(msg.Headers))
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+		msg.Headers = headers
+	}
+	msg.Body = append([]byte(nil), msg.Body...)
+
+	return msg
+}
+
+// messageTTL returns the shorter of the queue TTL and the expiration of the message
+func messageTTL(args amqp091.Table, expiration string) (time.Duration, bool) {
+	ttl, ok := tableInt(args, "x-message-ttl")
+
+	if expiration != "" {
+		if value, err := strconv.ParseInt(expiration, 10, 64); err == nil && (!ok || value < ttl) {
+			ttl, ok = value, true
+		}
+	}
+
+	return time.Duration(ttl) * time.Millisecond, ok
+}
+
+// expire dead-letters the messages of the queue whose TTL passed
+func (t *MemoryTransport) expire(q *memoryQueue) {
+	if q.deleted {
+		return
+	}
+
+	now := time.Now()
+	kept := q.messages[:0]
+	for _, message := range q.messages {
+		if !message.expiresAt.IsZero() && !now.Before(message.expiresAt) {
+			t.deadLetter(q, message, "expired")
+			continue
+		}
+		kept = append(kept, message)
+	}
+	q.messages = kept
+}
+
+// deadLetter republishes a message to the dead letter exchange of the queue, or drops it when there is none
+func (t *MemoryTransport) deadLetter(q *memoryQueue, message *memoryMessage, reason string) {
+	name, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+
+	exchange, ok := t.exchanges[name]
+	if !ok {
+		return
+	}
+
+	key := message.routingKey
+	if dlrk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlrk
+	}
+
+	publishing := message.publishing
+	publishing.Headers = deathHeaders(publishing.Headers, q.name, reason, message)
+	if reason == "expired" {
+		publishing.Expiration = ""
+	}
+
+	for _, target := range t.route(exchange, key, publishing.Headers) {
+		// A queue dead-lettering into itself on overflow would never settle
+		if target == q && reason == "maxlen" {
+			continue
+		}
+
+		t.enqueue(target, &memoryMessage{exchange: name, routingKey: key, publishing: publishing})
+	}
+}
+
+// deathHeaders returns a copy of the headers with the x-death history of the message updated
+func deathHeaders(headers amqp091.Table, queue, reason string, message *memoryMessage) amqp091.Table {
+	result := make(amqp091.Table, len(headers)+4)
+	for key, value := range headers {
+		result[key] = value
+	}
+
+	deaths, _ := result["x-death"].([]interface{})
+	updated := []interface{}{nil}
+	count := int64(1)
+	for _, death := range deaths {
+		if table, ok := death.(amqp091.Table); ok && table["queue"] == queue && table["reason"] == reason {
+			if previous, ok := tableInt(table, "count"); ok {
+				count += previous
+			}
+			continue
+		}
+		updated = append(updated, death)
+	}
+	updated[0] = amqp091.Table{
+		"count":        count,
+		"reason":       reason,
+		"queue":        queue,
+		"time":         time.Now(),
+		"exchange":     message.exchange,
+		"routing-keys": []interface{}{message.routingKey},
+	}
+	result["x-death"] = updated
+
+	if _, ok := result["x-first-death-reason"]; !ok {
+		result["x-first-death-reason"] = reason
+		result["x-first-death-queue"] = queue
+		result["x-first-death-exchange"] = message.exchange
+	}
+
+	return result
+}
+
+// dispatch hands the ready messages of the queue to its consumers in turn, as far as their prefetch allows
+func (t *MemoryTransport) dispatch(q *memoryQueue) {
+	if q.deleted {
+		return
+	}
+
+	t.expire(q)
+
+	for len(q.messages) > 0 && len(q.consumers) > 0 {
+		// A single active consumer queue delivers to its oldest consumer only
+		consumers := q.consumers
+		if single, _ := q.args["x-single-active-consumer"].(bool); single {
+			consumers = consumers[:1]
+		}
+
+		var consumer *memoryConsumer
+		for i := 0; i < len(consumers); i++ {
+			candidate := consumers[(q.next+i)%len(consumers)]
+			if candidate.ready() {
+				consumer = candidate
+				q.next = (q.next + i + 1) % len(consumers)
+				break
+			}
+		}
+		if consumer == nil {
+			return
+		}
+
+		message := q.messages[0]
+		q.messages = q.messages[1:]
+		consumer.deliver(message)
+	}
+}
+
+// ready reports whether the consumer may receive another message under its prefetch limits
+func (c *memoryConsumer) ready() bool {
+	if c.autoAck {
+		return true
+	}
+	if c.prefetch > 0 && c.unacked >= c.prefetch {
+		return false
+	}
+	if c.channel.globalPrefetch > 0 && len(c.channel.unacked) >= c.channel.globalPrefetch {
+		return false
+	}
+
+	return true
+}
+
+func (c *memoryConsumer) deliver(message *memoryMessage) {
+	ch := c.channel
+	ch.deliveryTag++
+
+	p := clonePublishing(message.publishing)
+	delivery := amqp091.Delivery{
+		Acknowledger:    ch,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     c.tag,
+		DeliveryTag:     ch.deliveryTag,
+		Redelivered:     message.redelivered,
+		Exchange:        message.exchange,
+		RoutingKey:      message.routingKey,
+		Body:            p.Body,
+	}
+
+	if !c.autoAck {
+		ch.unacked[ch.deliveryTag] = &memoryUnacked{message: message, queue: c.queue, consumer: c}
+		c.unacked++
+	}
+
+	c.buffer = append(c.buffer, delivery)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump sends the buffered deliveries to the consumer outside the broker lock
+func (c *memoryConsumer) pump(t *MemoryTransport) {
+	defer close(c.deliveries)
+
+	for {
+		t.mutex.Lock()
+		if c.cancelled {
+			t.mutex.Unlock()
+			return
+		}
+		if len(c.buffer) == 0 {
+			t.mutex.Unlock()
+
+			select {
+			case <-c.wake:
+			case <-c.done:
+			}
+			continue
+		}
+
+		delivery := c.buffer[0]
+		c.buffer = c.buffer[1:]
+		t.mutex.Unlock()
+
+		select {
+		case c.deliveries <- delivery:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// cancel stops the consumer, the deliveries it did not hand over yet go back to the queue
+func (t *MemoryTransport) cancel(c *memoryConsumer, notify bool) {
+	if c.cancelled {
+		return
+	}
+	c.cancelled = true
+	close(c.done)
+
+	ch := c.channel
+	delete(ch.consumers, c.tag)
+
+	for i := len(c.buffer) - 1; i >= 0; i-- {
+		if unacked, ok := ch.unacked[c.buffer[i].DeliveryTag]; ok {
+			delete(ch.unacked, c.buffer[i].DeliveryTag)
+			t.requeue(unacked)
+		}
+	}
+	c.buffer = nil
+
+	q := c.queue
+	for i, consumer := range q.consumers {
+		if consumer == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.next >= len(q.consumers) {
+		q.next = 0
+	}
+
+	if notify {
+		tag := c.tag
+		ch.notify(func() {
+			for _, listener := range ch.cancelListeners {
+				listener <- tag
+			}
+		})
+	}
+
+	if q.autoDelete && q.hadConsumers && len(q.consumers) == 0 {
+		t.deleteQueue(q)
+	}
+}
+
+// requeue puts an unacknowledged message back at the head of its queue
+func (t *MemoryTransport) requeue(unacked *memoryUnacked) {
+	if unacked.queue.deleted {
+		return
+	}
+
+	message := *unacked.message
+	message.redelivered = true
+	unacked.queue.messages = append([]*memoryMessage{&message}, unacked.queue.messages...)
+}
+
+func (t *MemoryTransport) deleteQueue(q *memoryQueue) int {
+	if q.deleted {
+		return 0
+	}
+
+	for _, consumer := range append([]*memoryConsumer(nil), q.consumers...) {
+		t.cancel(consumer, true)
+	}
+
+	q.deleted = true
+	delete(t.queues, q.name)
+
+	for _, exchange := range t.exchanges {
+		kept := exchange.bindings[:0]
+		for _, binding := range exchange.bindings {
+			if binding.queue != q.name {
+				kept = append(kept, binding)
+			}
+		}
+		exchange.bindings = kept
+	}
+
+	return len(q.messages)
+}
+
+func (t *MemoryTransport) closeConnection(connection *memoryConnection, cause *amqp091.Error) {
+	if connection.closed {
+		return
+	}
+	connection.closed = true
+	delete(t.connections, connection)
+
+	for ch := range connection.channels {
+		t.closeChannel(ch, cause)
+	}
+
+	for _, q := range t.queues {
+		if q.exclusive && q.owner == connection {
+			t.deleteQueue(q)
+		}
+	}
+
+	listeners := connection.closeListeners
+	connection.closeListeners = nil
+	t.after = append(t.after, func() {
+		for _, listener := range listeners {
+			if cause != nil {
+				listener <- cause
+			}
+			close(listener)
+		}
+	})
+}
+
+func (t *MemoryTransport) closeChannel(ch *memoryChannel, cause *amqp091.Error) {
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	delete(ch.connection.channels, ch)
+
+	for _, consumer := range ch.consumers {
+		t.cancel(consumer, false)
+	}
+
+	// Requeued from the last delivery to the first, so they end up at the head in delivery order
+	requeued := make(map[*memoryQueue]bool)
+	for tag := ch.deliveryTag; tag > 0; tag-- {
+		if unacked, ok := ch.unacked[tag]; ok {
+			requeued[unacked.queue] = true
+			t.requeue(unacked)
+		}
+	}
+	ch.unacked = nil
+
+	for q := range requeued {
+		t.dispatch(q)
+	}
+
+	ch.notify(func() {
+		ch.listenersClosed = true
+
+		for _, listener := range ch.closeListeners {
+			if cause != nil {
+				listener <- cause
+			}
+			close(listener)
+		}
+		for _, listener := range ch.confirmListeners {
+			close(listener)
+		}
+		for _, listener := range ch.returnListeners {
+			close(listener)
+		}
+		for _, listener := range ch.cancelListeners {
+			close(listener)
+		}
+	})
+}
+
+// fail closes the channel with an error like the broker does on a channel exception
+func (t *MemoryTransport) fail(ch *memoryChannel, code int, format string, args ...interface{}) error {
+	err := &amqp091.Error{Code: code, Reason: fmt.Sprintf(format, args...), Server: true}
+	t.closeChannel(ch, err)
+
+	return err
+}
+
+type memoryConnection struct {
+	transport      *MemoryTransport
+	channels       map[*memoryChannel]struct{}
+	closed         bool
+	closeListeners []chan *amqp091.Error
+}
+
+func (c *memoryConnection) Channel() (Channel, error) {
+	c.transport.mutex.Lock()
+	defer c.transport.unlock()
+
+	if c.closed {
+		return nil, amqp091.ErrClosed
+	}
+
+	ch := &memoryChannel{
+		transport:  c.transport,
+		connection: c,
+		consumers:  make(map[string]*memoryConsumer),
+		unacked:    make(map[uint64]*memoryUnacked),
+	}
+	c.channels[ch] = struct{}{}
+
+	return ch, nil
+}
+
+func (c *memoryConnection) NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error {
+	c.transport.mutex.Lock()
+	defer c.transport.unlock()
+
+	if c.closed {
+		close(receiver)
+	} else {
+		c.closeListeners = append(c.closeListeners, receiver)
+	}
+
+	return receiver
+}
+
+func (c *memoryConnection) IsClosed() bool {
+	c.transport.mutex.Lock()
+	defer c.transport.unlock()
+
+	return c.closed
+}
+
+func (c *memoryConnection) Close() error {
+	c.transport.mutex.Lock()
+	defer c.transport.unlock()
+
+	if c.closed {
+		return amqp091.ErrClosed
+	}
+	c.transport.closeConnection(c, nil)
+
+	return nil
+}
+
+type memoryChannel struct {
+	transport  *MemoryTransport
+	connection *memoryConnection
+	closed     bool
+
+	prefetch       int
+	globalPrefetch int
+	consumers      map[string]*memoryConsumer
+	unacked        map[uint64]*memoryUnacked
+	deliveryTag    uint64
+
+	confirm   bool
+	published uint64
+
+	// notifyMutex orders the notifications of the channel and closing their listeners
+	notifyMutex      sync.Mutex
+	listenersClosed  bool
+	confirmListeners []chan amqp091.Confirmation
+	returnListeners  []chan amqp091.Return
+	cancelListeners  []chan string
+	closeListeners   []chan *amqp091.Error
+}
+
+var _ Channel = (*memoryChannel)(nil)
+
+// notify queues a notification to be sent once the broker is unlocked
+func (ch *memoryChannel) notify(send func()) {
+	ch.transport.after = append(ch.transport.after, func() {
+		ch.notifyMutex.Lock()
+		defer ch.notifyMutex.Unlock()
+
+		if !ch.listenersClosed {
+			send()
+		}
+	})
+}
+
+func (ch *memoryChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp091.Table) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	if exchange, ok := t.exchanges[name]; ok {
+		if name == "" {
+			return t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+		}
+		if exchange.kind != kind || exchange.durable != durable || exchange.autoDelete != autoDelete || exchange.internal != internal {
+			return t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arg for exchange '%s'", name)
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(name, "amq.") {
+		return t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - exchange name '%s' contains reserved prefix 'amq.*'", name)
+	}
+
+	exchange := &memoryExchange{name: name, kind: kind, durable: durable, autoDelete: autoDelete, internal: internal, args: args}
+
+	switch exchange.routing() {
+	case amqp091.ExchangeDirect, amqp091.ExchangeFanout, amqp091.ExchangeTopic, amqp091.ExchangeHeaders:
+	default:
+		if kind == delayedExchange {
+			return t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - invalid x-delayed-type for exchange '%s'", name)
+		}
+		return t.fail(ch, amqp091.CommandInvalid, "COMMAND_INVALID - unknown exchange type '%s'", kind)
+	}
+
+	t.exchanges[name] = exchange
+
+	return nil
+}
+
+func (ch *memoryChannel) ExchangeDelete(name string, ifUnused, noWait bool) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	exchange, ok := t.exchanges[name]
+	if !ok {
+		return nil
+	}
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - operation not permitted on exchange '%s'", name)
+	}
+	if ifUnused && len(exchange.bindings) > 0 {
+		return t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - exchange '%s' in use", name)
+	}
+
+	delete(t.exchanges, name)
+
+	return nil
+}
+
+func (ch *memoryChannel) ExchangeBind(destination, key, source string, noWait bool, args amqp091.Table) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	if _, ok := t.exchanges[destination]; !ok {
+		return t.fail(ch, amqp091.NotFound, "NOT_FOUND - no exchange '%s'", destination)
+	}
+	e, ok := t.exchanges[source]
+	if !ok {
+		return t.fail(ch, amqp091.NotFound, "NOT_FOUND - no exchange '%s'", source)
+	}
+	if source == "" || destination == "" {
+		return t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+	}
+
+	for _, binding := range e.bindings {
+		if binding.destination == destination && binding.key == key && fmt.Sprint(binding.args) == fmt.Sprint(args) {
+			return nil
+		}
+	}
+	e.bindings = append(e.bindings, memoryBinding{destination: destination, key: key, args: args})
+
+	return nil
+}
+
+func (ch *memoryChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp091.Table) (amqp091.Queue, error) {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.Queue{}, amqp091.ErrClosed
+	}
+
+	if q, ok := t.queues[name]; ok {
+		if q.exclusive && q.owner != ch.connection {
+			return amqp091.Queue{}, t.fail(ch, amqp091.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", name)
+		}
+		if q.durable != durable || q.autoDelete != autoDelete || q.exclusive != exclusive || !equivalentArgs(q.args, args) {
+			return amqp091.Queue{}, t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - inequivalent arg for queue '%s'", name)
+		}
+
+		t.expire(q)
+		return amqp091.Queue{Name: q.name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+	}
+
+	if name == "" {
+		name = t.generateName("amq.gen-")
+	} else if strings.HasPrefix(name, "amq.") {
+		return amqp091.Queue{}, t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - queue name '%s' contains reserved prefix 'amq.*'", name)
+	}
+
+	q := &memoryQueue{name: name, durable: durable, autoDelete: autoDelete, exclusive: exclusive, args: args}
+	if exclusive {
+		q.owner = ch.connection
+	}
+	t.queues[name] = q
+
+	return amqp091.Queue{Name: name}, nil
+}
+
+// equivalentArgs compares the x- arguments of two declarations of the same queue
+func equivalentArgs(a, b amqp091.Table) bool {
+	for _, pair := range [][2]amqp091.Table{{a, b}, {b, a}} {
+		for key, value := range pair[0] {
+			if strings.HasPrefix(key, "x-") && fmt.Sprint(pair[1][key]) != fmt.Sprint(value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (ch *memoryChannel) QueueBind(name, key, exchange string, noWait bool, args amqp091.Table) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	if _, ok := t.queues[name]; !ok {
+		return t.fail(ch, amqp091.NotFound, "NOT_FOUND - no queue '%s'", name)
+	}
+	e, ok := t.exchanges[exchange]
+	if !ok {
+		return t.fail(ch, amqp091.NotFound, "NOT_FOUND - no exchange '%s'", exchange)
+	}
+	if exchange == "" {
+		return t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - operation not permitted on the default exchange")
+	}
+
+	for _, binding := range e.bindings {
+		if binding.destination == "" && binding.queue == name && binding.key == key && fmt.Sprint(binding.args) == fmt.Sprint(args) {
+			return nil
+		}
+	}
+	e.bindings = append(e.bindings, memoryBinding{queue: name, key: key, args: args})
+
+	return nil
+}
+
+func (ch *memoryChannel) QueueUnbind(name, key, exchange string, args amqp091.Table) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	e, ok := t.exchanges[exchange]
+	if !ok {
+		return t.fail(ch, amqp091.NotFound, "NOT_FOUND - no exchange '%s'", exchange)
+	}
+
+	kept := e.bindings[:0]
+	for _, binding := range e.bindings {
+		if binding.queue != name || binding.key != key || fmt.Sprint(binding.args) != fmt.Sprint(args) {
+			kept = append(kept, binding)
+		}
+	}
+	e.bindings = kept
+
+	return nil
+}
+
+func (ch *memoryChannel) QueuePurge(name string, noWait bool) (int, error) {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return 0, amqp091.ErrClosed
+	}
+
+	q, ok := t.queues[name]
+	if !ok {
+		return 0, t.fail(ch, amqp091.NotFound, "NOT_FOUND - no queue '%s'", name)
+	}
+
+	purged := len(q.messages)
+	q.messages = nil
+
+	return purged, nil
+}
+
+func (ch *memoryChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return 0, amqp091.ErrClosed
+	}
+
+	q, ok := t.queues[name]
+	if !ok {
+		return 0, nil
+	}
+	if ifUnused && len(q.consumers) > 0 {
+		return 0, t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - queue '%s' in use", name)
+	}
+	if ifEmpty && len(q.messages) > 0 {
+		return 0, t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - queue '%s' not empty", name)
+	}
+
+	return t.deleteQueue(q), nil
+}
+
+func (ch *memoryChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	if global {
+		ch.globalPrefetch = prefetchCount
+	} else {
+		ch.prefetch = prefetchCount
+	}
+
+	return nil
+}
+
+func (ch *memoryChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error) {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return nil, amqp091.ErrClosed
+	}
+
+	q, ok := t.queues[queue]
+	if !ok {
+		return nil, t.fail(ch, amqp091.NotFound, "NOT_FOUND - no queue '%s'", queue)
+	}
+	if q.exclusive && q.owner != ch.connection {
+		return nil, t.fail(ch, amqp091.ResourceLocked, "RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s'", queue)
+	}
+	for _, other := range q.consumers {
+		if exclusive || other.exclusive {
+			return nil, t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - queue '%s' in exclusive use", queue)
+		}
+	}
+
+	if consumer == "" {
+		consumer = t.generateName("amq.ctag-")
+	}
+	if _, ok := ch.consumers[consumer]; ok {
+		return nil, t.fail(ch, amqp091.NotAllowed, "NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumer)
+	}
+
+	c := &memoryConsumer{
+		tag:        consumer,
+		queue:      q,
+		channel:    ch,
+		autoAck:    autoAck,
+		exclusive:  exclusive,
+		prefetch:   ch.prefetch,
+		deliveries: make(chan amqp091.Delivery),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	ch.consumers[consumer] = c
+	q.consumers = append(q.consumers, c)
+	q.hadConsumers = true
+
+	go c.pump(t)
+	t.dispatch(q)
+
+	return c.deliveries, nil
+}
+
+func (ch *memoryChannel) Cancel(consumer string, noWait bool) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	if c, ok := ch.consumers[consumer]; ok {
+		t.cancel(c, false)
+		t.dispatch(c.queue)
+	}
+
+	return nil
+}
+
+func (ch *memoryChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	e, ok := t.exchanges[exchange]
+	if !ok {
+		return t.fail(ch, amqp091.NotFound, "NOT_FOUND - no exchange '%s'", exchange)
+	}
+	if e.internal {
+		return t.fail(ch, amqp091.AccessRefused, "ACCESS_REFUSED - cannot publish to internal exchange '%s'", exchange)
+	}
+
+	var queues []*memoryQueue
+	if e.kind == delayedExchange {
+		// The plugin routes the message once x-delay passed and never returns it
+		t.delay(e, key, clonePublishing(msg))
+		mandatory = false
+	} else {
+		queues = t.route(e, key, msg.Headers)
+	}
+	for _, q := range queues {
+		t.enqueue(q, &memoryMessage{exchange: exchange, routingKey: key, publishing: clonePublishing(msg)})
+	}
+
+	if len(queues) == 0 && mandatory {
+		returned := amqp091.Return{
+			ReplyCode:       amqp091.NoRoute,
+			ReplyText:       "NO_ROUTE",
+			Exchange:        exchange,
+			RoutingKey:      key,
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			Headers:         msg.Headers,
+			DeliveryMode:    msg.DeliveryMode,
+			Priority:        msg.Priority,
+			CorrelationId:   msg.CorrelationId,
+			ReplyTo:         msg.ReplyTo,
+			Expiration:      msg.Expiration,
+			MessageId:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Type:            msg.Type,
+			UserId:          msg.UserId,
+			AppId:           msg.AppId,
+			Body:            msg.Body,
+		}
+		ch.notify(func() {
+			for _, listener := range ch.returnListeners {
+				listener <- returned
+			}
+		})
+	}
+
+	if ch.confirm {
+		ch.published++
+		confirmation := amqp091.Confirmation{DeliveryTag: ch.published, Ack: true}
+		ch.notify(func() {
+			for _, listener := range ch.confirmListeners {
+				listener <- confirmation
+			}
+		})
+	}
+
+	return nil
+}
+
+func (ch *memoryChannel) Confirm(noWait bool) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+	ch.confirm = true
+
+	return nil
+}
+
+func (ch *memoryChannel) GetNextPublishSeqNo() uint64 {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	return ch.published + 1
+}
+
+func (ch *memoryChannel) NotifyPublish(confirm chan amqp091.Confirmation) chan amqp091.Confirmation {
+	ch.notifyMutex.Lock()
+	defer ch.notifyMutex.Unlock()
+
+	if ch.listenersClosed {
+		close(confirm)
+	} else {
+		ch.confirmListeners = append(ch.confirmListeners, confirm)
+	}
+
+	return confirm
+}
+
+func (ch *memoryChannel) NotifyReturn(returns chan amqp091.Return) chan amqp091.Return {
+	ch.notifyMutex.Lock()
+	defer ch.notifyMutex.Unlock()
+
+	if ch.listenersClosed {
+		close(returns)
+	} else {
+		ch.returnListeners = append(ch.returnListeners, returns)
+	}
+
+	return returns
+}
+
+func (ch *memoryChannel) NotifyCancel(cancellations chan string) chan string {
+	ch.notifyMutex.Lock()
+	defer ch.notifyMutex.Unlock()
+
+	if ch.listenersClosed {
+		close(cancellations)
+	} else {
+		ch.cancelListeners = append(ch.cancelListeners, cancellations)
+	}
+
+	return cancellations
+}
+
+func (ch *memoryChannel) NotifyClose(receiver chan *amqp091.Error) chan *amqp091.Error {
+	ch.notifyMutex.Lock()
+	defer ch.notifyMutex.Unlock()
+
+	if ch.listenersClosed {
+		close(receiver)
+	} else {
+		ch.closeListeners = append(ch.closeListeners, receiver)
+	}
+
+	return receiver
+}
+
+func (ch *memoryChannel) IsClosed() bool {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	return ch.closed
+}
+
+func (ch *memoryChannel) Close() error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	t.closeChannel(ch, nil)
+
+	return nil
+}
+
+// Ack implements amqp091.Acknowledger for the deliveries of the channel
+func (ch *memoryChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, func(unacked *memoryUnacked) {})
+}
+
+// Nack implements amqp091.Acknowledger for the deliveries of the channel
+func (ch *memoryChannel) Nack(tag uint64, multiple, requeue bool) error {
+	t := ch.transport
+
+	return ch.settle(tag, multiple, func(unacked *memoryUnacked) {
+		if requeue {
+			t.requeue(unacked)
+		} else {
+			t.deadLetter(unacked.queue, unacked.message, "rejected")
+		}
+	})
+}
+
+// Reject implements amqp091.Acknowledger for the deliveries of the channel
+func (ch *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+// settle removes the deliveries up to tag (or only tag) from the unacknowledged ones and hands each to settled
+func (ch *memoryChannel) settle(tag uint64, multiple bool, settled func(*memoryUnacked)) error {
+	t := ch.transport
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if ch.closed {
+		return amqp091.ErrClosed
+	}
+
+	var tags []uint64
+	if multiple {
+		// A multiple acknowledgement of tag 0 settles every outstanding delivery
+		upper := tag
+		if tag == 0 {
+			upper = ch.deliveryTag
+		}
+
+		for candidate := uint64(1); candidate <= upper; candidate++ {
+			if _, ok := ch.unacked[candidate]; ok {
+				tags = append(tags, candidate)
+			}
+		}
+	} else if _, ok := ch.unacked[tag]; ok {
+		tags = []uint64{tag}
+	}
+
+	if len(tags) == 0 && !multiple {
+		return t.fail(ch, amqp091.PreconditionFailed, "PRECONDITION_FAILED - unknown delivery tag %d", tag)
+	}
+
+	queues := make(map[*memoryQueue]bool)
+	for _, candidate := range tags {
+		unacked := ch.unacked[candidate]
+		delete(ch.unacked, candidate)
+		unacked.consumer.unacked--
+
+		settled(unacked)
+		queues[unacked.queue] = true
+	}
+
+	for q := range queues {
+		t.dispatch(q)
+	}
+
+	return nil
+}
@@ -0,0 +1,386 @@
+package volta
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func openChannel(t *testing.T, transport *MemoryTransport) Channel {
+	t.Helper()
+
+	connection, err := transport.Dial("")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	channel, err := connection.Channel()
+	if err != nil {
+		t.Fatalf("Channel() error = %v", err)
+	}
+
+	return channel
+}
+
+func receive(t *testing.T, messages <-chan amqp091.Delivery) amqp091.Delivery {
+	t.Helper()
+
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return amqp091.Delivery{}
+	}
+}
+
+func publish(t *testing.T, channel Channel, exchange, key string, msg amqp091.Publishing) {
+	t.Helper()
+
+	if err := channel.PublishWithContext(context.Background(), exchange, key, false, false, msg); err != nil {
+		t.Fatalf("PublishWithContext() error = %v", err)
+	}
+}
+
+func TestMemoryTransport_routing(t *testing.T) {
+	tests := []struct {
+		kind     string
+		binding  string
+		args     amqp091.Table
+		key      string
+		headers  amqp091.Table
+		expected int
+	}{
+		{kind: "direct", binding: "orders", key: "orders", expected: 1},
+		{kind: "direct", binding: "orders", key: "users", expected: 0},
+		{kind: "fanout", binding: "", key: "anything", expected: 1},
+		{kind: "topic", binding: "orders.*.created", key: "orders.eu.created", expected: 1},
+		{kind: "topic", binding: "orders.#", key: "orders", expected: 1},
+		{kind: "topic", binding: "orders.*", key: "orders.eu.created", expected: 0},
+		{kind: "headers", args: amqp091.Table{"x-match": "all", "region": "eu", "kind": "order"}, headers: amqp091.Table{"region": "eu", "kind": "order"}, expected: 1},
+		{kind: "headers", args: amqp091.Table{"x-match": "all", "region": "eu", "kind": "order"}, headers: amqp091.Table{"region": "eu"}, expected: 0},
+		{kind: "headers", args: amqp091.Table{"x-match": "any", "region": "eu", "kind": "order"}, headers: amqp091.Table{"region": "eu"}, expected: 1},
+	}
+
+	for _, tt := range tests {
+		transport := NewMemoryTransport()
+		channel := openChannel(t, transport)
+
+		if err := channel.ExchangeDeclare("test", tt.kind, false, false, false, false, nil); err != nil {
+			t.Fatalf("ExchangeDeclare() error = %v", err)
+		}
+		if _, err := channel.QueueDeclare("test", false, false, false, false, nil); err != nil {
+			t.Fatalf("QueueDeclare() error = %v", err)
+		}
+		if err := channel.QueueBind("test", tt.binding, "test", false, tt.args); err != nil {
+			t.Fatalf("QueueBind() error = %v", err)
+		}
+
+		publish(t, channel, "test", tt.key, amqp091.Publishing{Headers: tt.headers})
+
+		if length := transport.QueueLength("test"); length != tt.expected {
+			t.Errorf("%s exchange bound with %q routed %q to %d queues, expected %d", tt.kind, tt.binding, tt.key, length, tt.expected)
+		}
+	}
+}
+
+func TestMemoryTransport_exchangeBind(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	channel.ExchangeDeclare("source", "fanout", false, false, false, false, nil)
+	channel.ExchangeDeclare("destination", "topic", false, false, false, false, nil)
+	channel.QueueDeclare("orders", false, false, false, false, nil)
+	channel.QueueDeclare("users", false, false, false, false, nil)
+	channel.QueueBind("orders", "orders.#", "destination", false, nil)
+	channel.QueueBind("users", "users.#", "destination", false, nil)
+
+	if err := channel.ExchangeBind("destination", "", "source", false, nil); err != nil {
+		t.Fatalf("ExchangeBind() error = %v", err)
+	}
+	// A cycle is followed once
+	if err := channel.ExchangeBind("source", "#", "destination", false, nil); err != nil {
+		t.Fatalf("ExchangeBind() error = %v", err)
+	}
+
+	publish(t, channel, "source", "orders.created", amqp091.Publishing{})
+
+	if orders, users := transport.QueueLength("orders"), transport.QueueLength("users"); orders != 1 || users != 0 {
+		t.Errorf("queue lengths are orders %d and users %d, expected 1 and 0", orders, users)
+	}
+}
+
+func TestMemoryTransport_delayedExchange(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	if err := channel.ExchangeDeclare("delayed", "x-delayed-message", false, false, false, false, nil); err == nil {
+		t.Error("ExchangeDeclare() declared a delayed exchange without x-delayed-type")
+	}
+
+	channel = openChannel(t, transport)
+	if err := channel.ExchangeDeclare("delayed", "x-delayed-message", false, false, false, false, amqp091.Table{"x-delayed-type": "direct"}); err != nil {
+		t.Fatalf("ExchangeDeclare() error = %v", err)
+	}
+	channel.QueueDeclare("orders", false, false, false, false, nil)
+	channel.QueueBind("orders", "orders", "delayed", false, nil)
+
+	publish(t, channel, "delayed", "orders", amqp091.Publishing{Headers: amqp091.Table{"x-delay": 30}})
+
+	if length := transport.QueueLength("orders"); length != 0 {
+		t.Errorf("queue length is %d before the delay passed, expected 0", length)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for transport.QueueLength("orders") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("delayed message was not routed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemoryTransport_requeue(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	channel.QueueDeclare("test", false, false, false, false, nil)
+	publish(t, channel, "", "test", amqp091.Publishing{Body: []byte("test")})
+
+	messages, err := channel.Consume("test", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	message := receive(t, messages)
+	if message.Redelivered {
+		t.Error("first delivery is marked redelivered")
+	}
+	if err := message.Nack(false, true); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+
+	message = receive(t, messages)
+	if !message.Redelivered {
+		t.Error("requeued delivery is not marked redelivered")
+	}
+	if err := message.Ack(false); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if err := message.Ack(false); err == nil {
+		t.Error("acknowledging twice should fail")
+	}
+}
+
+func TestMemoryTransport_unackedRequeuedOnClose(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	channel.QueueDeclare("test", false, false, false, false, nil)
+	publish(t, channel, "", "test", amqp091.Publishing{})
+
+	consumer := openChannel(t, transport)
+	messages, _ := consumer.Consume("test", "", false, false, false, false, nil)
+	receive(t, messages)
+
+	if length := transport.QueueLength("test"); length != 0 {
+		t.Fatalf("queue length is %d while the message is unacknowledged, expected 0", length)
+	}
+
+	consumer.Close()
+
+	if length := transport.QueueLength("test"); length != 1 {
+		t.Errorf("queue length is %d after the channel closed, expected 1", length)
+	}
+}
+
+func TestMemoryTransport_deadLetter(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	channel.ExchangeDeclare("dlx", "fanout", false, false, false, false, nil)
+	channel.QueueDeclare("dead", false, false, false, false, nil)
+	channel.QueueBind("dead", "", "dlx", false, nil)
+	channel.QueueDeclare("test", false, false, false, false, amqp091.Table{
+		"x-dead-letter-exchange": "dlx",
+		"x-message-ttl":          int32(20),
+	})
+
+	publish(t, channel, "", "test", amqp091.Publishing{Body: []byte("expired")})
+
+	dead, _ := channel.Consume("dead", "", true, false, false, false, nil)
+	message := receive(t, dead)
+
+	if string(message.Body) != "expired" {
+		t.Errorf("Body is %s, expected expired", message.Body)
+	}
+	if reason := message.Headers["x-first-death-reason"]; reason != "expired" {
+		t.Errorf("x-first-death-reason is %v, expected expired", reason)
+	}
+
+	channel.QueueDeclare("rejected", false, false, false, false, amqp091.Table{"x-dead-letter-exchange": "dlx"})
+	publish(t, channel, "", "rejected", amqp091.Publishing{Body: []byte("rejected")})
+
+	messages, _ := channel.Consume("rejected", "", false, false, false, false, nil)
+	receive(t, messages).Reject(false)
+
+	message = receive(t, dead)
+	if reason := message.Headers["x-first-death-reason"]; reason != "rejected" {
+		t.Errorf("x-first-death-reason is %v, expected rejected", reason)
+	}
+	if _, ok := message.Headers["x-death"].([]interface{}); !ok {
+		t.Error("x-death header is missing")
+	}
+}
+
+func TestMemoryTransport_maxLength(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	channel.QueueDeclare("test", false, false, false, false, amqp091.Table{"x-max-length": int32(2)})
+	for _, body := range []string{"1", "2", "3"} {
+		publish(t, channel, "", "test", amqp091.Publishing{Body: []byte(body)})
+	}
+
+	if length := transport.QueueLength("test"); length != 2 {
+		t.Fatalf("queue length is %d, expected 2", length)
+	}
+
+	messages, _ := channel.Consume("test", "", true, false, false, false, nil)
+	if message := receive(t, messages); string(message.Body) != "2" {
+		t.Errorf("head of the queue is %s, expected the oldest message to be dropped", message.Body)
+	}
+}
+
+func TestMemoryTransport_confirmsAndReturns(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	if err := channel.Confirm(false); err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	confirms := channel.NotifyPublish(make(chan amqp091.Confirmation, 1))
+	returns := channel.NotifyReturn(make(chan amqp091.Return, 1))
+
+	if err := channel.PublishWithContext(context.Background(), "", "missing", true, false, amqp091.Publishing{}); err != nil {
+		t.Fatalf("PublishWithContext() error = %v", err)
+	}
+
+	select {
+	case r := <-returns:
+		if r.ReplyCode != amqp091.NoRoute {
+			t.Errorf("ReplyCode is %d, expected %d", r.ReplyCode, amqp091.NoRoute)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unroutable mandatory message was not returned")
+	}
+
+	select {
+	case confirm := <-confirms:
+		if !confirm.Ack || confirm.DeliveryTag != 1 {
+			t.Errorf("confirmation is %+v, expected an ack of tag 1", confirm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("publish was not confirmed")
+	}
+}
+
+func TestMemoryTransport_queueDeleteCancelsConsumers(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	channel.QueueDeclare("test", false, false, false, false, nil)
+	cancels := channel.NotifyCancel(make(chan string, 1))
+	messages, _ := channel.Consume("test", "tag", false, false, false, false, nil)
+
+	if _, err := channel.QueueDelete("test", false, false, false); err != nil {
+		t.Fatalf("QueueDelete() error = %v", err)
+	}
+
+	select {
+	case tag := <-cancels:
+		if tag != "tag" {
+			t.Errorf("cancelled consumer is %s, expected tag", tag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("consumer was not cancelled")
+	}
+
+	if _, ok := <-messages; ok {
+		t.Error("deliveries of a cancelled consumer are still open")
+	}
+}
+
+func TestMemoryTransport_channelException(t *testing.T) {
+	transport := NewMemoryTransport()
+	channel := openChannel(t, transport)
+
+	err := channel.QueueBind("missing", "", "amq.direct", false, nil)
+
+	var amqpErr *amqp091.Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != amqp091.NotFound {
+		t.Fatalf("QueueBind() error = %v, expected NOT_FOUND", err)
+	}
+	if !channel.IsClosed() {
+		t.Error("channel is still open after a channel exception")
+	}
+}
+
+func TestApp_reconnect(t *testing.T) {
+	transport := NewMemoryTransport()
+	app := New(Config{Transport: transport, DisableLogging: true})
+
+	app.AddExchanges(Exchange{Name: "test", Type: "topic"})
+	app.AddQueue(Queue{Name: "test", Exchange: "test", RoutingKey: "test"})
+
+	received := make(chan string, 1)
+	app.AddConsumer("test", func(ctx *Ctx) error {
+		received <- string(ctx.Body())
+		return ctx.Ack(false)
+	})
+
+	reconnected := make(chan struct{})
+	app.OnReconnect(func(int) { close(reconnected) })
+
+	go app.Listen()
+	defer app.Close()
+
+	waitHealthy(t, app)
+	transport.CloseConnections()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("application did not reconnect")
+	}
+	waitHealthy(t, app)
+
+	if err := app.Publish("test", "test", []byte("after reconnect")); err != nil {
+		t.Fatalf("App.Publish() error = %v", err)
+	}
+
+	select {
+	case body := <-received:
+		if body != "after reconnect" {
+			t.Errorf("Body is %s, expected after reconnect", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("consumer was not restarted after reconnecting")
+	}
+}
+
+func waitHealthy(t *testing.T, app *App) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for app.Health().Status != HealthOk {
+		if time.Now().After(deadline) {
+			t.Fatalf("application is %s, expected %s", app.Health().Status, HealthOk)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
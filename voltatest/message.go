@@ -0,0 +1,135 @@
+package voltatest
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Message builds the delivery a handler is run with
+type Message struct {
+	delivery amqp091.Delivery
+	queue    string
+	locals   map[string]interface{}
+}
+
+// NewMessage creates a message with the given body
+func NewMessage(body []byte) *Message {
+	return &Message{delivery: amqp091.Delivery{
+		ContentType: "text/plain",
+		DeliveryTag: 1,
+		Body:        body,
+	}}
+}
+
+// NewJSONMessage creates a message with data marshaled to JSON as body, it panics if data cannot be marshaled
+func NewJSONMessage(data interface{}) *Message {
+	body, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return NewMessage(body).ContentType("application/json")
+}
+
+// Header sets a header of the message
+func (m *Message) Header(key string, value interface{}) *Message {
+	if m.delivery.Headers == nil {
+		m.delivery.Headers = make(amqp091.Table)
+	}
+
+	m.delivery.Headers[key] = value
+	return m
+}
+
+func (m *Message) ContentType(contentType string) *Message {
+	m.delivery.ContentType = contentType
+	return m
+}
+
+func (m *Message) CorrelationId(correlationId string) *Message {
+	m.delivery.CorrelationId = correlationId
+	return m
+}
+
+// ReplyTo sets the queue the handler replies to
+func (m *Message) ReplyTo(replyTo string) *Message {
+	m.delivery.ReplyTo = replyTo
+	return m
+}
+
+func (m *Message) MessageId(messageId string) *Message {
+	m.delivery.MessageId = messageId
+	return m
+}
+
+func (m *Message) Type(kind string) *Message {
+	m.delivery.Type = kind
+	return m
+}
+
+func (m *Message) UserId(userId string) *Message {
+	m.delivery.UserId = userId
+	return m
+}
+
+func (m *Message) AppId(appId string) *Message {
+	m.delivery.AppId = appId
+	return m
+}
+
+func (m *Message) Timestamp(timestamp time.Time) *Message {
+	m.delivery.Timestamp = timestamp
+	return m
+}
+
+// Expiration sets the expiration property, in milliseconds
+func (m *Message) Expiration(expiration string) *Message {
+	m.delivery.Expiration = expiration
+	return m
+}
+
+func (m *Message) Priority(priority uint8) *Message {
+	m.delivery.Priority = priority
+	return m
+}
+
+// Exchange sets the exchange the message was published to
+func (m *Message) Exchange(exchange string) *Message {
+	m.delivery.Exchange = exchange
+	return m
+}
+
+// RoutingKey sets the routing key the message was published with
+func (m *Message) RoutingKey(routingKey string) *Message {
+	m.delivery.RoutingKey = routingKey
+	return m
+}
+
+// Queue sets the queue the message was consumed from, returned by Ctx.Queue
+func (m *Message) Queue(queue string) *Message {
+	m.queue = queue
+	return m
+}
+
+// Redelivered marks the message as delivered before
+func (m *Message) Redelivered() *Message {
+	m.delivery.Redelivered = true
+	return m
+}
+
+// Local sets a local of the context, as an earlier middleware would with Ctx.Locals
+func (m *Message) Local(key string, value interface{}) *Message {
+	if m.locals == nil {
+		m.locals = make(map[string]interface{})
+	}
+
+	m.locals[key] = value
+	return m
+}
+
+// Delivery returns the delivery built so far
+func (m *Message) Delivery() amqp091.Delivery {
+	return m.delivery
+}
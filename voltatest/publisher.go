@@ -0,0 +1,110 @@
+package voltatest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+)
+
+// Published is a message published through a Publisher
+type Published struct {
+	Exchange   string
+	RoutingKey string
+	Mandatory  bool
+	amqp091.Publishing
+}
+
+// Publisher is a volta.Transport recording every message published through it.
+// Messages are passed on to the wrapped transport, so confirms, returns and RPC replies keep working.
+type Publisher struct {
+	transport volta.Transport
+
+	mutex    sync.Mutex
+	messages []Published
+}
+
+// NewPublisher creates a recording publisher wrapping transport, an in-memory broker when omitted
+func NewPublisher(transport ...volta.Transport) *Publisher {
+	if len(transport) < 1 || transport[0] == nil {
+		return &Publisher{transport: volta.NewMemoryTransport()}
+	}
+
+	return &Publisher{transport: transport[0]}
+}
+
+func (p *Publisher) Dial(url string) (volta.Connection, error) {
+	connection, err := p.transport.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return recordingConnection{Connection: connection, publisher: p}, nil
+}
+
+// Messages returns the messages published so far, in order
+func (p *Publisher) Messages() []Published {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]Published(nil), p.messages...)
+}
+
+// Reset forgets the messages published so far
+func (p *Publisher) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.messages = nil
+}
+
+func (p *Publisher) record(message Published) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.messages = append(p.messages, message)
+}
+
+func (p *Publisher) since(n int) []Published {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if n > len(p.messages) {
+		return nil
+	}
+
+	return append([]Published(nil), p.messages[n:]...)
+}
+
+func (p *Publisher) len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.messages)
+}
+
+type recordingConnection struct {
+	volta.Connection
+	publisher *Publisher
+}
+
+func (c recordingConnection) Channel() (volta.Channel, error) {
+	channel, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return recordingChannel{Channel: channel, publisher: c.publisher}, nil
+}
+
+type recordingChannel struct {
+	volta.Channel
+	publisher *Publisher
+}
+
+func (c recordingChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	c.publisher.record(Published{Exchange: exchange, RoutingKey: key, Mandatory: mandatory, Publishing: msg})
+
+	return c.Channel.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
@@ -0,0 +1,217 @@
+// Package voltatest runs volta handlers and middleware chains without a broker
+// and captures how they settled the message, what they replied and what they published.
+package voltatest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/volta-dev/volta"
+)
+
+// Tester runs handlers with an application publishing through a recording Publisher
+type Tester struct {
+	App       *volta.App
+	Publisher *Publisher
+
+	connection volta.Connection
+	channel    volta.Channel
+}
+
+// New creates a tester, config is passed to volta.New with logging disabled unless a Logger is set.
+// The transport of config, an in-memory broker when nil, is wrapped by the Publisher.
+// It panics if no channel can be opened with the transport.
+func New(config ...volta.Config) *Tester {
+	var cfg volta.Config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.Logger == nil {
+		cfg.DisableLogging = true
+	}
+
+	publisher := NewPublisher(cfg.Transport)
+	cfg.Transport = publisher
+
+	connection, err := publisher.Dial(cfg.RabbitMQ)
+	if err != nil {
+		panic(fmt.Sprintf("voltatest: Problem with connecting: %v", err))
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		panic(fmt.Sprintf("voltatest: Problem with opening a channel: %v", err))
+	}
+
+	return &Tester{App: volta.New(cfg), Publisher: publisher, connection: connection, channel: channel}
+}
+
+// Declare declares exchanges on the broker of the tester, publishing to an undeclared exchange fails
+func (t *Tester) Declare(exchanges ...volta.Exchange) error {
+	channel, err := t.connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	for _, exchange := range exchanges {
+		err := channel.ExchangeDeclare(exchange.Name, exchange.Type, exchange.Durable, exchange.AutoDelete, exchange.Internal, exchange.NoWait, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ctx creates the context of the message, e.g. to call a single handler directly
+func (t *Tester) Ctx(message *Message, handlers ...volta.Handler) *volta.Ctx {
+	return t.ctx(message, &acknowledger{}, handlers...)
+}
+
+func (t *Tester) ctx(message *Message, acknowledger *acknowledger, handlers ...volta.Handler) *volta.Ctx {
+	if message == nil {
+		message = NewMessage(nil)
+	}
+
+	delivery := message.Delivery()
+	delivery.Acknowledger = acknowledger
+
+	ctx := volta.NewCtx(t.App, t.channel, message.queue, delivery, handlers...)
+	for key, value := range message.locals {
+		ctx.Locals(key, value)
+	}
+
+	return ctx
+}
+
+// Run runs the chain of handlers with the message and returns how it went.
+// A panic escaping the chain is recovered and reported in Result.Panic.
+func (t *Tester) Run(message *Message, handlers ...volta.Handler) *Result {
+	acknowledger := &acknowledger{}
+	ctx := t.ctx(message, acknowledger, handlers...)
+	published := t.Publisher.len()
+
+	result := &Result{Ctx: ctx}
+	result.Panic, result.Err = run(ctx, handlers)
+
+	result.Settlement = ctx.Settlement()
+	result.Multiple, result.Requeue = acknowledger.outcome()
+	result.Published = t.Publisher.since(published)
+
+	for i := range result.Published {
+		if isReply(ctx.Delivery, result.Published[i]) {
+			result.Reply = &result.Published[i]
+			break
+		}
+	}
+
+	return result
+}
+
+// Run runs the chain of handlers with the message using a new Tester
+func Run(message *Message, handlers ...volta.Handler) *Result {
+	return New().Run(message, handlers...)
+}
+
+func run(ctx *volta.Ctx, handlers []volta.Handler) (recovered interface{}, err error) {
+	if len(handlers) < 1 {
+		return nil, nil
+	}
+
+	defer func() {
+		recovered = recover()
+	}()
+
+	return nil, handlers[0](ctx)
+}
+
+func isReply(delivery amqp091.Delivery, message Published) bool {
+	return delivery.ReplyTo != "" &&
+		message.Exchange == "" &&
+		message.RoutingKey == delivery.ReplyTo &&
+		message.CorrelationId == delivery.CorrelationId
+}
+
+// Result is the outcome of running a chain of handlers
+type Result struct {
+	// Ctx the handlers were run with, e.g. to read the locals they set
+	Ctx *volta.Ctx
+
+	// Err returned by the chain
+	Err error
+
+	// Panic recovered from the chain, nil if it did not panic
+	Panic interface{}
+
+	// Settlement of the message, empty if it was not settled
+	Settlement volta.Settlement
+
+	// Multiple is set when the message was settled together with the earlier ones
+	Multiple bool
+
+	// Requeue is set when the message was negatively acknowledged or rejected with requeue
+	Requeue bool
+
+	// Reply published to the reply_to queue of the message, nil if there was none
+	Reply *Published
+
+	// Published holds every message published while the chain ran, the reply included
+	Published []Published
+}
+
+func (r *Result) Acked() bool {
+	return r.Settlement == volta.SettlementAck
+}
+
+func (r *Result) Nacked() bool {
+	return r.Settlement == volta.SettlementNack
+}
+
+func (r *Result) Rejected() bool {
+	return r.Settlement == volta.SettlementReject
+}
+
+func (r *Result) Replied() bool {
+	return r.Reply != nil
+}
+
+// acknowledger records the first settlement of a delivery
+type acknowledger struct {
+	mutex    sync.Mutex
+	settled  bool
+	multiple bool
+	requeue  bool
+}
+
+func (a *acknowledger) Ack(_ uint64, multiple bool) error {
+	return a.settle(multiple, false)
+}
+
+func (a *acknowledger) Nack(_ uint64, multiple, requeue bool) error {
+	return a.settle(multiple, requeue)
+}
+
+func (a *acknowledger) Reject(_ uint64, requeue bool) error {
+	return a.settle(false, requeue)
+}
+
+func (a *acknowledger) settle(multiple, requeue bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.settled {
+		return amqp091.ErrClosed
+	}
+
+	a.settled, a.multiple, a.requeue = true, multiple, requeue
+	return nil
+}
+
+func (a *acknowledger) outcome() (multiple, requeue bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.multiple, a.requeue
+}
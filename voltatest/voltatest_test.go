@@ -0,0 +1,162 @@
+package voltatest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/volta-dev/volta"
+	"github.com/volta-dev/volta/middlewares/limiter"
+	"github.com/volta-dev/volta/middlewares/recover"
+)
+
+func TestRun_settlement(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  volta.Handler
+		expected volta.Settlement
+		requeue  bool
+	}{
+		{"ack", func(c *volta.Ctx) error { return c.Ack(false) }, volta.SettlementAck, false},
+		{"nack", func(c *volta.Ctx) error { return c.Nack(false, true) }, volta.SettlementNack, true},
+		{"reject", func(c *volta.Ctx) error { return c.Reject(false) }, volta.SettlementReject, false},
+		{"none", func(c *volta.Ctx) error { return nil }, "", false},
+	}
+
+	for _, test := range tests {
+		result := Run(NewMessage([]byte("test")), test.handler)
+
+		if result.Err != nil {
+			t.Errorf("%s: Err = %v", test.name, result.Err)
+		}
+		if result.Settlement != test.expected {
+			t.Errorf("%s: Settlement is %q, expected %q", test.name, result.Settlement, test.expected)
+		}
+		if result.Requeue != test.requeue {
+			t.Errorf("%s: Requeue is %v, expected %v", test.name, result.Requeue, test.requeue)
+		}
+	}
+}
+
+func TestRun_reply(t *testing.T) {
+	message := NewJSONMessage(volta.Map{"id": 1}).ReplyTo("replies").CorrelationId("42")
+
+	result := Run(message, func(c *volta.Ctx) error {
+		var body map[string]int
+		if err := c.BindJSON(&body); err != nil {
+			return err
+		}
+
+		return c.ReplyJSON(volta.Map{"id": body["id"], "name": "volta"})
+	})
+
+	if result.Err != nil {
+		t.Fatalf("Err = %v", result.Err)
+	}
+	if !result.Acked() {
+		t.Errorf("Settlement is %q, expected the reply to acknowledge the message", result.Settlement)
+	}
+	if !result.Replied() {
+		t.Fatal("no reply captured")
+	}
+	if string(result.Reply.Body) != `{"id":1,"name":"volta"}` {
+		t.Errorf("Reply body is %s", result.Reply.Body)
+	}
+	if result.Reply.ContentType != "application/json" || result.Reply.CorrelationId != "42" {
+		t.Errorf("Reply properties are %q / %q", result.Reply.ContentType, result.Reply.CorrelationId)
+	}
+}
+
+func TestRun_published(t *testing.T) {
+	tester := New()
+	if err := tester.Declare(volta.Exchange{Name: "orders", Type: "topic"}); err != nil {
+		t.Fatalf("Declare() error = %v", err)
+	}
+
+	result := tester.Run(NewMessage([]byte("order")).Header("x-tenant", "acme"), func(c *volta.Ctx) error {
+		tenant, _ := c.Delivery.Headers["x-tenant"].(string)
+		if err := c.App.Publish("orders.created."+tenant, "orders", c.Body()); err != nil {
+			return err
+		}
+
+		return c.Ack(false)
+	})
+
+	if result.Err != nil {
+		t.Fatalf("Err = %v", result.Err)
+	}
+	if len(result.Published) != 1 {
+		t.Fatalf("%d messages published, expected 1", len(result.Published))
+	}
+
+	published := result.Published[0]
+	if published.Exchange != "orders" || published.RoutingKey != "orders.created.acme" || string(published.Body) != "order" {
+		t.Errorf("Published %s to %s with %s", published.Body, published.Exchange, published.RoutingKey)
+	}
+	if result.Replied() {
+		t.Error("a publish to another exchange was captured as the reply")
+	}
+	if len(tester.Publisher.Messages()) != 1 {
+		t.Errorf("Publisher recorded %d messages, expected 1", len(tester.Publisher.Messages()))
+	}
+}
+
+func TestRun_locals(t *testing.T) {
+	message := NewMessage(nil).Local("user", "alice").Queue("users")
+
+	result := Run(message, func(c *volta.Ctx) error {
+		if c.Queue() != "users" {
+			t.Errorf("Queue is %s, expected users", c.Queue())
+		}
+
+		c.Locals("greeting", "hello "+c.Locals("user").(string))
+		return c.Ack(false)
+	})
+
+	if greeting := result.Ctx.Locals("greeting"); greeting != "hello alice" {
+		t.Errorf("greeting local is %v, expected hello alice", greeting)
+	}
+}
+
+func TestRun_panic(t *testing.T) {
+	result := Run(NewMessage(nil), func(c *volta.Ctx) error {
+		panic("broken handler")
+	})
+
+	if result.Panic != "broken handler" {
+		t.Errorf("Panic is %v, expected broken handler", result.Panic)
+	}
+}
+
+func TestRun_recover(t *testing.T) {
+	result := Run(NewMessage(nil), recover.New(), func(c *volta.Ctx) error {
+		panic(errors.New("broken handler"))
+	})
+
+	if result.Panic != nil {
+		t.Errorf("Panic escaped the recover middleware: %v", result.Panic)
+	}
+	if result.Err == nil || result.Err.Error() != "broken handler" {
+		t.Errorf("Err = %v, expected broken handler", result.Err)
+	}
+}
+
+func TestRun_limiter(t *testing.T) {
+	tester := New()
+	limit := limiter.New(limiter.Config{Limits: 1, Window: time.Minute})
+	handler := func(c *volta.Ctx) error { return c.Ack(false) }
+
+	if result := tester.Run(NewMessage(nil).RoutingKey("orders"), limit, handler); !result.Acked() {
+		t.Errorf("first message is %q, expected it to be acknowledged", result.Settlement)
+	}
+
+	result := tester.Run(NewMessage(nil).RoutingKey("orders"), limit, handler)
+	if !result.Nacked() || !result.Requeue {
+		t.Errorf("second message is %q with requeue %v, expected it to be requeued", result.Settlement, result.Requeue)
+	}
+
+	result = tester.Run(NewMessage(nil).RoutingKey("orders").ReplyTo("replies"), limit, handler)
+	if !result.Replied() || string(result.Reply.Body) != `{"message":"Limit reached"}` {
+		t.Errorf("third message was not answered with the limit reply")
+	}
+}